@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// With adopt all A and AAAA records in a domain that point to the old address
+// are updated as well, without listing them all as records:
+//
+//   adopt example.com
+//
+// The old address is the last address that was published, or the address from
+// -adopt-from; for example to move all records from an old connection:
+//
+//   transip-dynamic -adopt-from 203.0.113.5
+//
+// The records are only adopted when the address changes (or with -adopt-from);
+// they're not stored in the config or state.
+
+// Set from the -adopt-from flag.
+var adoptFrom addrsFlag
+
+// oldAddrs gets the addresses to adopt records from.
+func oldAddrs() (ipT, error) {
+	if len(adoptFrom) == 0 {
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		return readState().LastIP.IP, nil
+	}
+
+	var ip ipT
+	for _, a := range adoptFrom {
+		addr := net.ParseIP(a)
+		switch {
+		case addr == nil:
+			return ipT{}, fmt.Errorf("-adopt-from: not a valid address: %q", a)
+		case addr.To4() != nil:
+			ip.IPv4 = addr.String()
+		default:
+			ip.IPv6 = addr.String()
+		}
+	}
+	return ip, nil
+}
+
+// adoptRecords sets the A and AAAA records in info that point to the old
+// address to ip, if domain is in Adopt; records are the records from the config,
+// which are already set. Only the family that points to the old address is
+// changed.
+func adoptRecords(domain string, records []string, info []Info, ip ipT) ([]Info, []planChange) {
+	if !inList(config.Adopt, domain) {
+		return info, nil
+	}
+	old, err := oldAddrs()
+	if err != nil || old == ip {
+		return info, nil
+	}
+
+	var changes []planChange
+	for i := range info {
+		if inList(records, info[i].FQDN) {
+			continue
+		}
+		var to string
+		switch {
+		case info[i].Type == "A" && old.IPv4 != "" && info[i].Content == old.IPv4 && config.UpdateIPv4:
+			to = ip.IPv4
+		case info[i].Type == "AAAA" && old.IPv6 != "" && info[i].Content == old.IPv6 && config.UpdateIPv6:
+			to = ip.IPv6
+		}
+		if to == "" || to == info[i].Content {
+			continue
+		}
+		debugf("adopting %v %v, as it points to the old address", toUnicode(info[i].FQDN), info[i].Type)
+		changes = append(changes, planChange{FQDN: info[i].FQDN, Type: info[i].Type, Old: info[i].Content, New: to})
+		info[i].Content = to
+	}
+	return info, changes
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The zone is written to a zone file in the StateDir before every update, as
+// the API always replaces the entire zone and a bug or a partial response could
+// remove records. The last Backups files are kept for every domain. To list
+// the backups for a domain and restore one:
+//
+//   transip-dynamic restore example.com
+//   transip-dynamic restore example.com 2024-01-02T15-04-05.000.zone
+
+var (
+	// The zones as they were last fetched from or sent to the API.
+	fetched   = make(map[string][]Info)
+	fetchedMu sync.Mutex
+)
+
+const backupTime = "2006-01-02T15-04-05.000"
+
+func backupDir(domain string) string { return filepath.Join(config.StateDir, "backups", domain) }
+
+// rememberZone stores the zone as it was fetched from the API, for the backup.
+func rememberZone(domain string, info []Info) {
+	fetchedMu.Lock()
+	defer fetchedMu.Unlock()
+	fetched[domain] = append([]Info(nil), info...)
+}
+
+// backupZone writes the zone as it is now to the backup directory. This is the
+// zone we last fetched or sent, and is only fetched if we have neither.
+func backupZone(ctx context.Context, domain string) error {
+	if config.Backups <= 0 || config.StateDir == "" || mockMode == "replay" {
+		return nil
+	}
+
+	fetchedMu.Lock()
+	info, ok := fetched[domain]
+	fetchedMu.Unlock()
+	if !ok {
+		zoneCacheMu.Lock()
+		z, ok := zoneCache[domain]
+		if !ok && !memoryCache {
+			z, ok = readCachedZone(domain)
+		}
+		zoneCacheMu.Unlock()
+		info = z.Entries
+		if !ok {
+			var err error
+			info, err = fetchDomain(ctx, domain)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	dir := backupDir(domain)
+	err := writeFileAtomic(filepath.Join(dir, time.Now().UTC().Format(backupTime)+".zone"),
+		zoneFile(domain, info, "backup before update"), 0600)
+	if err != nil {
+		return err
+	}
+
+	// Remove the oldest backups.
+	backups, err := listBackups(domain)
+	if err != nil {
+		return err
+	}
+	for len(backups) > int(config.Backups) {
+		os.Remove(filepath.Join(dir, backups[0]))
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// listBackups gets the backup files for domain, oldest first.
+func listBackups(domain string) ([]string, error) {
+	ls, err := ioutil.ReadDir(backupDir(domain))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []string
+	for _, f := range ls {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".zone") {
+			backups = append(backups, f.Name())
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func restore(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the changes")
+	pos, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 1 || len(pos) > 2 {
+		return fmt.Errorf("usage: %v restore DOMAIN [BACKUP] [-dry-run]", os.Args[0])
+	}
+	domain, _, err := splitRecord(pos[0])
+	if err != nil {
+		return err
+	}
+
+	if len(pos) == 1 {
+		backups, err := listBackups(domain)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no backups for %v in %v", toUnicode(domain), backupDir(domain))
+		}
+		for _, b := range backups {
+			fmt.Println(b)
+		}
+		return nil
+	}
+
+	path := pos[1]
+	if _, err := os.Stat(path); os.IsNotExist(err) && !strings.ContainsRune(path, os.PathSeparator) {
+		path = filepath.Join(backupDir(domain), path)
+	}
+	return replaceZone(ctx, domain, path, *dryRun)
+}
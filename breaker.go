@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// If the key or username is wrong every request fails, and TransIP may block
+// the IP if we keep sending them. After an authentication failure we wait
+// before trying again (a minute the first time, and twice as long after every
+// failure after that), and give up completely after AuthMaxFailures.
+//
+// This is stored in the state so it also works from cron; it's reset once a
+// request succeeds or if the key or username changes.
+//
+// If there's a SecondaryKeyFile we first try again with the other key, so keys
+// can be rotated without any failed updates: add the new key as the secondary,
+// remove the old one from the control panel, and it will switch on the next
+// update. The key we switched to is stored in the state.
+//
+// For the REST API a failed signed request for a token (/v6/auth), and 401 or
+// 403 responses, count as authentication failures. The token request is signed
+// with the key up front, so it's not tried again with the other key here.
+
+var (
+	activeKey *rsa.PrivateKey
+	keyMu     sync.Mutex
+)
+
+// signingKey gets the key to sign API requests with.
+func signingKey() *rsa.PrivateKey {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if activeKey == nil {
+		activeKey = config.key
+		if config.secondaryKey != nil {
+			stateMu.Lock()
+			st := readState()
+			stateMu.Unlock()
+			if st.ActiveKey == fingerprint(config.secondaryKey) {
+				activeKey = config.secondaryKey
+			}
+		}
+	}
+	return activeKey
+}
+
+// failover switches to the other key, if there is one.
+func failover(fault string) bool {
+	if signingKey() == nil || config.secondaryKey == nil {
+		return false
+	}
+
+	keyMu.Lock()
+	from, to := "key-file", "secondary-key-file"
+	if activeKey == config.secondaryKey {
+		from, to = to, from
+		activeKey = config.key
+	} else {
+		activeKey = config.secondaryKey
+	}
+	fp := fingerprint(activeKey)
+	keyMu.Unlock()
+	forgetRESTClient()
+
+	stateMu.Lock()
+	st := readState()
+	st.ActiveKey = fp
+	writeState(st)
+	stateMu.Unlock()
+
+	notify("switched API key",
+		fmt.Sprintf("the key from %v was rejected (%v); now using the key from %v", from, fault, to))
+	return true
+}
+
+// authState is the authentication failure state.
+type authState struct {
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error"`
+	Last      time.Time `json:"last"`
+
+	// Fingerprint of the user and key that failed.
+	Key string `json:"key"`
+}
+
+// keyFingerprint identifies the user and current key, so we know when they
+// changed.
+func keyFingerprint() string { return fingerprint(signingKey()) }
+
+func fingerprint(key *rsa.PrivateKey) string {
+	if key == nil {
+		return ""
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(append([]byte(config.User+"\x00"), pub...))
+	return hex.EncodeToString(h[:8])
+}
+
+// authBackoff is how long to wait after n failures.
+func authBackoff(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	d := time.Minute << uint(n-1)
+	if d > 24*time.Hour || d <= 0 {
+		d = 24 * time.Hour
+	}
+	return d
+}
+
+// authBreaker is a middleware which stops sending requests after
+// authentication failures.
+func authBreaker(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		_, soap := transip.CallFromContext(req.Context())
+		if !soap && !isREST(req) {
+			return next.RoundTrip(req)
+		}
+
+		stateMu.Lock()
+		a := readAPIFlags().Auth
+		stateMu.Unlock()
+
+		if a.Failures > 0 && a.Key == keyFingerprint() {
+			if config.AuthMaxFailures > 0 && int64(a.Failures) >= config.AuthMaxFailures {
+				return nil, fmt.Errorf("not sending API requests after %d authentication failures (last: %v); fix the user or key-file and try again",
+					a.Failures, a.LastError)
+			}
+			if until := a.Last.Add(authBackoff(a.Failures)); time.Now().Before(until) {
+				return nil, fmt.Errorf("%d authentication failures (last: %v); not trying again until %v",
+					a.Failures, a.LastError, until.Format(time.RFC3339))
+			}
+		}
+
+		var (
+			resp  *http.Response
+			err   error
+			fault string
+			r     = req
+		)
+		for i := 0; ; i++ {
+			resp, err = next.RoundTrip(r)
+			if err != nil {
+				return resp, err
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			fault = authFault(req, resp, body)
+
+			// Try again once with the other key.
+			if fault == "" || !soap || i > 0 || req.GetBody == nil || !failover(fault) {
+				break
+			}
+			r = req.Clone(req.Context())
+			r.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case fault != "":
+			authFailed(fault)
+		case resp.StatusCode < 300 && a.Failures > 0:
+			stateMu.Lock()
+			st := readState()
+			st.Auth = authState{}
+			writeState(st)
+			stateMu.Unlock()
+		}
+		return resp, nil
+	})
+}
+
+// isREST reports if req is for the REST API.
+func isREST(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/v6/")
+}
+
+// authFault gets the error if resp is an authentication failure, or "" if it's
+// not.
+func authFault(req *http.Request, resp *http.Response, body []byte) string {
+	if !isREST(req) {
+		if f := transip.ParseFault(body); f != nil && f.Auth() {
+			return f.Message
+		}
+		return ""
+	}
+
+	msg := resp.Status
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		msg = e.Error
+	}
+	switch {
+	case reReadOnly.MatchString(msg):
+		return ""
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return msg
+	case req.URL.Path == "/v6/auth" && resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusTooManyRequests:
+		return msg
+	}
+	return ""
+}
+
+// authFailed records an authentication failure.
+func authFailed(fault string) {
+	key := keyFingerprint()
+	stateMu.Lock()
+	st := readState()
+	if st.Auth.Key != key {
+		st.Auth = authState{Key: key}
+	}
+	st.Auth.Failures++
+	st.Auth.Last = time.Now()
+	st.Auth.LastError = fault
+	writeState(st)
+	stateMu.Unlock()
+
+	n := st.Auth.Failures
+	if config.AuthMaxFailures > 0 && int64(n) >= config.AuthMaxFailures {
+		notify("API authentication failing",
+			fmt.Sprintf("authentication with the TransIP API failed %d times (last: %v); no more requests will be sent until the user or key-file is changed",
+				n, fault))
+		return
+	}
+	warnf("authentication failed (%v); not trying again for %v",
+		fault, authBackoff(n))
+}
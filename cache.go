@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Zones are cached for CacheTTL, so that repeated runs don't need to fetch the
+// same zone again. The zone is also stored after every successful update, so
+// the cache always has what we last sent to the API.
+//
+// The cache is only for reading: the API replaces the entire zone, so anything
+// that writes it uses fetchDomain() to start from the current zone, rather
+// than overwriting changes made in the last CacheTTL.
+//
+// The daemon keeps the cache in memory; for other runs it's stored in the
+// StateDir.
+
+// cachedZone is a zone in the cache.
+type cachedZone struct {
+	Fetched time.Time `json:"fetched"`
+	Hash    string    `json:"hash"`
+	Entries []Info    `json:"entries"`
+}
+
+var (
+	zoneCache   = make(map[string]cachedZone)
+	zoneCacheMu sync.Mutex
+
+	// Keep the cache in memory only; set in daemon mode.
+	memoryCache bool
+)
+
+// getDomain gets a single domain from the cache, or from the API if it's not
+// cached or if the cached version is too old.
+func getDomain(ctx context.Context, name string) ([]Info, error) {
+	if z, ok := loadZone(name); ok {
+		return z, nil
+	}
+
+	info, err := fetchDomain(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	storeZone(name, info)
+	return info, nil
+}
+
+// loadZone gets a copy of the zone from the cache, if it's there and not
+// expired.
+func loadZone(name string) ([]Info, bool) {
+	if config.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	zoneCacheMu.Lock()
+	defer zoneCacheMu.Unlock()
+
+	z, ok := zoneCache[name]
+	if !ok && !memoryCache {
+		z, ok = readCachedZone(name)
+	}
+	if !ok || time.Since(z.Fetched) > config.CacheTTL {
+		return nil, false
+	}
+
+	// Never return the version in the cache, as callers are free to modify
+	// it.
+	return append([]Info(nil), z.Entries...), true
+}
+
+// storeZone stores a copy of the zone in the cache.
+func storeZone(name string, info []Info) {
+	if config.CacheTTL <= 0 {
+		return
+	}
+
+	info = append([]Info(nil), info...)
+	setFQDN(info, name)
+	z := cachedZone{Fetched: time.Now(), Hash: zoneHash(info), Entries: info}
+
+	zoneCacheMu.Lock()
+	defer zoneCacheMu.Unlock()
+	zoneCache[name] = z
+	if !memoryCache {
+		err := writeCachedZone(name, z)
+		if err != nil {
+			warnf("cannot write cache: %v", err)
+		}
+	}
+}
+
+// forgetZone removes the zone from the cache; this is done after errors, as we
+// no longer know what the state of the zone is.
+func forgetZone(name string) {
+	zoneCacheMu.Lock()
+	defer zoneCacheMu.Unlock()
+	delete(zoneCache, name)
+	if !memoryCache && config.StateDir != "" {
+		os.Remove(cachePath(name))
+	}
+}
+
+func cachePath(name string) string {
+	return filepath.Join(config.StateDir, "zones", name+".json")
+}
+
+func readCachedZone(name string) (cachedZone, bool) {
+	if config.StateDir == "" {
+		return cachedZone{}, false
+	}
+
+	data, err := ioutil.ReadFile(cachePath(name))
+	if err != nil {
+		return cachedZone{}, false
+	}
+
+	var z cachedZone
+	err = json.Unmarshal(data, &z)
+	if err != nil || z.Hash != zoneHash(z.Entries) {
+		warnf("ignoring corrupt cache for %v", name)
+		return cachedZone{}, false
+	}
+	return z, true
+}
+
+func writeCachedZone(name string, z cachedZone) error {
+	if config.StateDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(z)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cachePath(name), data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file first, and then renames it,
+// so that readers never see a partially written file. The directory is created
+// if it doesn't exist.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// With ConsensusOf the get-ip services are asked until at least that many agree
+// on the address, rather than just until there's an address:
+//
+//   get-ip icanhazip.com dns:cloudflare dns:opendns
+//   consensus-of 2
+//
+// This protects against a single broken or compromised service. A family for
+// which there's no consensus is skipped (with a warning), like a family that
+// none of the services have an address for.
+//
+// The services are asked until there's consensus for every family that's
+// updated in one of the records, so that a few IPv4-only services at the start
+// of the list don't mean IPv6 is never looked at.
+
+// ipConsensus gets the addresses that at least n of the services in hosts
+// agree on. The return values are the same as ipFromServices.
+func ipConsensus(ctx context.Context, hosts []string, n int64) (*ipT, bool, bool, error) {
+	var (
+		votes4         = make(map[string][]string)
+		votes6         = make(map[string][]string)
+		tried4, tried6 bool
+		errs           []string
+	)
+	agreed := func(votes map[string][]string) string {
+		for addr, from := range votes {
+			if int64(len(from)) >= n {
+				return addr
+			}
+		}
+		return ""
+	}
+
+	want4, want6 := wantFamilies()
+	for _, h := range hosts {
+		hip, t4, t6, err := ipFromHost(ctx, h)
+		if err != nil {
+			warnf("%v: %v", h, err)
+			errs = append(errs, fmt.Sprintf("%v: %v", h, err))
+			continue
+		}
+		tried4, tried6 = tried4 || t4, tried6 || t6
+		if hip.IPv4 != "" {
+			votes4[hip.IPv4] = append(votes4[hip.IPv4], h)
+		}
+		if hip.IPv6 != "" {
+			votes6[hip.IPv6] = append(votes6[hip.IPv6], h)
+		}
+		if (agreed(votes4) != "" || !want4) && (agreed(votes6) != "" || !want6) {
+			break
+		}
+	}
+	if len(errs) == len(hosts) {
+		return nil, false, false, errors.New(strings.Join(errs, "; "))
+	}
+
+	ip := &ipT{IPv4: agreed(votes4), IPv6: agreed(votes6)}
+	for _, f := range []struct {
+		name  string
+		addr  string
+		votes map[string][]string
+	}{
+		{"IPv4", ip.IPv4, votes4},
+		{"IPv6", ip.IPv6, votes6},
+	} {
+		if f.addr != "" || len(f.votes) == 0 {
+			continue
+		}
+		l := make([]string, 0, len(f.votes))
+		for addr, from := range f.votes {
+			l = append(l, fmt.Sprintf("%v from %v", addr, strings.Join(from, ", ")))
+		}
+		sort.Strings(l)
+		warnf("fewer than %d get-ip services agree on the %v address; not using it: %v",
+			n, f.name, strings.Join(l, "; "))
+	}
+	return ip, tried4, tried6, nil
+}
+
+// wantFamilies reports if any of the records are updated with an IPv4 or IPv6
+// address.
+func wantFamilies() (want4, want6 bool) {
+	for _, records := range config.Records {
+		for _, r := range records {
+			want4 = want4 || updatesType(r, "A")
+			want6 = want6 || updatesType(r, "AAAA")
+		}
+	}
+	return want4, want6
+}
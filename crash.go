@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Settings left out of crash reports.
+var secretSettings = map[string]bool{
+	"ControlToken": true,
+	"DyndnsUsers":  true,
+	"Push":         true,
+	"Notify":       true,
+	"OnChange":     true,
+	"HeartbeatURL": true,
+}
+
+// crashed writes a crash report for the panic r to the StateDir and sends a
+// notification. The returned error is used as the result of the run, so the
+// daemon keeps running.
+func crashed(r interface{}, stack []byte) error {
+	err := fmt.Errorf("panic: %v", r)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "transip-dynamic crashed at %v\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Version: %v (%v, %v/%v)\n", programVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Panic:   %v\n\n", r)
+
+	b.WriteString("Config:\n")
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" { // Unexported
+			continue
+		}
+		val := fmt.Sprintf("%v", v.Field(i).Interface())
+		if secretSettings[f.Name] && !v.Field(i).IsZero() {
+			val = "[redacted]"
+		}
+		fmt.Fprintf(&b, "    %-18v %v\n", f.Name, val)
+	}
+	fmt.Fprintf(&b, "\n%s", stack)
+
+	path := filepath.Join(config.StateDir, "crash-"+time.Now().Format("20060102-150405")+".txt")
+	wErr := writeFileAtomic(path, []byte(b.String()), 0600)
+	if wErr != nil {
+		warnf("cannot write crash report: %v\n%s", wErr, b.String())
+		path = "stderr"
+	}
+
+	notify("transip-dynamic crashed", fmt.Sprintf("%v; the crash report was written to %v", err, path))
+	return err
+}
+
+// programVersion gets the version from the build information.
+func programVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(unknown)"
+	}
+	return info.Main.Version
+}
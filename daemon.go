@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// statusT is the status of the daemon.
+type statusT struct {
+	Started   time.Time `json:"started"`
+	LastRun   time.Time `json:"last_run"`
+	NextRun   time.Time `json:"next_run"`
+	LastError string    `json:"last_error"`
+	Runs      int       `json:"runs"`
+	IP        *ipT      `json:"ip"`
+	IPChanges int       `json:"ip_changes"`
+	API       apiStatsT `json:"api"`
+
+	// Only in monitor mode.
+	Monitor bool `json:"monitor"`
+	Drifted int  `json:"drifted"`
+}
+
+var (
+	status   statusT
+	statusMu sync.Mutex
+
+	// Make sure only one update runs at the same time.
+	runMu sync.Mutex
+
+	// The config is only changed by reloadConfig, which holds runMu and
+	// configMu. The update holds runMu, so it can read the config as it likes;
+	// everything else that reads it from another goroutine (the HTTP
+	// handlers, the domain check, the netlink watcher) holds a read lock on
+	// configMu. Don't call runUpdate with the read lock, as that deadlocks
+	// with a reload.
+	configMu sync.RWMutex
+
+	// Set from the -pprof flag.
+	enablePprof bool
+
+	// Set from the -watch-netlink flag.
+	netlinkWatch bool
+
+	// Cancelled on SIGTERM or SIGINT. This is the context for the updates, so
+	// the fetches and the get-ip and DNS requests that are running are
+	// cancelled, and updateDomains doesn't start any new domains. Writes that
+	// already started aren't cancelled; see sendUpdate.
+	stopCtx, stopDaemon = context.WithCancel(context.Background())
+	errStopped          = errors.New("the daemon is stopping")
+)
+
+// runDaemon updates every interval until it gets SIGTERM or SIGINT, and reloads
+// the config from path on SIGHUP.
+func runDaemon(path string) error {
+	memoryCache, daemonMode = true, true
+
+	if config.Interval < time.Minute {
+		return fmt.Errorf("interval %v is too short; needs to be at least a minute",
+			config.Interval)
+	}
+
+	if enablePprof && config.ControlListen == "" {
+		return errors.New("-pprof needs control-listen to be set")
+	}
+	if config.ControlListen != "" {
+		if config.ControlToken == "" {
+			return errors.New("control-listen is set but control-token isn't")
+		}
+		if enablePprof && !isLoopback(config.ControlListen) {
+			return fmt.Errorf("-pprof is only allowed if control-listen is on localhost, not %q",
+				config.ControlListen)
+		}
+		go func() {
+			err := serveControl()
+			fatal(fmt.Errorf("control API: %v", err))
+		}()
+	}
+
+	if config.MetricsListen != "" {
+		go func() {
+			err := serveMetrics()
+			fatal(fmt.Errorf("metrics: %v", err))
+		}()
+	}
+
+	if config.GrpcListen != "" {
+		go func() {
+			err := serveGRPC()
+			fatal(fmt.Errorf("gRPC: %v", err))
+		}()
+	}
+
+	if config.DomainCheckInterval > 0 {
+		go checkDomainsLoop()
+	}
+
+	statusMu.Lock()
+	status.Started = time.Now()
+	status.Monitor = monitorOnly
+	statusMu.Unlock()
+
+	var netlinkChanged chan struct{}
+	if netlinkWatch {
+		netlinkChanged = make(chan struct{}, 1)
+		err := watchNetlink(netlinkChanged)
+		if err != nil {
+			return err
+		}
+	}
+
+	go handleSignals(path)
+	go sdWatchdog()
+	for first := true; ; first = false {
+		err := runUpdate()
+		if err != nil {
+			warnf("%v", err)
+		}
+		if first {
+			sdNotify("READY=1")
+		}
+		statusMu.Lock()
+		next := status.NextRun
+		statusMu.Unlock()
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-t.C:
+		case <-netlinkChanged:
+			t.Stop()
+			infof("network changed; updating now")
+		case <-stopCtx.Done():
+			t.Stop()
+			// Wait for an update from the control API to finish.
+			runMu.Lock()
+			infof("stopped")
+			return nil
+		}
+	}
+}
+
+// handleSignals reloads the config on SIGHUP, and stops the daemon on SIGTERM
+// and SIGINT. The update that's running finishes the writes it started, but
+// its other requests are cancelled, and the domains it didn't update are
+// queued.
+func handleSignals(path string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			infof("got %v; stopping", sig)
+			sdNotify("STOPPING=1")
+			signal.Stop(sigs)
+			stopDaemon()
+			return
+		}
+
+		sdNotify("RELOADING=1")
+		err := reloadConfig(path)
+		sdNotify("READY=1")
+		if err != nil {
+			warnf("not reloading the config: %v", err)
+			continue
+		}
+		infof("reloaded the config")
+	}
+}
+
+// reloadConfig reads the config and key again. The old config is kept if
+// there's an error. The listen addresses for the control API, metrics, and
+// gRPC can't be changed this way.
+//
+// Readers hold configMu (or runMu) for as long as they use the config, so the
+// new config replaces the old one in one step for them.
+func reloadConfig(path string) error {
+	runMu.Lock()
+	defer runMu.Unlock()
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	old := config
+	config = configT{}
+	err := parseConfig(path)
+	if err == nil && config.Interval < time.Minute {
+		err = fmt.Errorf("interval %v is too short; needs to be at least a minute", config.Interval)
+	}
+	if err != nil {
+		config = old
+		return err
+	}
+
+	keyMu.Lock()
+	activeKey = nil
+	keyMu.Unlock()
+	forgetRESTClient()
+
+	// The state may be in another StateDir now.
+	stateMu.Lock()
+	apiFlagsCache = nil
+	stateMu.Unlock()
+
+	statusMu.Lock()
+	if !status.LastRun.IsZero() {
+		status.NextRun = status.LastRun.Add(config.Interval)
+	}
+	statusMu.Unlock()
+	return nil
+}
+
+// configLocked is a middleware which holds a read lock on the config for the
+// request; see configMu.
+func configLocked(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configMu.RLock()
+		defer configMu.RUnlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runUpdate runs an update (or a check in monitor mode) and records the result
+// in the status. Panics are recovered and written to a crash report.
+func runUpdate() error {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	sdRunning(true)
+	defer sdRunning(false)
+
+	startReport()
+	ip, err := func() (ip *ipT, err error) {
+		// Keep running if there's a bug somewhere.
+		defer func() {
+			if r := recover(); r != nil {
+				err = crashed(r, debug.Stack())
+			}
+		}()
+
+		if monitorOnly {
+			statusMu.Lock()
+			prev := status.IP
+			statusMu.Unlock()
+			return monitor(stopCtx, prev)
+		}
+		return update(stopCtx)
+	}()
+	writeStatusFile(ip, err)
+	writeReport(ip, err)
+	pingHeartbeat(stopCtx, err)
+	sdNotify(sdStatus(ip, err))
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Runs++
+	status.LastRun = time.Now()
+	status.NextRun = status.LastRun.Add(config.Interval)
+	status.LastError = ""
+	status.API = getAPIStats()
+	status.Drifted = len(monitorDrifts)
+	if ip != nil {
+		if status.IP != nil && *status.IP != *ip {
+			status.IPChanges++
+		}
+		status.IP = ip
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	return err
+}
+
+// serveControl serves the local control API so that other programs can trigger
+// an update or get the status.
+func serveControl() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := runUpdate()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.Handle("/records", configLocked(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := configuredRecords(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})))
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	configMu.RLock()
+	l, err := sdListen("control", config.ControlListen)
+	h := controlAuth(mux)
+	configMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return http.Serve(l, h)
+}
+
+// isLoopback reports if the listen address is only reachable from localhost.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// controlAuth requires the control-token as a bearer token.
+func controlAuth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + config.ControlToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// configuredRecords gets the current A and AAAA records for all records in the
+// config from the API.
+func configuredRecords(ctx context.Context) ([]Info, error) {
+	var records []Info
+	for _, domain := range sortedDomains() {
+		info, err := getDomain(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get domain %v: %v", domain, err)
+		}
+		for _, i := range info {
+			if (i.Type == "A" || i.Type == "AAAA") && inList(config.Records[domain], i.FQDN) {
+				records = append(records, i)
+			}
+		}
+	}
+	return records, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// diff shows the differences between what the config wants, what's stored in
+// TransIP, and what the authoritative nameservers serve for all records.
+func diff() error {
+	ctx := context.Background()
+	ip, err := getIP(ctx)
+	if err != nil {
+		return err
+	}
+
+	mismatch := 0
+	for _, domain := range sortedDomains() {
+		info, err := fetchDomain(ctx, domain)
+		if err != nil {
+			return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+		}
+		ns, err := nameservers(ctx, domain)
+		if err != nil {
+			warnf("cannot get nameservers for %v: %v", domain, err)
+		}
+
+		for _, record := range config.Records[domain] {
+			rip, ok := ipForRecord(record, *ip)
+			if !ok {
+				continue
+			}
+			for _, t := range []string{"A", "AAAA"} {
+				if !updatesType(record, t) {
+					continue
+				}
+				want := rip.IPv4
+				qtype := uint16(typeA)
+				if t == "AAAA" {
+					want, qtype = rip.IPv6, typeAAAA
+				}
+
+				var stored []string
+				for _, i := range info {
+					if i.FQDN == record && i.Type == t {
+						stored = append(stored, i.Content)
+					}
+				}
+				if want == "" && len(stored) == 0 {
+					continue
+				}
+
+				lines := [][2]string{
+					{"config", orNone(want)},
+					{"TransIP", orNone(strings.Join(stored, ", "))},
+				}
+				for _, n := range ns {
+					lines = append(lines, [2]string{n, serves(ctx, n, record, qtype)})
+				}
+
+				fmt.Printf("%v %v\n", toUnicode(record), t)
+				for _, l := range lines {
+					mark := " "
+					if want != "" && l[1] != want {
+						mark = "!"
+						mismatch++
+					}
+					fmt.Printf("  %v %-24v %v\n", mark, l[0], l[1])
+				}
+			}
+		}
+	}
+
+	if mismatch > 0 {
+		return fmt.Errorf("%d mismatches (marked with !)", mismatch)
+	}
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// nameservers gets the authoritative nameservers for domain.
+func nameservers(ctx context.Context, domain string) ([]string, error) {
+	var ns []string
+	if config.Resolver == "" {
+		nss, err := net.LookupNS(domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nss {
+			ns = append(ns, n.Host)
+		}
+	} else {
+		rrs, err := dnsQuery(ctx, config.Resolver, domain, typeNS, classIN, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			if rr.Type == typeNS {
+				ns = append(ns, rr.Value)
+			}
+		}
+	}
+	sort.Strings(ns)
+	return ns, nil
+}
+
+// serves gets what the nameserver serves for the record.
+func serves(ctx context.Context, ns, record string, qtype uint16) string {
+	rrs, err := dnsQuery(ctx, strings.TrimSuffix(ns, "."), record, qtype, classIN, false)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	var vals []string
+	for _, rr := range rrs {
+		if rr.Type == qtype {
+			vals = append(vals, rr.Value)
+		}
+	}
+	sort.Strings(vals)
+	return orNone(strings.Join(vals, ", "))
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A small DNS client, so we can send queries over DNS-over-HTTPS or to a
+// specific server, which the net package doesn't allow.
+
+// DNS record types and classes.
+const (
+	typeA     = 1
+	typeNS    = 2
+	typeCNAME = 5
+	typeSOA   = 6
+	typeTXT   = 16
+	typeAAAA  = 28
+
+	classIN = 1
+	classCH = 3
+)
+
+// dnsRR is a resource record from a DNS response.
+type dnsRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+
+	// Address for A and AAAA, name for NS and CNAME, and the text for TXT
+	// records (with multiple strings joined). Empty for other types.
+	Value string
+}
+
+// lookupHost looks up all addresses for host, using the configured Resolver.
+func lookupHost(ctx context.Context, host string) ([]string, error) {
+	if config.Resolver == "" {
+		return net.DefaultResolver.LookupHost(ctx, host)
+	}
+
+	var addrs []string
+	for _, t := range []uint16{typeA, typeAAAA} {
+		rrs, err := dnsQuery(ctx, config.Resolver, host, t, classIN, true)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %v: %v", host, err)
+		}
+		for _, rr := range rrs {
+			if rr.Type == t {
+				addrs = append(addrs, rr.Value)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("lookup %v: no addresses", host)
+	}
+	return addrs, nil
+}
+
+// dnsQuery sends a query to server and returns the answers. The server is
+// either a DNS-over-HTTPS URL or host:port; port 53 is used if there is no
+// port.
+func dnsQuery(ctx context.Context, server, name string, qtype, qclass uint16, recurse bool) ([]dnsRR, error) {
+	msg, id, err := dnsMsg(name, qtype, qclass, recurse)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []byte
+	if strings.HasPrefix(server, "https://") {
+		// RFC 8484 recommends an ID of 0 for caching.
+		binary.BigEndian.PutUint16(msg, 0)
+		id = 0
+		resp, err = dohExchange(ctx, server, msg)
+	} else {
+		resp, err = dnsExchange(ctx, server, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dnsParse(resp, id)
+}
+
+// dnsMsg builds a single query message.
+func dnsMsg(name string, qtype, qclass uint16, recurse bool) ([]byte, uint16, error) {
+	b := make([]byte, 2)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(b)
+
+	var flags uint16
+	if recurse {
+		flags = 1 << 8
+	}
+
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+
+	for _, l := range strings.Split(strings.TrimRight(name, "."), ".") {
+		if len(l) == 0 || len(l) > 63 {
+			return nil, 0, fmt.Errorf("invalid name %q", name)
+		}
+		msg = append(msg, byte(len(l)))
+		msg = append(msg, l...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), byte(qclass>>8), byte(qclass))
+	return msg, id, nil
+}
+
+// dohExchange sends the message to a DNS-over-HTTPS server.
+func dohExchange(ctx context.Context, url string, msg []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := httpClient(config.HTTPTimeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server %v: %v", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+// dnsExchange sends the message over UDP, retrying with TCP if the response is
+// truncated.
+func dnsExchange(ctx context.Context, server string, msg []byte) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 4096)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp = resp[:n]
+	if len(resp) < 4 || resp[2]&0x02 == 0 {
+		return resp, nil
+	}
+
+	// Truncated; try again over TCP.
+	tconn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer tconn.Close()
+	defer closeOnDone(ctx, tconn)()
+	tconn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = tconn.Write(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...))
+	if err != nil {
+		return nil, err
+	}
+	l := make([]byte, 2)
+	_, err = io.ReadFull(tconn, l)
+	if err != nil {
+		return nil, err
+	}
+	resp = make([]byte, binary.BigEndian.Uint16(l))
+	_, err = io.ReadFull(tconn, resp)
+	return resp, err
+}
+
+// closeOnDone closes c if ctx is cancelled before the returned function is
+// called, so that a read or write on it doesn't block until the deadline.
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+var (
+	errShortMsg = errors.New("DNS response is too short")
+	errNoHost   = errors.New("no such host")
+)
+
+// dnsParse parses the answer section from a DNS response.
+func dnsParse(msg []byte, id uint16) ([]dnsRR, error) {
+	if len(msg) < 12 {
+		return nil, errShortMsg
+	}
+	if binary.BigEndian.Uint16(msg) != id {
+		return nil, errors.New("DNS response has the wrong ID")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		switch rcode {
+		case 2:
+			return nil, errors.New("server failure")
+		case 3:
+			return nil, errNoHost
+		case 5:
+			return nil, errors.New("query refused")
+		default:
+			return nil, fmt.Errorf("DNS error code %d", rcode)
+		}
+	}
+
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	an := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, n, err := dnsName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n + 4
+	}
+
+	rrs := make([]dnsRR, 0, an)
+	for i := 0; i < an; i++ {
+		name, n, err := dnsName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+10 > len(msg) {
+			return nil, errShortMsg
+		}
+
+		rr := dnsRR{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[off:]),
+			Class: binary.BigEndian.Uint16(msg[off+2:]),
+			TTL:   binary.BigEndian.Uint32(msg[off+4:]),
+		}
+		l := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+l > len(msg) {
+			return nil, errShortMsg
+		}
+		data := msg[off : off+l]
+
+		switch rr.Type {
+		case typeA, typeAAAA:
+			if len(data) == 4 || len(data) == 16 {
+				rr.Value = net.IP(data).String()
+			}
+		case typeNS, typeCNAME:
+			rr.Value, _, err = dnsName(msg, off)
+			if err != nil {
+				return nil, err
+			}
+		case typeTXT:
+			var txt strings.Builder
+			for j := 0; j < len(data); {
+				sl := int(data[j])
+				if j+1+sl > len(data) {
+					return nil, errShortMsg
+				}
+				txt.Write(data[j+1 : j+1+sl])
+				j += 1 + sl
+			}
+			rr.Value = txt.String()
+		}
+
+		off += l
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// dnsName reads a (possibly compressed) name at off, and returns the name and
+// the offset right after it.
+func dnsName(msg []byte, off int) (string, int, error) {
+	var (
+		labels []string
+		end    = -1
+		jumps  = 0
+	)
+	for {
+		if off >= len(msg) {
+			return "", 0, errShortMsg
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end == -1 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMsg
+			}
+			if end == -1 {
+				end = off + 2
+			}
+			jumps++
+			if jumps > 64 {
+				return "", 0, errors.New("DNS response has a compression loop")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errShortMsg
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
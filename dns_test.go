@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsResp builds a response with one question for name and the answers in rrs;
+// a pointer to the question name (0xc00c) can be used as the name in an RR.
+func dnsResp(id uint16, rcode byte, name string, qtype, qclass uint16, rrs ...[]byte) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg, id)
+	msg[2] = 0x80 // QR
+	msg[3] = rcode
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(rrs)))
+
+	msg = append(msg, dnsLabels(name)...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, qclass)
+	for _, rr := range rrs {
+		msg = append(msg, rr...)
+	}
+	return msg
+}
+
+// dnsAnswer builds an RR pointing to the question name.
+func dnsAnswer(typ, class uint16, ttl uint32, data []byte) []byte {
+	rr := []byte{0xc0, 0x0c}
+	rr = binary.BigEndian.AppendUint16(rr, typ)
+	rr = binary.BigEndian.AppendUint16(rr, class)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data)))
+	return append(rr, data...)
+}
+
+// dnsLabels encodes a name without compression.
+func dnsLabels(name string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if l != "" {
+			b = append(b, byte(len(l)))
+			b = append(b, l...)
+		}
+	}
+	return append(b, 0)
+}
+
+// dnsStrings encodes TXT data.
+func dnsStrings(s ...string) []byte {
+	var b []byte
+	for _, ss := range s {
+		b = append(b, byte(len(ss)))
+		b = append(b, ss...)
+	}
+	return b
+}
+
+func TestDNSName(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     []byte
+		off     int
+		want    string
+		wantEnd int
+		wantErr string
+	}{
+		{"root", []byte{0}, 0, ".", 1, ""},
+		{"labels", dnsLabels("www.example.com"), 0, "www.example.com.", 17, ""},
+		{"offset", append([]byte{0xff, 0xff}, dnsLabels("a.b")...), 2, "a.b.", 7, ""},
+		{"pointer", append(dnsLabels("example.com"), 3, 'w', 'w', 'w', 0xc0, 0x00), 13, "www.example.com.", 19, ""},
+		{"pointer to pointer", append(append(dnsLabels("com"), 0xc0, 0x00), 1, 'a', 0xc0, 0x05), 7, "a.com.", 11, ""},
+		{"only a pointer", append(dnsLabels("com"), 0xc0, 0x00), 5, "com.", 7, ""},
+
+		{"empty", nil, 0, "", 0, errShortMsg.Error()},
+		{"past the end", []byte{0}, 1, "", 0, errShortMsg.Error()},
+		{"no end", []byte{1, 'a'}, 0, "", 0, errShortMsg.Error()},
+		{"label past the end", []byte{5, 'a', 'b'}, 0, "", 0, errShortMsg.Error()},
+		{"truncated pointer", []byte{1, 'a', 0xc0}, 0, "", 0, errShortMsg.Error()},
+		{"pointer past the end", []byte{1, 'a', 0xc0, 0xff}, 0, "", 0, errShortMsg.Error()},
+		{"pointer to itself", []byte{0xc0, 0x00}, 0, "", 0, "compression loop"},
+		{"pointer loop", []byte{1, 'a', 0xc0, 0x04, 1, 'b', 0xc0, 0x00}, 0, "", 0, "compression loop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, end, err := dnsName(tt.msg, tt.off)
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if have != tt.want || end != tt.wantEnd {
+				t.Errorf("\nhave: %q %d\nwant: %q %d", have, end, tt.want, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestDNSParse(t *testing.T) {
+	a := dnsAnswer(typeA, classIN, 60, []byte{192, 0, 2, 1})
+	good := dnsResp(42, 0, "example.com", typeA, classIN, a)
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    []dnsRR
+		wantErr string
+	}{
+		{"A", good, []dnsRR{{"example.com.", typeA, classIN, 60, "192.0.2.1"}}, ""},
+		{"AAAA", dnsResp(42, 0, "example.com", typeAAAA, classIN,
+			dnsAnswer(typeAAAA, classIN, 60, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})),
+			[]dnsRR{{"example.com.", typeAAAA, classIN, 60, "2001:db8::1"}}, ""},
+		{"TXT", dnsResp(42, 0, "example.com", typeTXT, classIN,
+			dnsAnswer(typeTXT, classIN, 60, dnsStrings("v=spf1 ", "-all")), dnsAnswer(typeTXT, classIN, 60, nil)),
+			[]dnsRR{{"example.com.", typeTXT, classIN, 60, "v=spf1 -all"}, {"example.com.", typeTXT, classIN, 60, ""}}, ""},
+		{"CNAME", dnsResp(42, 0, "www.example.com", typeA, classIN,
+			dnsAnswer(typeCNAME, classIN, 300, []byte{0xc0, 0x10}), a),
+			[]dnsRR{{"www.example.com.", typeCNAME, classIN, 300, "example.com."}, {"www.example.com.", typeA, classIN, 60, "192.0.2.1"}}, ""},
+		{"not an address", dnsResp(42, 0, "example.com", typeA, classIN, dnsAnswer(typeA, classIN, 60, []byte{1, 2, 3})),
+			[]dnsRR{{"example.com.", typeA, classIN, 60, ""}}, ""},
+		{"other type", dnsResp(42, 0, "example.com", typeSOA, classIN, dnsAnswer(typeSOA, classIN, 60, []byte{0, 0, 1, 2})),
+			[]dnsRR{{"example.com.", typeSOA, classIN, 60, ""}}, ""},
+		{"no answers", dnsResp(42, 0, "example.com", typeA, classIN), []dnsRR{}, ""},
+
+		{"short", good[:11], nil, errShortMsg.Error()},
+		{"wrong ID", dnsResp(43, 0, "example.com", typeA, classIN, a), nil, "wrong ID"},
+		{"NXDOMAIN", dnsResp(42, 3, "example.com", typeA, classIN), nil, errNoHost.Error()},
+		{"SERVFAIL", dnsResp(42, 2, "example.com", typeA, classIN), nil, "server failure"},
+		{"REFUSED", dnsResp(42, 5, "example.com", typeA, classIN), nil, "query refused"},
+		{"other rcode", dnsResp(42, 4, "example.com", typeA, classIN), nil, "DNS error code 4"},
+		{"truncated question", good[:20], nil, errShortMsg.Error()},
+		{"truncated RR name", good[:len(good)-len(a)+1], nil, errShortMsg.Error()},
+		{"truncated RR header", good[:len(good)-len(a)+8], nil, errShortMsg.Error()},
+		{"truncated RR data", good[:len(good)-1], nil, errShortMsg.Error()},
+		{"missing RR", good[:len(good)-len(a)], nil, errShortMsg.Error()},
+		{"truncated TXT string", dnsResp(42, 0, "example.com", typeTXT, classIN,
+			dnsAnswer(typeTXT, classIN, 60, []byte{5, 'a', 'b'})), nil, errShortMsg.Error()},
+		{"CNAME past the end", dnsResp(42, 0, "example.com", typeA, classIN,
+			dnsAnswer(typeCNAME, classIN, 60, []byte{0xc0, 0xff})), nil, errShortMsg.Error()},
+		{"CNAME loop", dnsResp(42, 0, "example.com", typeA, classIN,
+			dnsAnswer(typeCNAME, classIN, 60, []byte{0xc0, byte(len(good) - len(a) + 12)})), nil, "compression loop"},
+		{"answer name loop", append(dnsResp(42, 0, "example.com", typeA, classIN, a)[:len(good)-len(a)],
+			0xc0, byte(len(good)-len(a))), nil, "compression loop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := dnsParse(tt.msg, 42)
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %#v\nwant: %#v", have, tt.want)
+			}
+		})
+	}
+}
+
+// dohServer starts a DNS-over-HTTPS server which responds with answer for
+// every query.
+func dohServer(t *testing.T, answer func(q []byte) []byte) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			t.Errorf("wrong request: %v %v", r.Method, r.Header)
+		}
+		q, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		resp := answer(q)
+		if resp == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	config = configT{HTTPTimeout: 5 * time.Second, transport: srv.Client().Transport}
+	return srv
+}
+
+func TestDoH(t *testing.T) {
+	var query []byte
+	srv := dohServer(t, func(q []byte) []byte {
+		query = q
+		if binary.BigEndian.Uint16(q) != 0 {
+			t.Errorf("ID is not 0: %x", q[:2])
+		}
+		return dnsResp(0, 0, "example.com", typeA, classIN, dnsAnswer(typeA, classIN, 60, []byte{192, 0, 2, 1}))
+	})
+
+	rrs, err := dnsQuery(context.Background(), srv.URL, "example.com", typeA, classIN, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dnsRR{{"example.com.", typeA, classIN, 60, "192.0.2.1"}}
+	if !reflect.DeepEqual(rrs, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", rrs, want)
+	}
+
+	wantQ := dnsResp(0, 0, "example.com", typeA, classIN)
+	wantQ[2], wantQ[3] = 0x01, 0 // Just RD.
+	if string(query) != string(wantQ) {
+		t.Errorf("wrong query\nhave: %x\nwant: %x", query, wantQ)
+	}
+
+	t.Run("error status", func(t *testing.T) {
+		srv := dohServer(t, func([]byte) []byte { return nil })
+		_, err := dohExchange(context.Background(), srv.URL, []byte{1})
+		if !errorContains(err, "400 Bad Request") {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
+	t.Run("wrong ID", func(t *testing.T) {
+		srv := dohServer(t, func(q []byte) []byte { return dnsResp(1, 0, "example.com", typeA, classIN) })
+		_, err := dnsQuery(context.Background(), srv.URL, "example.com", typeA, classIN, true)
+		if !errorContains(err, "wrong ID") {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		srv := dohServer(t, func([]byte) []byte { time.Sleep(200 * time.Millisecond); return nil })
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := dohExchange(ctx, srv.URL, []byte{1})
+		if !errorContains(err, "context deadline exceeded") {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// In daemon mode all domains are checked every DomainCheckInterval: the
+// registration is fetched (which sends a notification if it's close to the
+// renewal date, see checkExpiry), and the delegation and DNSSEC are checked.
+// Notifications are only sent when a problem starts or is resolved.
+
+// Extra DNS record types for the DNSSEC check.
+const (
+	typeDS     = 43
+	typeDNSKEY = 48
+)
+
+func checkDomainsLoop() {
+	problems := make(map[string]string)
+	for {
+		configMu.RLock()
+		for _, domain := range sortedDomains() {
+			checkDomain(stopCtx, domain, problems)
+		}
+		wait := config.DomainCheckInterval
+		configMu.RUnlock()
+		time.Sleep(wait)
+	}
+}
+
+// checkDomain checks domain, and sends a notification if the result is
+// different from what's in problems.
+func checkDomain(ctx context.Context, domain string, problems map[string]string) {
+	_, err := fetchDomain(ctx, domain)
+	if err != nil {
+		warnf("cannot check domain %v: %v", domain, err)
+	}
+
+	problem, ok := delegationProblems(ctx, domain)
+	if !ok {
+		return
+	}
+	p := strings.Join(problem, "; ")
+	switch prev := problems[domain]; {
+	case p == prev:
+	case p == "":
+		notify("delegation fixed", fmt.Sprintf("the delegation of %v works again", domain))
+	default:
+		notify("broken delegation", fmt.Sprintf("%v: %v", domain, p))
+	}
+	problems[domain] = p
+}
+
+// delegationProblems checks that every nameserver the parent zone delegates to
+// answers for the domain, and that there's a DNSKEY if the parent has a DS
+// record. It returns false if it can't be checked as the resolver doesn't work.
+func delegationProblems(ctx context.Context, domain string) ([]string, bool) {
+	resolver := config.Resolver
+	if resolver == "" {
+		resolver = config.DriftResolvers[0]
+	}
+
+	rrs, err := dnsQuery(ctx, resolver, domain, typeNS, classIN, true)
+	if err != nil {
+		// Can't tell anything if the resolver doesn't work.
+		if err != errNoHost {
+			warnf("cannot check delegation of %v: %v", domain, err)
+			return nil, false
+		}
+		return []string{"domain doesn't exist according to " + resolver}, true
+	}
+	var ns []string
+	for _, rr := range rrs {
+		if rr.Type == typeNS {
+			ns = append(ns, strings.TrimSuffix(rr.Value, "."))
+		}
+	}
+	sort.Strings(ns)
+	if len(ns) == 0 {
+		return []string{"no NS records"}, true
+	}
+
+	var problems []string
+	hasKey := false
+	for _, n := range ns {
+		rrs, err := dnsQuery(ctx, n, domain, typeSOA, classIN, false)
+		if err == nil && !hasType(rrs, typeSOA) {
+			err = fmt.Errorf("no SOA record")
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("nameserver %v: %v", n, err))
+			continue
+		}
+		if !hasKey {
+			rrs, err = dnsQuery(ctx, n, domain, typeDNSKEY, classIN, false)
+			hasKey = err == nil && hasType(rrs, typeDNSKEY)
+		}
+	}
+
+	rrs, err = dnsQuery(ctx, resolver, domain, typeDS, classIN, true)
+	if err == nil && hasType(rrs, typeDS) && !hasKey {
+		problems = append(problems, "DNSSEC: there is a DS record but the nameservers don't serve a DNSKEY")
+	}
+	return problems, true
+}
+
+func hasType(rrs []dnsRR, t uint16) bool {
+	for _, rr := range rrs {
+		if rr.Type == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Drift mode ("transip-dynamic drift") periodically asks public resolvers what
+// they serve for the records we published, and sends a notification if it's
+// different for longer than the TTL: longer than that it's no longer caching,
+// but someone edited the record manually or another updater is fighting us.
+
+// drift is a record that's currently different from what we published.
+type drift struct {
+	Since    time.Time
+	Notified bool
+}
+
+func runDrift() error {
+	ctx := context.Background()
+	drifts := make(map[string]*drift)
+	for {
+		checkDrift(ctx, drifts, publishedRecords(), time.Now())
+		time.Sleep(config.Interval)
+	}
+}
+
+// publishedRecords gets the published records from the state which are still
+// in the config.
+func publishedRecords() []published {
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+
+	keys := make([]string, 0, len(st.Published))
+	for k, p := range st.Published {
+		domain, _, err := splitRecord(p.FQDN)
+		if err != nil || !inList(config.Records[domain], p.FQDN) {
+			continue // Removed from the config.
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	recs := make([]published, 0, len(keys))
+	for _, k := range keys {
+		recs = append(recs, st.Published[k])
+	}
+	return recs
+}
+
+// checkDrift compares the records against the DriftResolvers; drifts is
+// updated with the records that are currently different.
+func checkDrift(ctx context.Context, drifts map[string]*drift, recs []published, now time.Time) {
+	for _, p := range recs {
+		k := p.FQDN + " " + p.Type
+		got := driftCheck(ctx, p)
+
+		d, ok := drifts[k]
+		if got == "" {
+			if ok && d.Notified {
+				audit("drift-resolved", "%v %v is %v again", p.FQDN, p.Type, p.Content)
+				notify("DNS drift resolved",
+					fmt.Sprintf("%v %v is %v again", p.FQDN, p.Type, p.Content))
+			}
+			delete(drifts, k)
+			continue
+		}
+
+		if !ok {
+			d = &drift{Since: now}
+			drifts[k] = d
+		}
+		if !d.Notified && now.Sub(d.Since) > time.Duration(p.TTL)*time.Second {
+			d.Notified = true
+			msg := fmt.Sprintf("%v %v should be %v, but %v since %v",
+				p.FQDN, p.Type, p.Content, got, d.Since.Format(time.RFC3339))
+			audit("drift", "%v", msg)
+			notify("DNS drift", msg)
+		}
+	}
+}
+
+// driftCheck queries all resolvers for the record, and returns a description
+// of what's different, or an empty string if everything matches.
+//
+// Resolvers that can't be reached are skipped with a warning, as that doesn't
+// tell us anything about the record.
+func driftCheck(ctx context.Context, p published) string {
+	qtype := uint16(typeA)
+	if p.Type == "AAAA" {
+		qtype = typeAAAA
+	}
+
+	var diff []string
+	for _, r := range config.DriftResolvers {
+		rrs, err := dnsQuery(ctx, r, p.FQDN, qtype, classIN, true)
+		if err != nil && err != errNoHost {
+			warnf("cannot query %v for %v: %v",
+				r, p.FQDN, err)
+			continue
+		}
+
+		var vals []string
+		for _, rr := range rrs {
+			if rr.Type == qtype {
+				vals = append(vals, rr.Value)
+			}
+		}
+		if len(vals) != 1 || vals[0] != p.Content {
+			sort.Strings(vals)
+			v := strings.Join(vals, ", ")
+			if v == "" {
+				v = "nothing"
+			}
+			diff = append(diff, fmt.Sprintf("%v serves %v", r, v))
+		}
+	}
+	return strings.Join(diff, "; ")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// This implements the server side of the dyndns2 protocol, so routers and
+// other appliances which can only speak that can update records. See:
+// https://help.dyn.com/remote-access-api/perform-update/
+
+// dyndnsUser is a user allowed to update records through the dyndns2 server.
+type dyndnsUser struct {
+	Password string
+
+	// FQDNs this user is allowed to update.
+	Hosts []string
+}
+
+// Serialize updates, as we need to send back the entire zone.
+var dyndnsMu sync.Mutex
+
+func serveDyndns(args []string) error {
+	fs := flag.NewFlagSet("dyndns", flag.ContinueOnError)
+	fs.StringVar(&config.DyndnsListen, "listen", config.DyndnsListen, "listen address; overrides dyndns-listen")
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %q", rest)
+	}
+
+	memoryCache = true
+
+	if len(config.DyndnsUsers) == 0 {
+		return errors.New("no users configured; add at least one dyndns-user")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nic/update", handleDyndns)
+
+	l, err := sdListen("dyndns", config.DyndnsListen)
+	if err != nil {
+		return err
+	}
+	infof("dyndns2 server listening on %v", l.Addr())
+	sdNotify("READY=1", "STATUS=listening on "+l.Addr().String())
+	return http.Serve(l, configLocked(mux))
+}
+
+func handleDyndns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	user, pass, ok := r.BasicAuth()
+	u, has := config.DyndnsUsers[user]
+	if !ok || !has || subtle.ConstantTimeCompare([]byte(pass), []byte(u.Password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="transip-dynamic"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	addr := r.URL.Query().Get("myip")
+	if addr == "" {
+		addr, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		fmt.Fprintln(w, "911")
+		return
+	}
+
+	hosts := r.URL.Query().Get("hostname")
+	if hosts == "" {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+
+	for _, h := range strings.Split(hosts, ",") {
+		fmt.Fprintln(w, dyndnsUpdate(r.Context(), u, h, ip))
+	}
+}
+
+// dyndnsUpdate updates a single host and returns the dyndns2 response code.
+func dyndnsUpdate(ctx context.Context, u dyndnsUser, host string, ip net.IP) string {
+	domain, fqdn, err := splitRecord(strings.TrimSpace(host))
+	if err != nil {
+		return "notfqdn"
+	}
+	if !inList(u.Hosts, fqdn) {
+		return "nohost"
+	}
+
+	typ, addr := "A", ip.String()
+	if ip.To4() == nil {
+		typ = "AAAA"
+	}
+
+	dyndnsMu.Lock()
+	defer dyndnsMu.Unlock()
+
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		warnf("dyndns: cannot get domain %v: %v",
+			domain, err)
+		return "dnserr"
+	}
+
+	found, changed := false, false
+	for i := range info {
+		if info[i].FQDN == fqdn && info[i].Type == typ {
+			found = true
+			if info[i].Content != addr {
+				info[i].Content = addr
+				changed = true
+			}
+		}
+	}
+	if !found {
+		warnf("dyndns: no %v record for %v",
+			typ, fqdn)
+		return "nohost"
+	}
+	if !changed {
+		return "nochg " + addr
+	}
+
+	err = sendUpdate(ctx, domain, info)
+	if err != nil {
+		warnf("dyndns: cannot update domain %v: %v",
+			domain, err)
+		return "dnserr"
+	}
+	return "good " + addr
+}
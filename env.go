@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"arp242.net/sconfig"
+)
+
+// All settings can also be set from the environment, so it can run in a
+// container without a config file. The setting name is uppercased with
+// underscores and prefixed with TRANSIP_, e.g. TRANSIP_GET_IP for get-ip.
+// Settings that can be repeated are given one per line.
+//
+// The order of precedence is:
+//
+//   1. TRANSIP_<SETTING>
+//   2. TRANSIP_<SETTING>_FILE; the value is read from this file, for secrets
+//      mounted as files.
+//   3. the config file.
+//   4. the default.
+//
+// A setting from the environment replaces the value from the config file,
+// including settings that can be repeated.
+//
+// The key can also be given as PEM in TRANSIP_KEY, which takes precedence over
+// key-file. If there is no key at all, transip.key in $CREDENTIALS_DIRECTORY
+// (systemd's LoadCredential) is used.
+//
+// With "key-file -" the key is read from stdin:
+//
+//   vault read -field=key secret/transip | transip-dynamic
+
+const envPrefix = "TRANSIP_"
+
+var (
+	// The key from stdin; stdin can only be read once, and the config is
+	// read again when the daemon reloads.
+	stdinKey   []byte
+	stdinKeyMu sync.Mutex
+)
+
+// keyData reads a key file, or stdin if file is "-".
+func keyData(file string) ([]byte, error) {
+	if file != "-" {
+		return ioutil.ReadFile(file)
+	}
+
+	stdinKeyMu.Lock()
+	defer stdinKeyMu.Unlock()
+	if stdinKey == nil {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading key from stdin: %v", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil, errors.New("key-file is - but there is nothing on stdin")
+		}
+		stdinKey = data
+	}
+	return stdinKey, nil
+}
+
+// envName gets the environment variable for a config key.
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// envSetting gets the value of a setting from the environment.
+func envSetting(key string) (string, bool, error) {
+	name := envName(key)
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true, nil
+	}
+	if file, ok := os.LookupEnv(name + "_FILE"); ok {
+		v, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%v_FILE: %v", name, err)
+		}
+		return strings.TrimSpace(string(v)), true, nil
+	}
+	return "", false, nil
+}
+
+// hasEnvConfig reports if there are any TRANSIP_ variables in the environment.
+func hasEnvConfig() bool {
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, envPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnv sets the config from the environment.
+func parseEnv(handlers sconfig.Handlers) error {
+	var (
+		v   = reflect.ValueOf(&config).Elem()
+		dir string
+	)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" { // Unexported
+			continue
+		}
+
+		k := configKey(f.Name)
+		val, ok, err := envSetting(k)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		// sconfig can only read files, so write it to a temporary one.
+		if dir == "" {
+			dir, err = ioutil.TempDir("", "transip-dynamic")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+		}
+		var lines []string
+		for _, l := range strings.Split(val, "\n") {
+			if strings.TrimSpace(l) != "" {
+				lines = append(lines, k+" "+l)
+			}
+		}
+		path := filepath.Join(dir, envName(k))
+		err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+		if err != nil {
+			return err
+		}
+
+		v.Field(i).Set(reflect.Zero(f.Type))
+		err = sconfig.Parse(&config, path, handlers)
+		if err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), dir+string(filepath.Separator)))
+		}
+	}
+
+	if pemKey, ok := os.LookupEnv(envPrefix + "KEY"); ok {
+		key, err := decryptKey([]byte(pemKey))
+		if err != nil {
+			return fmt.Errorf("%vKEY: %v", envPrefix, err)
+		}
+		config.key, config.KeyFile = key, "$"+envPrefix+"KEY"
+	}
+
+	if credDir := os.Getenv("CREDENTIALS_DIRECTORY"); config.key == nil && config.KeyFile == "" && credDir != "" {
+		path := filepath.Join(credDir, "transip.key")
+		if _, err := os.Stat(path); err == nil {
+			config.KeyFile = path
+			key, err := readEncryptedKey(path)
+			if err != nil {
+				return fmt.Errorf("%v: %v", path, err)
+			}
+			config.key = key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// domainMeta is the registration information for a domain, from getInfo.
+type domainMeta struct {
+	Name             string `json:"name"`
+	IsLocked         bool   `json:"is_locked"`
+	RegistrationDate string `json:"registration_date"`
+	RenewalDate      string `json:"renewal_date"`
+}
+
+var (
+	domainMetas   = make(map[string]domainMeta)
+	domainMetasMu sync.Mutex
+)
+
+// getDomainMeta gets the registration information for a domain we fetched
+// from the API in this process.
+func getDomainMeta(name string) (domainMeta, bool) {
+	domainMetasMu.Lock()
+	defer domainMetasMu.Unlock()
+	m, ok := domainMetas[name]
+	return m, ok
+}
+
+// renewal gets the renewal date, or the zero time if it's not known.
+func (m domainMeta) renewal() time.Time {
+	t, _ := time.Parse("2006-01-02", m.RenewalDate)
+	return t
+}
+
+// checkExpiry sends a notification if the domain is up for renewal in
+// less than ExpiryWarnDays. This is done once a day for every domain, so it's
+// not repeated on every run.
+func checkExpiry(m domainMeta) {
+	domainMetasMu.Lock()
+	domainMetas[m.Name] = m
+	domainMetasMu.Unlock()
+
+	r := m.renewal()
+	if config.ExpiryWarnDays <= 0 || r.IsZero() {
+		return
+	}
+	days := int(time.Until(r).Hours() / 24)
+	if days >= int(config.ExpiryWarnDays) {
+		return
+	}
+
+	today := time.Now().Format("2006-01-02")
+	stateMu.Lock()
+	st := readState()
+	if st.ExpiryWarned[m.Name] == today {
+		stateMu.Unlock()
+		return
+	}
+	st.ExpiryWarned[m.Name] = today
+	writeState(st)
+	stateMu.Unlock()
+
+	var msg string
+	if days < 0 {
+		msg = fmt.Sprintf("%v was up for renewal on %v", m.Name, m.RenewalDate)
+	} else {
+		msg = fmt.Sprintf("%v is up for renewal on %v (in %d days)", m.Name, m.RenewalDate, days)
+	}
+	notify("domain expiring", msg)
+}
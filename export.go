@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The export and import commands write a domain as a BIND zone file, and
+// replace all records in a domain with the records from one:
+//
+//   transip-dynamic export example.com > example.zone
+//   transip-dynamic import example.com example.zone
+//
+// import prints the changes before sending them; use -dry-run to only print
+// them. See zonefile.go for what's supported when reading zone files.
+
+func exportZone(args []string) error {
+	ctx := context.Background()
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v export DOMAIN", os.Args[0])
+	}
+	domain, _, err := splitRecord(args[0])
+	if err != nil {
+		return err
+	}
+
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+	}
+
+	_, err = os.Stdout.Write(zoneFile(domain, info, "exported from TransIP"))
+	return err
+}
+
+// zoneFile gets the records in info as a zone file.
+func zoneFile(domain string, info []Info, comment string) []byte {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "; %v, %v at %v\n", toUnicode(domain), comment, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(b, "$ORIGIN %v.\n", domain)
+	for _, i := range info {
+		c := i.Content
+		if i.Type == "TXT" {
+			c = quoteTXT(c)
+		}
+		fmt.Fprintf(b, "%-24v%-7v IN      %-7v %v\n", i.Name, i.Expire, i.Type, c)
+	}
+	return b.Bytes()
+}
+
+func importZone(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the changes")
+	pos, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 2 {
+		return fmt.Errorf("usage: %v import DOMAIN FILE [-dry-run]", os.Args[0])
+	}
+	domain, _, err := splitRecord(pos[0])
+	if err != nil {
+		return err
+	}
+
+	return replaceZone(ctx, domain, pos[1], *dryRun)
+}
+
+// replaceZone replaces all records in domain with the records from the zone
+// file at path.
+func replaceZone(ctx context.Context, domain, path string, dryRun bool) error {
+	want, err := readZoneFile(domain, path)
+	if err != nil {
+		return err
+	}
+	if len(want) == 0 {
+		return fmt.Errorf("no records in %v; not removing all records from %v", path, toUnicode(domain))
+	}
+
+	// Get the current zone rather than the cached one, so the changes are
+	// accurate.
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+	}
+
+	changes := diffZone(info, want)
+	if len(changes) == 0 {
+		infof("no changes")
+		return nil
+	}
+	printChanges(changes, want)
+	if dryRun {
+		return nil
+	}
+
+	err = sendUpdate(ctx, domain, want)
+	if err != nil {
+		return fmt.Errorf("cannot update domain %v: %v", toUnicode(domain), err)
+	}
+	infof("replaced the records in %v with the %d records from %v; %d changes",
+		toUnicode(domain), len(want), path, len(changes))
+	return nil
+}
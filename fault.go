@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// The SOAP API reports errors as a SOAP-ENV:Fault with a code and message
+// (transip.FaultError). The codes aren't documented and aren't very specific,
+// so the common problems are recognized from the message, and a hint on how to
+// fix it is added to the error.
+
+var reUnknownFault = regexp.MustCompile(`(?i)(domain|object).*(not found|unknown|does ?n[o']t exist|not in your account)|unknown domain`)
+
+// withFaultHint adds a hint to err if it's a SOAP fault we know how to fix;
+// errors.As still finds the *transip.FaultError.
+func withFaultHint(err error) error {
+	var f *transip.FaultError
+	if !errors.As(err, &f) {
+		return err
+	}
+	if h := faultHint(f); h != "" {
+		return fmt.Errorf("%w (%v)", err, h)
+	}
+	return err
+}
+
+func faultHint(f *transip.FaultError) string {
+	switch {
+	case reReadOnly.MatchString(f.Message):
+		return "the key is restricted to read-only"
+	case strings.Contains(strings.ToLower(f.Message), "signature"):
+		return "check that key-file is the key for user, that it's not restricted to whitelisted IPs, and that the clock is correct"
+	case f.Auth():
+		return "check user and key-file, and that the key isn't restricted to whitelisted IPs"
+	case reUnknownFault.MatchString(f.Message):
+		return "check that the domain is in the account of user"
+	case f.Temporary():
+		return "too many requests; it will be retried in the next run"
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The VpsFirewall setting keeps the whitelist of a VPS firewall rule up to date
+// with our IP, so that you're not locked out of SSH on the VPS when the IP
+// changes. The address we added is stored in the state, so we know which entry
+// to replace next time; other entries are left alone.
+
+// vpsFirewall is the firewall of a VPS in the REST API.
+type vpsFirewall struct {
+	IsEnabled bool              `json:"isEnabled"`
+	RuleSet   []vpsFirewallRule `json:"ruleSet"`
+}
+
+type vpsFirewallRule struct {
+	Description string   `json:"description"`
+	StartPort   int      `json:"startPort"`
+	EndPort     int      `json:"endPort"`
+	Protocol    string   `json:"protocol"`
+	Whitelist   []string `json:"whitelist"`
+}
+
+// syncFirewalls updates all the firewall rules in VpsFirewall to ip.
+func syncFirewalls(ctx context.Context, ip ipT) error {
+	if len(config.VpsFirewall) == 0 || mockMode == "replay" {
+		return nil
+	}
+
+	want := make([]string, 0, 2)
+	if ip.IPv4 != "" {
+		want = append(want, ip.IPv4+"/32")
+	}
+	if ip.IPv6 != "" {
+		want = append(want, ip.IPv6+"/128")
+	}
+
+	var errs []string
+	for _, fw := range config.VpsFirewall {
+		err := syncFirewall(ctx, fw[0], strings.Join(fw[1:], " "), want)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("updating VPS firewall: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func syncFirewall(ctx context.Context, vps, rule string, want []string) error {
+	k := vps + "/" + rule
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+	old := st.Firewall[k]
+	if strings.Join(old, " ") == strings.Join(want, " ") {
+		return nil
+	}
+
+	var resp struct {
+		VpsFirewall vpsFirewall `json:"vpsFirewall"`
+	}
+	path := "/vps/" + url.PathEscape(vps) + "/firewall"
+	err := restClient().Do(ctx, "GET", path, nil, &resp)
+	if err != nil {
+		return err
+	}
+
+	fw := resp.VpsFirewall
+	found := false
+	for i, r := range fw.RuleSet {
+		if !strings.EqualFold(r.Description, rule) {
+			continue
+		}
+		found = true
+
+		wl := make([]string, 0, len(r.Whitelist)+len(want))
+		for _, w := range r.Whitelist {
+			if !inList(old, w) && !inList(want, w) {
+				wl = append(wl, w)
+			}
+		}
+		fw.RuleSet[i].Whitelist = append(wl, want...)
+	}
+	if !found {
+		return fmt.Errorf("VPS %v has no firewall rule %q", vps, rule)
+	}
+
+	err = restClient().Do(ctx, "PUT", path, map[string]interface{}{"vpsFirewall": fw}, nil)
+	if err != nil {
+		return err
+	}
+
+	stateMu.Lock()
+	st = readState()
+	st.Firewall[k] = want
+	writeState(st)
+	stateMu.Unlock()
+
+	infof("updated firewall rule %q on %v to %v", rule, vps, strings.Join(want, ", "))
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// With FlapDampen an address that keeps changing back and forth, for example
+// with a dual-WAN failover that bounces between the links, is only published
+// once it's stable for that long:
+//
+//   flap-dampen 30m
+//
+// The address is flapping if it changed twice in that period, or if it changes
+// back to an address it changed away from in that period. The first change is
+// always published right away, and -force publishes it anyway. See also
+// -stable-for, which waits for every change, and WriteInterval, which limits how
+// often a record is changed.
+
+// flapChange is a change of the published address.
+type flapChange struct {
+	From ipT       `json:"from"`
+	To   ipT       `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// recordFlap records that the published address changed in st; changes older
+// than FlapDampen are removed.
+func recordFlap(st *stateT, from, to ipT) {
+	if config.FlapDampen <= 0 {
+		st.Flaps = nil
+		return
+	}
+	var keep []flapChange
+	for _, c := range st.Flaps {
+		if time.Since(c.Time) < config.FlapDampen {
+			keep = append(keep, c)
+		}
+	}
+	st.Flaps = append(keep, flapChange{From: from, To: to, Time: time.Now()})
+}
+
+// flapping gets why changing to ip is flapping, or "" if it's not.
+func flapping(ip ipT) string {
+	if config.FlapDampen <= 0 {
+		return ""
+	}
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+	if st.LastIP.IP == ip {
+		return ""
+	}
+
+	var n int
+	for _, c := range st.Flaps {
+		if time.Since(c.Time) >= config.FlapDampen {
+			continue
+		}
+		n++
+		if c.From == ip {
+			return fmt.Sprintf("it changed from %v to %v %v ago", c.From, c.To, time.Since(c.Time).Round(time.Second))
+		}
+	}
+	if n >= 2 {
+		return fmt.Sprintf("it changed %d times in the last %v", n, config.FlapDampen)
+	}
+	return ""
+}
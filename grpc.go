@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a small gRPC server for the service in control.proto, so a central
+// controller can manage many updaters. It's implemented on top of net/http's
+// HTTP/2 support with hand-written protobuf encoding, which is easy enough for
+// the handful of simple messages we have and saves pulling in a large
+// dependency.
+
+const grpcService = "/transipdynamic.Control/"
+
+// gRPC status codes; see google.golang.org/grpc/codes
+const (
+	grpcOK            = 0
+	grpcUnimplemented = 12
+	grpcInternal      = 13
+)
+
+type event struct {
+	planChange
+	Time time.Time
+}
+
+var (
+	subsMu sync.Mutex
+	subs   = make(map[chan event]struct{})
+)
+
+// publishEvent sends the change to everyone who is subscribed. Slow
+// subscribers will miss events rather than blocking the update.
+func publishEvent(c planChange) {
+	subsMu.Lock()
+	defer subsMu.Unlock()
+	for ch := range subs {
+		select {
+		case ch <- event{planChange: c, Time: time.Now()}:
+		default:
+		}
+	}
+}
+
+// subscribe to change events; the returned function should be called to
+// unsubscribe.
+func subscribe() (chan event, func()) {
+	ch := make(chan event, 16)
+	subsMu.Lock()
+	subs[ch] = struct{}{}
+	subsMu.Unlock()
+	return ch, func() {
+		subsMu.Lock()
+		delete(subs, ch)
+		subsMu.Unlock()
+	}
+}
+
+func serveGRPC() error {
+	configMu.RLock()
+	listen, cert, key, clientCA := config.GrpcListen, config.GrpcCert, config.GrpcKey, config.GrpcClientCa
+	configMu.RUnlock()
+
+	if cert == "" || key == "" || clientCA == "" {
+		return errors.New("grpc-cert, grpc-key, and grpc-client-ca need to be set")
+	}
+
+	ca, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificates found in %v", clientCA)
+	}
+
+	srv := &http.Server{
+		Addr:    listen,
+		Handler: http.HandlerFunc(handleGRPC),
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	infof("gRPC listening on %v", listen)
+	return srv.ListenAndServeTLS(cert, key)
+}
+
+func handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// None of the requests have any fields.
+	io.Copy(ioutil.Discard, r.Body)
+	w.Header().Set("Content-Type", "application/grpc")
+
+	switch r.URL.Path {
+	case grpcService + "Update":
+		err := runUpdate()
+		if err != nil {
+			grpcStatus(w, grpcInternal, err.Error())
+			return
+		}
+		grpcWrite(w, statusMsg())
+		grpcStatus(w, grpcOK, "")
+	case grpcService + "Status":
+		grpcWrite(w, statusMsg())
+		grpcStatus(w, grpcOK, "")
+	case grpcService + "Events":
+		ch, unsub := subscribe()
+		defer unsub()
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-ch:
+				grpcWrite(w, pbuf(nil).
+					str(1, ev.FQDN).
+					str(2, ev.Type).
+					str(3, ev.Old).
+					str(4, ev.New).
+					int(5, ev.Time.Unix()))
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+func statusMsg() pbuf {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	b := pbuf(nil)
+	if status.IP != nil {
+		b = b.str(1, status.IP.IPv4).str(2, status.IP.IPv6)
+	}
+	if !status.LastRun.IsZero() {
+		b = b.int(3, status.LastRun.Unix()).int(4, status.NextRun.Unix())
+	}
+	return b.
+		str(5, status.LastError).
+		int(6, int64(status.Runs))
+}
+
+// grpcWrite writes a single length-prefixed message.
+func grpcWrite(w io.Writer, msg pbuf) {
+	h := make([]byte, 5)
+	binary.BigEndian.PutUint32(h[1:], uint32(len(msg)))
+	w.Write(h)
+	w.Write(msg)
+}
+
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// pbuf is a protobuf-encoded message.
+type pbuf []byte
+
+func (b pbuf) varint(v uint64) pbuf {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func (b pbuf) str(field int, s string) pbuf {
+	if s == "" {
+		return b
+	}
+	b = b.varint(uint64(field<<3 | 2)).varint(uint64(len(s)))
+	return append(b, s...)
+}
+
+func (b pbuf) int(field int, v int64) pbuf {
+	if v == 0 {
+		return b
+	}
+	return b.varint(uint64(field << 3)).varint(uint64(v))
+}
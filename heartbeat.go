@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// With HeartbeatRecord a TXT record with the hostname and time of the last
+// update is written, so monitoring can check that the updater is still running
+// with just DNS:
+//
+//   _transip-dynamic.example.com. 60 IN TXT "transip-dynamic host=nas time=2026-10-14T12:00:00Z"
+//
+// If the domain is one of the domains that's updated this is sent in the same
+// request. If nothing changed it's only written when it's older than half of
+// HeartbeatInterval, so it's never older than HeartbeatInterval plus the time
+// between two runs.
+//
+// With HeartbeatURL a URL is pinged after every run instead (or as well), for
+// dead man's switch services such as healthchecks.io:
+//
+//   heartbeat-url https://hc-ping.com/eb095278-f28d-448d-87fb-7b75c171a6aa
+//
+// A run that failed pings URL/fail, with the error in the body. If cron stops
+// running it or it keeps failing the service will send an alert.
+
+// addHeartbeat sets the heartbeat record in info if it's in domain.
+func addHeartbeat(domain string, info []Info) []Info {
+	if config.HeartbeatRecord == "" {
+		return info
+	}
+	hDomain, fqdn, err := splitRecord(config.HeartbeatRecord)
+	if err != nil || hDomain != domain {
+		return info
+	}
+	return setTXT(info, domain, fqdn, heartbeat{Host: hostname(), Time: time.Now()}.String())
+}
+
+// writeHeartbeat writes the heartbeat record if it's due; updated is set if the
+// domains from Records were just written, in which case it was already sent
+// with them if it's in one of those.
+func writeHeartbeat(ctx context.Context, updated bool) error {
+	if config.HeartbeatRecord == "" || !heartbeatDue() {
+		return nil
+	}
+	domain, _, err := splitRecord(config.HeartbeatRecord)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Records[domain]; ok && updated {
+		return nil
+	}
+
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot write heartbeat record: %v", err)
+	}
+	err = sendUpdate(ctx, domain, addHeartbeat(domain, info))
+	if err != nil {
+		return fmt.Errorf("cannot write heartbeat record: %v", err)
+	}
+	wroteHeartbeat(domain)
+	return nil
+}
+
+// heartbeatDue reports if the heartbeat record needs to be written even if
+// nothing else changed.
+func heartbeatDue() bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return time.Since(readState().Heartbeat) >= config.HeartbeatInterval/2
+}
+
+// wroteHeartbeat records that domain was written, if the heartbeat record is in
+// it.
+func wroteHeartbeat(domain string) {
+	if config.HeartbeatRecord == "" {
+		return
+	}
+	if hDomain, _, err := splitRecord(config.HeartbeatRecord); err != nil || hDomain != domain {
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	st.Heartbeat = time.Now()
+	writeState(st)
+}
+
+// pingHeartbeat pings the HeartbeatURL after a run.
+func pingHeartbeat(ctx context.Context, runErr error) {
+	if config.HeartbeatURL == "" || mockMode == "replay" {
+		return
+	}
+
+	u, body := config.HeartbeatURL, ""
+	if runErr != nil {
+		u, body = strings.TrimSuffix(u, "/")+"/fail", runErr.Error()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(body))
+	if err != nil {
+		warnf("cannot ping heartbeat-url: %v", redactURL(err))
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := httpClient(config.HTTPTimeout).Do(req)
+	if err != nil {
+		// The URL usually contains a secret, so don't log it.
+		warnf("cannot ping heartbeat-url: %v", redactURL(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		warnf("cannot ping heartbeat-url: %v", resp.Status)
+	}
+}
+
+// redactURL removes the URL from an error from the http package.
+func redactURL(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err
+	}
+	return err
+}
+
+// validHeartbeatURL checks the HeartbeatURL.
+func validHeartbeatURL(u string) error {
+	p, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return fmt.Errorf("not a http:// or https:// URL: %q", u)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// With HoldSuspicious a new address that looks wrong is held rather than
+// published. An address is suspicious if:
+//
+//   - it's in another network than the previous one (with CheckOrigin);
+//   - it's far away from the previous one (another /16 for IPv4, or /32 for
+//     IPv6);
+//   - the previous change was less than HoldWindow ago.
+//
+// A held address is published once the next run detects the same address
+// again, with -force, or if HoldApproveURL returns a 2xx status.
+
+// Set from the -force flag.
+var force bool
+
+// lastIP is the last address we published, and when it changed.
+type lastIP struct {
+	IP      ipT       `json:"ip"`
+	Changed time.Time `json:"changed"`
+}
+
+// heldIP is an address that's waiting for confirmation.
+type heldIP struct {
+	IP      ipT       `json:"ip"`
+	Reasons []string  `json:"reasons"`
+	Since   time.Time `json:"since"`
+}
+
+// farAway reports if the addresses are in a different /16 (IPv4) or /32
+// (IPv6).
+func farAway(prev, cur string) bool {
+	p, c := net.ParseIP(prev), net.ParseIP(cur)
+	if p == nil || c == nil {
+		return false
+	}
+	bits, size := 32, 128
+	if p.To4() != nil {
+		p, c, bits, size = p.To4(), c.To4(), 16, 32
+	}
+	if c == nil {
+		return false
+	}
+	m := net.CIDRMask(bits, size)
+	return !p.Mask(m).Equal(c.Mask(m))
+}
+
+// suspicious gets the reasons why the change from last to ip looks wrong; an
+// empty list means it's fine. The origin are the changes of network from
+// checkOrigin.
+func suspicious(last lastIP, ip ipT, origin []string) []string {
+	reasons := append([]string(nil), origin...)
+	for _, f := range [][2]string{{last.IP.IPv4, ip.IPv4}, {last.IP.IPv6, ip.IPv6}} {
+		if f[0] != "" && f[1] != "" && farAway(f[0], f[1]) {
+			reasons = append(reasons, fmt.Sprintf("%v is far away from the previous address %v", f[1], f[0]))
+		}
+	}
+	if config.HoldWindow > 0 && !last.Changed.IsZero() && time.Since(last.Changed) < config.HoldWindow {
+		reasons = append(reasons, fmt.Sprintf("the previous change was only %v ago",
+			time.Since(last.Changed).Round(time.Second)))
+	}
+	return reasons
+}
+
+// confirmIP reports if ip can be published. It returns an error if it's held.
+func confirmIP(ctx context.Context, ip ipT, origin []string) (bool, error) {
+	if !config.HoldSuspicious {
+		return true, nil
+	}
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+
+	last := st.LastIP
+	if last.IP == (ipT{}) || last.IP == ip {
+		return true, nil
+	}
+	reasons := suspicious(last, ip, origin)
+	if len(reasons) == 0 {
+		return true, nil
+	}
+
+	why := strings.Join(reasons, "; ")
+	switch {
+	case force:
+		infof("publishing %v with -force: %v", ip, why)
+		return true, nil
+	case st.Held != nil && st.Held.IP == ip:
+		infof("publishing %v as it was detected again: %v", ip, why)
+		return true, nil
+	case config.HoldApproveURL != "":
+		err := approveIP(ctx, ip, reasons)
+		if err == nil {
+			infof("publishing %v as it was approved: %v", ip, why)
+			return true, nil
+		}
+		warnf("%v not approved: %v", ip, err)
+	}
+
+	stateMu.Lock()
+	st = readState()
+	if st.Held == nil || st.Held.IP != ip {
+		st.Held = &heldIP{IP: ip, Reasons: reasons, Since: time.Now()}
+		notify("holding new address", fmt.Sprintf(
+			"not publishing %v yet: %v; it's published if the next run detects the same address, or with -force",
+			ip, why))
+	}
+	writeState(st)
+	stateMu.Unlock()
+	return false, fmt.Errorf("holding new address %v: %v", ip, why)
+}
+
+// approveIP asks HoldApproveURL if the address can be published.
+func approveIP(ctx context.Context, ip ipT, reasons []string) error {
+	j, err := json.Marshal(map[string]interface{}{"ip": ip, "reasons": reasons})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", config.HoldApproveURL, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient(30 * time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v returned %v", config.HoldApproveURL, resp.Status)
+	}
+	return nil
+}
+
+// publishedIP records that ip was published, and returns the address that was
+// published before.
+func publishedIP(ip ipT) ipT {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	old := st.LastIP.IP
+	if old == ip && st.Held == nil {
+		return old
+	}
+	switch {
+	case st.LastIP.IP == (ipT{}): // First run; we don't know when it changed.
+		st.LastIP = lastIP{IP: ip}
+	case st.LastIP.IP != ip:
+		recordFlap(&st, st.LastIP.IP, ip)
+		st.LastIP = lastIP{IP: ip, Changed: time.Now()}
+	}
+	st.Held = nil
+	writeState(st)
+	return old
+}
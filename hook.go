@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The on-change hooks are run after the records were updated to a new
+// address, for example to restart a VPN or update firewall rules:
+//
+//   on-change exec /usr/local/bin/restart-vpn
+//   on-change webhook https://example.com/ip-changed
+//
+// exec runs the command with the old and new address and the records in the
+// environment, and webhook POSTs the same as a JSON object.
+
+// changeT is what's sent to the on-change hooks.
+type changeT struct {
+	Old     ipT      `json:"old"`
+	New     ipT      `json:"new"`
+	Records []string `json:"records"`
+}
+
+// validOnChange checks if an on-change setting from the config is valid.
+func validOnChange(v []string) error {
+	if len(v) < 2 {
+		return fmt.Errorf("need a type and argument: %q", v)
+	}
+	switch v[0] {
+	case "exec":
+	case "webhook":
+		if len(v) != 2 {
+			return fmt.Errorf("webhook needs a single URL: %q", v)
+		}
+	default:
+		return fmt.Errorf("unknown on-change type %q", v[0])
+	}
+	return nil
+}
+
+// runOnChange runs all on-change hooks if the address changed from old to ip.
+func runOnChange(ctx context.Context, old, ip ipT) error {
+	if old == ip || mockMode == "replay" || len(config.OnChange) == 0 {
+		return nil
+	}
+
+	c := changeT{Old: old, New: ip}
+	for _, domain := range sortedDomains() {
+		for _, r := range config.Records[domain] {
+			c.Records = append(c.Records, strings.TrimSuffix(r, "."))
+		}
+	}
+
+	var errs []string
+	for _, h := range config.OnChange {
+		var err error
+		switch h[0] {
+		case "exec":
+			err = onChangeExec(ctx, h[1:], c)
+		case "webhook":
+			err = onChangeWebhook(ctx, h[1], c)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v %v: %v", h[0], h[1], err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("on-change: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// onChangeExec runs a command with the change in $TRANSIP_OLD_IP4,
+// $TRANSIP_OLD_IP6, $TRANSIP_NEW_IP4, $TRANSIP_NEW_IP6, and $TRANSIP_RECORDS
+// (space-separated).
+func onChangeExec(ctx context.Context, cmd []string, c changeT) error {
+	e := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	e.Env = append(os.Environ(),
+		"TRANSIP_OLD_IP4="+c.Old.IPv4,
+		"TRANSIP_OLD_IP6="+c.Old.IPv6,
+		"TRANSIP_NEW_IP4="+c.New.IPv4,
+		"TRANSIP_NEW_IP6="+c.New.IPv6,
+		"TRANSIP_RECORDS="+strings.Join(c.Records, " "))
+	out, err := e.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// onChangeWebhook POSTs the change as JSON to url.
+func onChangeWebhook(ctx context.Context, url string, c changeT) error {
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient(10 * time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP client for the API is built from a chain of middleware, each of
+// which wraps the next http.RoundTripper.
+
+// roundTripperFunc is a function that implements http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// middleware wraps a RoundTripper to add some behaviour.
+type middleware func(http.RoundTripper) http.RoundTripper
+
+// chain wraps rt in all the middleware; the first one is the outermost, and the
+// last one is called just before rt.
+func chain(rt http.RoundTripper, mw ...middleware) http.RoundTripper {
+	for i := len(mw) - 1; i >= 0; i-- {
+		rt = mw[i](rt)
+	}
+	return rt
+}
+
+// newTransport creates the transport for all outbound connections.
+func newTransport() (http.RoundTripper, error) {
+	if config.TLSCert == "" && config.TLSKey == "" && config.CAFile == "" && config.CAPath == "" {
+		return http.DefaultTransport, nil
+	}
+
+	tlsc := &tls.Config{}
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return nil, errors.New("need both tls-cert and tls-key for a client certificate")
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		tlsc.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.CAFile != "" || config.CAPath != "" {
+		pool, err := loadCAs(config.CAFile, config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsc.RootCAs = pool
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsc
+	return t, nil
+}
+
+// loadCAs loads all CA certificates from file and all the files in dir; these
+// replace the system's CAs.
+func loadCAs(file, dir string) (*x509.CertPool, error) {
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	}
+	if dir != "" {
+		ls, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CAPath: %v", err)
+		}
+		for _, f := range ls {
+			if !f.IsDir() {
+				files = append(files, filepath.Join(dir, f.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	n := 0
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA: %v", err)
+		}
+		if pool.AppendCertsFromPEM(data) {
+			n++
+		} else if f == file {
+			return nil, fmt.Errorf("no certificates in CAFile %v", f)
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("no CA certificates found in CAFile or CAPath")
+	}
+	return pool, nil
+}
+
+// httpClient gets a HTTP client for outbound connections.
+func httpClient(timeout time.Duration) *http.Client {
+	t := config.transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &http.Client{Timeout: timeout, Transport: decompress(t)}
+}
+
+// apiClient gets the HTTP client to use for API requests. It's created once
+// when reading the config, so connections are re-used.
+func apiClient() *http.Client {
+	if config.apiClient == nil {
+		return newAPIClient()
+	}
+	return config.apiClient
+}
+
+func newAPIClient() *http.Client {
+	t := config.apiTransport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &http.Client{
+		Timeout: config.APITimeout,
+		Transport: chain(t,
+			logRequests,
+			measure,
+			authBreaker,
+			readOnly,
+			retry(int(config.APIRetries)),
+			rateLimit(config.APIRateLimit),
+			signSOAP,
+			mock,
+			decompress,
+		),
+	}
+}
+
+// apiStatsT are statistics for all API requests.
+type apiStatsT struct {
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Latency  time.Duration `json:"latency_ns"`
+}
+
+var (
+	apiStats   apiStatsT
+	apiStatsMu sync.Mutex
+)
+
+// getAPIStats gets a copy of the current statistics.
+func getAPIStats() apiStatsT {
+	apiStatsMu.Lock()
+	defer apiStatsMu.Unlock()
+	return apiStats
+}
+
+// measure records statistics for all requests in apiStats.
+func measure(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+
+		apiStatsMu.Lock()
+		defer apiStatsMu.Unlock()
+		apiStats.Requests++
+		apiStats.Latency += time.Since(start)
+		if err != nil || resp.StatusCode >= 500 {
+			apiStats.Errors++
+		}
+		return resp, err
+	})
+}
+
+// retry the request n times on network errors and temporary server errors,
+// waiting a bit longer every time.
+//
+// SOAP faults are sent with a 500 status code, so those aren't retried as
+// retrying something like an invalid signature won't help.
+func retry(n int) middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			var (
+				resp *http.Response
+				err  error
+			)
+			for i := 0; ; i++ {
+				r := req
+				if i > 0 && req.GetBody != nil {
+					r = req.Clone(req.Context())
+					r.Body, err = req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				resp, err = next.RoundTrip(r)
+				temp := false
+				if err != nil {
+					temp = isTemporary(err)
+				} else {
+					switch resp.StatusCode {
+					case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+						temp = true
+					}
+				}
+				if !temp || i >= n || (req.Body != nil && req.GetBody == nil) {
+					return resp, err
+				}
+				if err == nil {
+					resp.Body.Close()
+				}
+
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(time.Duration(i+1) * time.Second):
+				}
+			}
+		})
+	}
+}
+
+var (
+	lastRequest   time.Time
+	lastRequestMu sync.Mutex
+)
+
+// rateLimit makes sure there's at least d between requests.
+func rateLimit(d time.Duration) middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if d <= 0 {
+			return next
+		}
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lastRequestMu.Lock()
+			wait := time.Until(lastRequest.Add(d))
+			if wait < 0 {
+				wait = 0
+			}
+			lastRequest = time.Now().Add(wait)
+			lastRequestMu.Unlock()
+
+			if wait > 0 {
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// decompress is a middleware which asks for gzip or deflate compressed
+// responses, and decodes them. The http.Transport only does this for gzip.
+func decompress(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Accept-Encoding") != "" || req.Method == http.MethodHead {
+			return next.RoundTrip(req)
+		}
+
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		var body io.ReadCloser
+		switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+		case "gzip":
+			body, err = gzip.NewReader(resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("invalid gzip response: %v", err)
+			}
+		case "deflate":
+			// This should be zlib, but some servers send raw deflate.
+			br := bufio.NewReader(resp.Body)
+			if h, err := br.Peek(2); err == nil && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 && h[0]&0x0f == 8 {
+				body, err = zlib.NewReader(br)
+				if err != nil {
+					resp.Body.Close()
+					return nil, fmt.Errorf("invalid deflate response: %v", err)
+				}
+			} else {
+				body = flate.NewReader(br)
+			}
+		default:
+			return resp, nil
+		}
+
+		resp.Body = decompressed{ReadCloser: body, orig: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+		return resp, nil
+	})
+}
+
+type decompressed struct {
+	io.ReadCloser
+	orig io.Closer
+}
+
+func (d decompressed) Close() error {
+	d.ReadCloser.Close()
+	return d.orig.Close()
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Internationalized domain names in the config are converted to the ASCII
+// form (punycode, RFC 3492) that the API and DNS use, and shown in the Unicode
+// form in the output.
+//
+// The labels that aren't ASCII, and the ones that are already in the ASCII
+// form ("xn--"), are checked as IDNA2008 does for lookups (RFC 5891, 5892,
+// 5893): the disallowed code points, the context rules, and the bidi rule.
+// golang.org/x/net/idna does this with the full Unicode tables; this uses the
+// tables from the unicode package, with these differences:
+//
+//   - Names aren't normalized; a combining accent after a Latin, Greek, or
+//     Cyrillic letter is rejected instead, as NFC would combine most of them
+//     with the letter. Type the accented letter.
+//   - Only the common compatibility characters (such as fullwidth letters and
+//     ligatures) are rejected as "unstable"; see unstable.
+//   - ZWNJ is only allowed after a virama, not by the Arabic joining rules.
+//   - The bidi classes are derived from the script and category.
+//
+// ASCII labels are kept as they are, except for being lowercased, so that
+// names like _sip._udp and * keep working.
+
+const (
+	punyBase        = 36
+	punyTmin        = 1
+	punyTmax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// toASCII converts a domain name to the ASCII form.
+func toASCII(name string) (string, error) {
+	labels, err := uLabels(strings.ToLower(name))
+	if err != nil {
+		return "", err
+	}
+	for i, l := range labels {
+		if !isASCII(l) {
+			p, err := punyEncode(l)
+			if err != nil {
+				return "", fmt.Errorf("invalid label %q: %v", l, err)
+			}
+			labels[i] = "xn--" + p
+		}
+		if len(labels[i]) > 63 {
+			return "", fmt.Errorf("invalid label %q: longer than 63 characters", l)
+		}
+	}
+	return strings.Join(labels, "."), nil
+}
+
+var (
+	badIDN   = make(map[string]struct{})
+	badIDNMu sync.Mutex
+)
+
+// toUnicode converts a domain name to the Unicode form for display. A name
+// that isn't valid is shown as it is, with a warning the first time.
+func toUnicode(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	labels, err := uLabels(name)
+	if err != nil {
+		badIDNMu.Lock()
+		defer badIDNMu.Unlock()
+		if _, ok := badIDN[name]; !ok {
+			badIDN[name] = struct{}{}
+			warnf("%v: %v", name, err)
+		}
+		return name
+	}
+	return strings.Join(labels, ".")
+}
+
+// uLabels splits a name in labels, converting the labels in the ASCII form to
+// Unicode, and checks the labels that aren't ASCII.
+func uLabels(name string) ([]string, error) {
+	labels := strings.Split(name, ".")
+	bidi := false
+	for i, l := range labels {
+		if strings.HasPrefix(l, "xn--") {
+			u, err := punyDecode(l[4:])
+			if err == nil && (u == "" || isASCII(u)) {
+				err = errors.New("doesn't encode a Unicode name")
+			}
+			if err == nil {
+				// Only the lowercase form is canonical.
+				if p, _ := punyEncode(u); "xn--"+p != strings.ToLower(l) {
+					err = errors.New("not in the canonical form")
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid label %q: %v", l, err)
+			}
+			labels[i] = u
+		}
+		for _, r := range labels[i] {
+			if c := bidiClass(r); c == bidiR || c == bidiAL || c == bidiAN {
+				bidi = true
+			}
+		}
+	}
+
+	for _, l := range labels {
+		if isASCII(l) {
+			continue
+		}
+		if err := checkLabel([]rune(l), bidi); err != nil {
+			return nil, fmt.Errorf("invalid label %q: %v", l, err)
+		}
+	}
+	return labels, nil
+}
+
+// checkLabel checks a label that isn't ASCII; bidi is set if any label in the
+// name has right-to-left characters, in which case the bidi rule applies.
+func checkLabel(l []rune, bidi bool) error {
+	switch {
+	case l[0] == '-' || l[len(l)-1] == '-':
+		return errors.New("starts or ends with a hyphen")
+	case len(l) >= 4 && l[2] == '-' && l[3] == '-':
+		return errors.New("hyphens in the third and fourth position")
+	case unicode.Is(unicode.M, l[0]):
+		return errors.New("starts with a combining mark")
+	}
+
+	for i, r := range l {
+		if i > 0 && r >= 0x0300 && r <= 0x036f && unicode.In(l[i-1], unicode.Latin, unicode.Greek, unicode.Cyrillic) {
+			return fmt.Errorf("combining mark %U isn't in NFC; use the precomposed character", r)
+		}
+
+		switch codePoint(r) {
+		case idnaDisallowed:
+			return fmt.Errorf("disallowed code point %U", r)
+		case idnaContext:
+			if !checkContext(l, i) {
+				return fmt.Errorf("code point %U not allowed in this context", r)
+			}
+		}
+	}
+
+	if bidi {
+		return checkBidi(l)
+	}
+	return nil
+}
+
+const (
+	idnaPValid = iota
+	idnaContext
+	idnaDisallowed
+)
+
+// codePoint gets the IDNA2008 property of a code point, as in RFC 5892
+// section 3.
+func codePoint(r rune) int {
+	switch {
+	// Exceptions (F).
+	case r == 0x00df, r == 0x03c2, r == 0x06fd, r == 0x06fe, r == 0x0f0b, r == 0x3007:
+		return idnaPValid
+	case r == 0x00b7, r == 0x0375, r == 0x05f3, r == 0x05f4, r == 0x30fb,
+		r >= 0x0660 && r <= 0x0669, r >= 0x06f0 && r <= 0x06f9:
+		return idnaContext
+	case r == 0x0640, r == 0x07fa, r == 0x302e, r == 0x302f, r >= 0x3031 && r <= 0x3035, r == 0x303b:
+		return idnaDisallowed
+
+	// LDH (H) and JoinControl (H).
+	case r < 0x80:
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return idnaPValid
+		}
+		return idnaDisallowed
+	case r == 0x200c, r == 0x200d:
+		return idnaContext
+
+	// Unstable (B), IgnorableProperties (C), IgnorableBlocks (D), and
+	// OldHangulJamo (I).
+	case unicode.Is(unstable, r),
+		unicode.In(r, unicode.White_Space, unicode.Noncharacter_Code_Point,
+			unicode.Variation_Selector, unicode.Other_Default_Ignorable_Code_Point),
+		r >= 0x20d0 && r <= 0x20ff, r >= 0x1d100 && r <= 0x1d24f,
+		r >= 0x1100 && r <= 0x11ff, r >= 0xa960 && r <= 0xa97f, r >= 0xd7b0 && r <= 0xd7ff:
+		return idnaDisallowed
+
+	// LetterDigits (A).
+	case unicode.In(r, unicode.Ll, unicode.Lo, unicode.Lm, unicode.Mn, unicode.Mc, unicode.Nd):
+		return idnaPValid
+	}
+	return idnaDisallowed
+}
+
+// unstable are the common characters that NFKC and case folding change, or
+// that NFC changes without a combining mark: compatibility letters, ligatures,
+// presentation forms, fullwidth and halfwidth forms, and compatibility
+// ideographs. Uppercase letters, symbols, and the like are already disallowed
+// by their category.
+var unstable = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00aa, 0x00aa, 1}, {0x00b5, 0x00b5, 1}, {0x00ba, 0x00ba, 1},
+		{0x0132, 0x0133, 1}, {0x013f, 0x0140, 1}, {0x0149, 0x0149, 1},
+		{0x017f, 0x017f, 1}, {0x01c4, 0x01cc, 1}, {0x01f1, 0x01f3, 1},
+		{0x02b0, 0x02b8, 1}, {0x02e0, 0x02e4, 1}, {0x0340, 0x0341, 1},
+		{0x0343, 0x0344, 1}, {0x0374, 0x0374, 1}, {0x037a, 0x037a, 1},
+		{0x03d0, 0x03d6, 1}, {0x03f0, 0x03f2, 1}, {0x03f4, 0x03f5, 1},
+		{0x0587, 0x0587, 1}, {0x0675, 0x0678, 1}, {0x0958, 0x095f, 1},
+		{0x09dc, 0x09dd, 1}, {0x09df, 0x09df, 1}, {0x0a33, 0x0a33, 1},
+		{0x0a36, 0x0a36, 1}, {0x0a59, 0x0a5b, 1}, {0x0a5e, 0x0a5e, 1},
+		{0x0b5c, 0x0b5d, 1}, {0x0e33, 0x0e33, 1}, {0x0eb3, 0x0eb3, 1},
+		{0x0edc, 0x0edd, 1}, {0x0f43, 0x0f43, 1}, {0x0f4d, 0x0f4d, 1},
+		{0x0f52, 0x0f52, 1}, {0x0f57, 0x0f57, 1}, {0x0f5c, 0x0f5c, 1},
+		{0x0f69, 0x0f69, 1}, {0x0f73, 0x0f73, 1}, {0x0f75, 0x0f79, 1},
+		{0x0f81, 0x0f81, 1}, {0x0f93, 0x0f93, 1}, {0x0f9d, 0x0f9d, 1},
+		{0x0fa2, 0x0fa2, 1}, {0x0fa7, 0x0fa7, 1}, {0x0fac, 0x0fac, 1},
+		{0x0fb9, 0x0fb9, 1}, {0x1d2c, 0x1d6a, 1}, {0x1d78, 0x1d78, 1},
+		{0x1d9b, 0x1dbf, 1}, {0x1e9a, 0x1e9b, 1}, {0x1f71, 0x1f7d, 2},
+		{0x2070, 0x209f, 1}, {0x2100, 0x214f, 1}, {0x2460, 0x24ff, 1},
+		{0x2c7c, 0x2c7d, 1}, {0x2d6f, 0x2d6f, 1}, {0x3131, 0x318e, 1},
+		{0x3192, 0x319f, 1}, {0x3200, 0x33ff, 1}, {0xa69c, 0xa69d, 1},
+		{0xa770, 0xa770, 1}, {0xa7f8, 0xa7f9, 1}, {0xab5c, 0xab5f, 1},
+		{0xf900, 0xfaff, 1}, {0xfb00, 0xfb4f, 1}, {0xfb50, 0xfdff, 1},
+		{0xfe10, 0xfe1f, 1}, {0xfe30, 0xfe4f, 1}, {0xfe50, 0xfe6f, 1},
+		{0xfe70, 0xfeff, 1}, {0xff00, 0xffef, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1d400, 0x1d7ff, 1}, {0x1ee00, 0x1eeff, 1}, {0x1f100, 0x1f1ff, 1},
+		{0x2f800, 0x2fa1f, 1},
+	},
+}
+
+// viramas are the code points with canonical combining class 9 (Virama) in the
+// BMP, as used by the context rules for ZWJ and ZWNJ.
+var viramas = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x094d, 0x0d4d, 0x80}, {0x0dca, 0x0dca, 1}, {0x0e3a, 0x0e3a, 1},
+		{0x0eba, 0x0eba, 1}, {0x0f84, 0x0f84, 1}, {0x1039, 0x103a, 1},
+		{0x1714, 0x1715, 1}, {0x1734, 0x1734, 1}, {0x17d2, 0x17d2, 1},
+		{0x1a60, 0x1a60, 1}, {0x1b44, 0x1b44, 1}, {0x1baa, 0x1bab, 1},
+		{0x1bf2, 0x1bf3, 1}, {0x2d7f, 0x2d7f, 1}, {0xa806, 0xa806, 1},
+		{0xa82c, 0xa82c, 1}, {0xa8c4, 0xa8c4, 1}, {0xa953, 0xa953, 1},
+		{0xa9c0, 0xa9c0, 1}, {0xaaf6, 0xaaf6, 1}, {0xabed, 0xabed, 1},
+	},
+}
+
+// checkContext checks the context rules for l[i], from RFC 5892 appendix A.
+func checkContext(l []rune, i int) bool {
+	before := func(t *unicode.RangeTable) bool { return i > 0 && unicode.Is(t, l[i-1]) }
+	after := func(t *unicode.RangeTable) bool { return i < len(l)-1 && unicode.Is(t, l[i+1]) }
+	hasScript := func(f func(rune) bool) bool {
+		for _, r := range l {
+			if f(r) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch r := l[i]; {
+	case r == 0x200c || r == 0x200d: // ZWNJ, ZWJ
+		return before(viramas)
+	case r == 0x00b7: // MIDDLE DOT
+		return i > 0 && i < len(l)-1 && l[i-1] == 'l' && l[i+1] == 'l'
+	case r == 0x0375: // GREEK LOWER NUMERAL SIGN (KERAIA)
+		return after(unicode.Greek)
+	case r == 0x05f3 || r == 0x05f4: // HEBREW PUNCTUATION GERESH, GERSHAYIM
+		return before(unicode.Hebrew)
+	case r == 0x30fb: // KATAKANA MIDDLE DOT
+		return hasScript(func(r rune) bool { return unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) })
+	case r >= 0x0660 && r <= 0x0669: // ARABIC-INDIC DIGITS
+		return !hasScript(func(r rune) bool { return r >= 0x06f0 && r <= 0x06f9 })
+	case r >= 0x06f0 && r <= 0x06f9: // EXTENDED ARABIC-INDIC DIGITS
+		return !hasScript(func(r rune) bool { return r >= 0x0660 && r <= 0x0669 })
+	}
+	return false
+}
+
+// Bidi classes, as far as the bidi rule needs them.
+const (
+	bidiL = iota
+	bidiR
+	bidiAL
+	bidiAN
+	bidiEN
+	bidiES
+	bidiBN
+	bidiNSM
+	bidiON
+)
+
+// bidiClass gets the bidi class of a code point in a label; this is derived
+// from the script and category, which is correct for everything that's
+// allowed in a label, except for some rare marks and digits.
+func bidiClass(r rune) int {
+	switch {
+	case r >= '0' && r <= '9', r >= 0x06f0 && r <= 0x06f9:
+		return bidiEN
+	case r >= 0x0660 && r <= 0x0669, r == 0x066b, r == 0x066c:
+		return bidiAN
+	case r == '-':
+		return bidiES
+	case r == 0x200c, r == 0x200d:
+		return bidiBN
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return bidiNSM
+	case unicode.In(r, unicode.Arabic, unicode.Syriac, unicode.Thaana):
+		return bidiAL
+	case unicode.In(r, unicode.Hebrew, unicode.Nko, unicode.Samaritan, unicode.Mandaic,
+		unicode.Adlam, unicode.Hanifi_Rohingya, unicode.Yezidi):
+		return bidiR
+	case unicode.In(r, unicode.L, unicode.Mc, unicode.Nd):
+		return bidiL
+	}
+	return bidiON
+}
+
+// checkBidi checks the bidi rule from RFC 5893 section 2.
+func checkBidi(l []rune) error {
+	last := bidiNSM
+	for i := len(l) - 1; i >= 0 && last == bidiNSM; i-- {
+		last = bidiClass(l[i])
+	}
+
+	switch bidiClass(l[0]) {
+	case bidiR, bidiAL:
+		var en, an bool
+		for _, r := range l {
+			switch bidiClass(r) {
+			case bidiL:
+				return errors.New("left-to-right character in a right-to-left label")
+			case bidiEN:
+				en = true
+			case bidiAN:
+				an = true
+			}
+		}
+		if last != bidiR && last != bidiAL && last != bidiEN && last != bidiAN {
+			return errors.New("right-to-left label doesn't end with a letter or digit")
+		}
+		if en && an {
+			return errors.New("both European and Arabic-Indic digits in a right-to-left label")
+		}
+	case bidiL:
+		for _, r := range l {
+			if c := bidiClass(r); c == bidiR || c == bidiAL || c == bidiAN {
+				return errors.New("right-to-left character in a left-to-right label")
+			}
+		}
+		if last != bidiL && last != bidiEN {
+			return errors.New("left-to-right label doesn't end with a letter or digit")
+		}
+	default:
+		return errors.New("doesn't start with a letter, which is required in a name with right-to-left labels")
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func punyAdapt(delta, n int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / n
+	k := 0
+	for delta > ((punyBase-punyTmin)*punyTmax)/2 {
+		delta /= punyBase - punyTmin
+		k += punyBase
+	}
+	return k + (punyBase-punyTmin+1)*delta/(delta+punySkew)
+}
+
+// punyThreshold gets the threshold t for position k.
+func punyThreshold(k, bias int) int {
+	t := k - bias
+	if t < punyTmin {
+		return punyTmin
+	}
+	if t > punyTmax {
+		return punyTmax
+	}
+	return t
+}
+
+var errPunycode = errors.New("invalid punycode")
+
+func punyEncode(s string) (string, error) {
+	runes := []rune(s)
+	out := make([]byte, 0, len(s)+8)
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h < len(runes) {
+		m := -1
+		for _, r := range runes {
+			if int(r) >= n && (m == -1 || int(r) < m) {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (h + 1)
+		if delta < 0 {
+			return "", errPunycode
+		}
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, digit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, digit(q))
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punyDecode(s string) (string, error) {
+	var out []rune
+	pos := 0
+	if b := strings.LastIndexByte(s, '-'); b >= 0 {
+		for _, r := range s[:b] {
+			if r >= 0x80 {
+				return "", errPunycode
+			}
+			out = append(out, r)
+		}
+		pos = b + 1
+	}
+
+	i, n, bias := 0, punyInitialN, punyInitialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(s) {
+				return "", errPunycode
+			}
+			c := s[pos]
+			pos++
+
+			var d int
+			switch {
+			case c >= 'a' && c <= 'z':
+				d = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				d = int(c - 'A')
+			case c >= '0' && c <= '9':
+				d = int(c-'0') + 26
+			default:
+				return "", errPunycode
+			}
+
+			i += d * w
+			if i > 0x10FFFF*(len(out)+1) { // Overflow; can't be valid.
+				return "", errPunycode
+			}
+			t := punyThreshold(k, bias)
+			if d < t {
+				break
+			}
+			w *= punyBase - t
+		}
+
+		bias = punyAdapt(i-oldi, len(out)+1, oldi == 0)
+		n += i / (len(out) + 1)
+		i %= len(out) + 1
+		if n > 0x10FFFF {
+			return "", errPunycode
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = rune(n)
+		i++
+	}
+	return string(out), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPunycode(t *testing.T) {
+	// Samples from RFC 3492 section 7.1.
+	tests := []struct {
+		in   []rune
+		want string
+	}{
+		{[]rune{0x0644, 0x064A, 0x0647, 0x0645, 0x0627, 0x0628, 0x062A, 0x0643, 0x0644, 0x0645, 0x0648, 0x0634, 0x0639, 0x0631, 0x0628, 0x064A, 0x061F},
+			"egbpdaj6bu4bxfgehfvwxn"},
+		{[]rune{0x4ED6, 0x4EEC, 0x4E3A, 0x4EC0, 0x4E48, 0x4E0D, 0x8BF4, 0x4E2D, 0x6587},
+			"ihqwcrb4cv8a8dqg056pqjye"},
+		{[]rune{0x4ED6, 0x5011, 0x7232, 0x4EC0, 0x9EBD, 0x4E0D, 0x8AAA, 0x4E2D, 0x6587},
+			"ihqwctvzc91f659drss3x8bo0yb"},
+		{[]rune("Pročprostěnemluvíčesky"),
+			"Proprostnemluvesky-uyb24dma41a"},
+		{[]rune{0x05DC, 0x05DE, 0x05D4, 0x05D4, 0x05DD, 0x05E4, 0x05E9, 0x05D5, 0x05D8, 0x05DC, 0x05D0, 0x05DE, 0x05D3, 0x05D1, 0x05E8, 0x05D9, 0x05DD, 0x05E2, 0x05D1, 0x05E8, 0x05D9, 0x05EA},
+			"4dbcagdahymbxekheh6e0a7fei0b"},
+		{[]rune{0x092F, 0x0939, 0x0932, 0x094B, 0x0917, 0x0939, 0x093F, 0x0928, 0x094D, 0x0926, 0x0940, 0x0915, 0x094D, 0x092F, 0x094B, 0x0902, 0x0928, 0x0939, 0x0940, 0x0902, 0x092C, 0x094B, 0x0932, 0x0938, 0x0915, 0x0924, 0x0947, 0x0939, 0x0948, 0x0902},
+			"i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd"},
+		{[]rune{0x306A, 0x305C, 0x307F, 0x3093, 0x306A, 0x65E5, 0x672C, 0x8A9E, 0x3092, 0x8A71, 0x3057, 0x3066, 0x304F, 0x308C, 0x306A, 0x3044, 0x306E, 0x304B},
+			"n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+		{[]rune{0xC138, 0xACC4, 0xC758, 0xBAA8, 0xB4E0, 0xC0AC, 0xB78C, 0xB4E4, 0xC774, 0xD55C, 0xAD6D, 0xC5B4, 0xB97C, 0xC774, 0xD574, 0xD55C, 0xB2E4, 0xBA74, 0xC5BC, 0xB9C8, 0xB098, 0xC88B, 0xC744, 0xAE4C},
+			"989aomsvi5e83db1d2a355cv1e0vak1dwrv93d5xbh15a0dt30a5jpsd879ccm6fea98c"},
+		{[]rune{0x043F, 0x043E, 0x0447, 0x0435, 0x043C, 0x0443, 0x0436, 0x0435, 0x043E, 0x043D, 0x0438, 0x043D, 0x0435, 0x0433, 0x043E, 0x0432, 0x043E, 0x0440, 0x044F, 0x0442, 0x043F, 0x043E, 0x0440, 0x0443, 0x0441, 0x0441, 0x043A, 0x0438},
+			"b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{[]rune("PorquénopuedensimplementehablarenEspañol"),
+			"PorqunopuedensimplementehablarenEspaol-fmd56a"},
+		{[]rune("TạisaohọkhôngthểchỉnóitiếngViệt"),
+			"TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g"},
+		{[]rune("3年B組金八先生"),
+			"3B-ww4c5e180e575a65lsy2b"},
+		{[]rune("安室奈美恵-with-SUPER-MONKEYS"),
+			"-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+		{[]rune("Hello-Another-Way-それぞれの場所"),
+			"Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+		{[]rune("ひとつ屋根の下2"),
+			"2-u9tlzr9756bt3uc0v"},
+		{[]rune("MajiでKoiする5秒前"),
+			"MajiKoi5-783gue6qz075azm5e"},
+		{[]rune("パフィーdeルンバ"),
+			"de-jg4avhby1noc0d"},
+		{[]rune("そのスピードで"),
+			"d9juau41awczczp"},
+		{[]rune("-> $1.00 <-"),
+			"-> $1.00 <--"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			have, err := punyEncode(string(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != tt.want {
+				t.Errorf("encode\nhave: %q\nwant: %q", have, tt.want)
+			}
+
+			dec, err := punyDecode(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dec != string(tt.in) {
+				t.Errorf("decode\nhave: %q\nwant: %q", dec, string(tt.in))
+			}
+			if up, err := punyDecode(strings.ToUpper(tt.want)); err != nil || strings.ToLower(up) != strings.ToLower(dec) {
+				t.Errorf("decode uppercase: %q %v", up, err)
+			}
+		})
+	}
+
+	for _, in := range []string{"a!b", "99999999999", "z", "ü-abc"} {
+		if have, err := punyDecode(in); err == nil {
+			t.Errorf("%q: no error; decoded to %q", in, have)
+		}
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		in, want, wantErr string
+	}{
+		{"example.com", "example.com", ""},
+		{"_sip._udp.Example.com", "_sip._udp.example.com", ""},
+		{"*.example.com", "*.example.com", ""},
+		{"bücher.example", "xn--bcher-kva.example", ""},
+		{"BÜCHER.example", "xn--bcher-kva.example", ""},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", ""},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example", ""},
+		{"münchen.de", "xn--mnchen-3ya.de", ""},
+		{"faß.de", "xn--fa-hia.de", ""},
+		{"日本語.jp", "xn--wgv71a119e.jp", ""},
+		{"l·l.cat", "", ""},
+		{"\u0915\u094d\u200d\u0937.in", "", ""},
+		{"א׳.il", "", ""},
+		{"ア・イ.jp", "", ""},
+		{"͵α.gr", "", ""},
+		{"שלום.com", "", ""},
+		{"ا١.example", "", ""},
+
+		// Not valid in the ASCII form.
+		{"xn--.example", "", "doesn't encode a Unicode name"},
+		{"xn--abc-.example", "", "doesn't encode a Unicode name"},
+		{"xn--a!b.example", "", "invalid punycode"},
+		{"xn--ls8h.la", "", "disallowed code point U+1F4A9"},
+
+		// Not valid in the Unicode form.
+		{"a☃b.example", "", "disallowed code point U+2603"},
+		{"a\u00a0b.example", "", "disallowed code point U+00A0"},
+		{"ｅxampleé.com", "", "disallowed code point U+FF45"},
+		{"ﬁé.com", "", "disallowed code point U+FB01"},
+		{"a\u00adb\u00e9.com", "", "disallowed code point U+00AD"},
+		{"u\u0308ber.de", "", "isn't in NFC"},
+		{"\u0308a.de", "", "starts with a combining mark"},
+		{"-bü.de", "", "starts or ends with a hyphen"},
+		{"bü-.de", "", "starts or ends with a hyphen"},
+		{"ab--ü.de", "", "third and fourth position"},
+		{strings.Repeat("ü", 60) + ".de", "", "longer than 63"},
+
+		// Context rules.
+		{"a·b.cat", "", "U+00B7 not allowed in this context"},
+		{"a\u200db\u00e9.example", "", "U+200D not allowed in this context"},
+		{"é׳.example", "", "U+05F3 not allowed in this context"},
+		{"é・b.example", "", "U+30FB not allowed in this context"},
+		{"͵é.example", "", "U+0375 not allowed in this context"},
+		{"ا١۱.example", "", "U+0661 not allowed in this context"},
+
+		// Bidi rule.
+		{"שa.com", "", "left-to-right character in a right-to-left label"},
+		{"1ש.com", "", "doesn't start with a letter"},
+		{"שלום.éש", "", "right-to-left character in a left-to-right label"},
+		{"ا1١.com", "", "both European and Arabic-Indic digits"},
+		{"של-.com", "", "hyphen"},
+		{"é1.ש", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have, err := toASCII(tt.in)
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if tt.want != "" && have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+			if !isASCII(have) {
+				t.Errorf("not ASCII: %q", have)
+			}
+
+			// Round trip.
+			u := toUnicode(have)
+			if want := strings.ToLower(toUnicode(strings.ToLower(tt.in))); u != want {
+				t.Errorf("toUnicode\nhave: %q\nwant: %q", u, want)
+			}
+			if a, err := toASCII(u); err != nil || a != have {
+				t.Errorf("toASCII(toUnicode)\nhave: %q %v\nwant: %q", a, err, have)
+			}
+		})
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com.", "example.com."},
+		{"xn--bcher-kva.example.", "bücher.example."},
+		{"a.xn--wgv71a119e.jp", "a.日本語.jp"},
+
+		// Invalid names are kept as they are.
+		{"xn--.example.", "xn--.example."},
+		{"xn--abc-.example.", "xn--abc-.example."},
+		{"xn--a!b.example.", "xn--a!b.example."},
+		{"xn--ls8h.la.", "xn--ls8h.la."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have := toUnicode(tt.in)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
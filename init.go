@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// The init command writes a config with the A and AAAA records in the account:
+//
+//   transip-dynamic init
+//   transip-dynamic init -user me -key-file priv.pem -o /etc/transip-dynamic
+//
+// It asks for the username and key file if they're not given as flags. See the
+// config file in the source for all the other settings.
+
+func initConfig(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	user := fs.String("user", "", "TransIP username")
+	keyFile := fs.String("key-file", "", "path to the private key")
+	api := fs.String("api", "api.transip.nl", "API endpoint")
+	out := fs.String("o", "config", "write the config to this file")
+	pos, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 0 {
+		return fmt.Errorf("usage: %v init [-user USER] [-key-file FILE] [-api HOST] [-o FILE]", os.Args[0])
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%v already exists; use -o to write the config to another file", *out)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if *user == "" {
+		*user, err = prompt(in, "TransIP username")
+		if err != nil {
+			return err
+		}
+	}
+	if *keyFile == "" {
+		*keyFile, err = prompt(in, "Path to the private key")
+		if err != nil {
+			return err
+		}
+	}
+	// Use an absolute path, as it's relative to the working directory and not
+	// the config file.
+	if abs, err := filepath.Abs(*keyFile); err == nil && *keyFile != "-" {
+		*keyFile = abs
+	}
+
+	setDefaults()
+	config.User, config.KeyFile, config.API = *user, *keyFile, *api
+	config.key, err = readEncryptedKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	config.apiClient = newAPIClient()
+
+	data, err := soapRequest(ctx, transip.GetDomainNames())
+	if err != nil {
+		return fmt.Errorf("cannot get the domains: %v", err)
+	}
+	domains, err := transip.ParseDomainNames(data)
+	if err != nil {
+		return fmt.Errorf("cannot get the domains: %v", err)
+	}
+	if len(domains) == 0 {
+		return fmt.Errorf("there are no domains in the account of %v", *user)
+	}
+
+	var records []string
+	for _, d := range domains {
+		info, err := fetchDomain(ctx, d)
+		if err != nil {
+			return fmt.Errorf("cannot get domain %v: %v", toUnicode(d), err)
+		}
+		for _, i := range info {
+			r := toUnicode(strings.TrimSuffix(i.FQDN, "."))
+			if (i.Type == "A" || i.Type == "AAAA") && !inList(records, r) {
+				records = append(records, r)
+			}
+		}
+	}
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "# Written by \"transip-dynamic init\" at %v; see the config file in the\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(b, "# source for all settings.\n\n")
+	fmt.Fprintf(b, "user %v\nkey-file %v\napi %v\n", *user, *keyFile, *api)
+	fmt.Fprintf(b, "get-ip icanhazip.com\n\n")
+	if len(records) == 0 {
+		fmt.Fprintf(b, "# There are no A or AAAA records in %v; add the records to update here.\n", strings.Join(domains, ", "))
+		fmt.Fprintf(b, "#record home.%v\n", toUnicode(domains[0]))
+	} else {
+		fmt.Fprintf(b, "# The A and AAAA records in the account; remove the ones that shouldn't be\n")
+		fmt.Fprintf(b, "# set to the address of this host.\n")
+		for _, r := range records {
+			fmt.Fprintf(b, "record %v\n", r)
+		}
+	}
+
+	fp, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(b.Bytes())
+	if cErr := fp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+
+	infof("wrote %v with %d records from %d domains; check the records, and run %v -config %v plan to see what would change",
+		*out, len(records), len(domains), os.Args[0], *out)
+	return nil
+}
+
+// prompt asks for a value on stdin.
+func prompt(in *bufio.Reader, q string) (string, error) {
+	for {
+		fmt.Fprintf(os.Stderr, "%v: ", q)
+		l, err := in.ReadString('\n')
+		if l = strings.TrimSpace(l); l != "" {
+			return l, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("no value given for %q", q)
+		}
+	}
+}
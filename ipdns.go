@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Instead of a "what's my IP" HTTP service get-ip can also be one of these
+// DNS services, which return the address the query came from:
+//
+//   dns:opendns      myip.opendns.com on resolver1.opendns.com
+//   dns:cloudflare   whoami.cloudflare (CH TXT) on 1.1.1.1
+//   dns:google       o-o.myaddr.l.google.com (TXT) on ns1.google.com
+//   dns:akamai       whoami.akamai.net on ns1-1.akamaitech.net; IPv4 only.
+//
+// This is a single UDP packet per family, which is faster and more reliable
+// than HTTP. The servers are queried directly by address, so Resolver isn't
+// used for this.
+
+type dnsIPService struct {
+	name    string
+	qtype4  uint16 // Type for IPv4; the IPv6 query uses AAAA instead of A.
+	qclass  uint16
+	server4 string
+	server6 string
+}
+
+var dnsIPServices = map[string]dnsIPService{
+	"opendns":    {"myip.opendns.com", typeA, classIN, "208.67.222.222", "2620:119:35::35"},
+	"cloudflare": {"whoami.cloudflare", typeTXT, classCH, "1.1.1.1", "2606:4700:4700::1111"},
+	"google":     {"o-o.myaddr.l.google.com", typeTXT, classIN, "216.239.32.10", "2001:4860:4802:32::a"},
+	"akamai":     {"whoami.akamai.net", typeA, classIN, "193.108.88.1", ""},
+}
+
+// validDNSIPService checks that host is a known DNS service.
+func validDNSIPService(host string) error {
+	if _, ok := dnsIPServices[strings.TrimPrefix(host, "dns:")]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(dnsIPServices))
+	for n := range dnsIPServices {
+		names = append(names, "dns:"+n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown DNS IP service %q; must be one of %v", host, strings.Join(names, ", "))
+}
+
+// ipFromDNS gets the IP addresses from one of the DNS services; the return
+// values are the same as ipFromService.
+func ipFromDNS(ctx context.Context, host string) (*ipT, bool, bool, error) {
+	if err := validDNSIPService(host); err != nil {
+		return nil, false, false, err
+	}
+	svc := dnsIPServices[strings.TrimPrefix(host, "dns:")]
+
+	query := func(is6 bool) string {
+		server, qtype, name := svc.server4, svc.qtype4, "IPv4"
+		if is6 {
+			server, name = svc.server6, "IPv6"
+			if qtype == typeA {
+				qtype = typeAAAA
+			}
+		}
+
+		rrs, err := dnsQuery(ctx, server, svc.name, qtype, svc.qclass, false)
+		if err != nil {
+			warnf("cannot find %v address with %v: %v", name, host, err)
+			return ""
+		}
+		ip := dnsIPAnswer(rrs, qtype, is6)
+		if ip == "" {
+			warnf("cannot find %v address with %v: no address in the answer", name, host)
+		}
+		return ip
+	}
+
+	var (
+		tried4 = hasFamily("udp4")
+		tried6 = svc.server6 != "" && hasFamily("udp6")
+		ip     = &ipT{}
+		ch     = make(chan struct{}, 2)
+	)
+	if tried4 {
+		go func() { ip.IPv4 = query(false); ch <- struct{}{} }()
+	}
+	if tried6 {
+		go func() { ip.IPv6 = query(true); ch <- struct{}{} }()
+	}
+	if tried4 {
+		<-ch
+	}
+	if tried6 {
+		<-ch
+	}
+	return ip, tried4, tried6, nil
+}
+
+// dnsIPAnswer gets the address from the answer by one of the DNS services, or
+// "" if there isn't one.
+func dnsIPAnswer(rrs []dnsRR, qtype uint16, is6 bool) string {
+	for _, rr := range rrs {
+		if rr.Type != qtype {
+			continue
+		}
+		// Google's TXT record is sometimes an "edns0-client-subnet" record as
+		// well; only use the value that's an address.
+		ip := net.ParseIP(strings.TrimSpace(rr.Value))
+		if ip != nil && (ip.To4() == nil) == is6 {
+			return ip.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestDNSIPAnswer(t *testing.T) {
+	v4, v6 := []byte{198, 51, 100, 1}, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	tests := []struct {
+		svc  string
+		is6  bool
+		rrs  [][]byte
+		want string
+	}{
+		{"opendns", false, [][]byte{dnsAnswer(typeA, classIN, 0, v4)}, "198.51.100.1"},
+		{"opendns", true, [][]byte{dnsAnswer(typeAAAA, classIN, 0, v6)}, "2001:db8::1"},
+		{"opendns", false, nil, ""},
+		{"opendns", true, [][]byte{dnsAnswer(typeAAAA, classIN, 0, []byte{15: 0, 10: 0xff, 11: 0xff, 12: 198, 13: 51, 14: 100})}, ""},
+
+		{"akamai", false, [][]byte{dnsAnswer(typeA, classIN, 20, v4)}, "198.51.100.1"},
+		{"akamai", false, [][]byte{
+			dnsAnswer(typeCNAME, classIN, 20, []byte{0xc0, 0x0c}),
+			dnsAnswer(typeA, classIN, 20, v4),
+		}, "198.51.100.1"},
+
+		{"cloudflare", false, [][]byte{dnsAnswer(typeTXT, classCH, 0, dnsStrings("198.51.100.1"))}, "198.51.100.1"},
+		{"cloudflare", true, [][]byte{dnsAnswer(typeTXT, classCH, 0, dnsStrings("2001:db8::1"))}, "2001:db8::1"},
+		{"cloudflare", true, [][]byte{dnsAnswer(typeTXT, classCH, 0, dnsStrings("198.51.100.1"))}, ""},
+		{"cloudflare", false, [][]byte{dnsAnswer(typeTXT, classCH, 0, dnsStrings("not an address"))}, ""},
+
+		{"google", false, [][]byte{
+			dnsAnswer(typeTXT, classIN, 60, dnsStrings("edns0-client-subnet 198.51.100.0/24")),
+			dnsAnswer(typeTXT, classIN, 60, dnsStrings("198.51.100.1")),
+		}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.svc, func(t *testing.T) {
+			svc := dnsIPServices[tt.svc]
+			qtype := svc.qtype4
+			if tt.is6 && qtype == typeA {
+				qtype = typeAAAA
+			}
+
+			rrs, err := dnsParse(dnsResp(42, 0, svc.name, qtype, svc.qclass, tt.rrs...), 42)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rr := range rrs {
+				if rr.Name != svc.name+"." || rr.Class != svc.qclass {
+					t.Errorf("wrong RR: %#v", rr)
+				}
+			}
+
+			have := dnsIPAnswer(rrs, qtype, tt.is6)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidDNSIPService(t *testing.T) {
+	for _, h := range []string{"dns:opendns", "dns:cloudflare", "dns:google", "dns:akamai"} {
+		if err := validDNSIPService(h); err != nil {
+			t.Errorf("%v: %v", h, err)
+		}
+	}
+	err := validDNSIPService("dns:example")
+	if !errorContains(err, "must be one of dns:akamai, dns:cloudflare, dns:google, dns:opendns") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
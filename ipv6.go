@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A host often has several global IPv6 addresses (EUI-64, stable-privacy,
+// temporary privacy addresses), and the address the IP detection sees is
+// whatever the OS happened to pick for that connection. The IPv6Policy setting
+// selects one of the local addresses instead.
+
+// ipv6Policy is how to select the IPv6 address.
+type ipv6Policy struct {
+	Kind   string // "", "eui64", "stable-privacy", "prefix", "suffix"
+	Prefix *net.IPNet
+	Suffix net.IP
+}
+
+func parseIPv6Policy(v []string) (ipv6Policy, error) {
+	p := ipv6Policy{Kind: v[0]}
+	switch p.Kind {
+	case "detected":
+		p.Kind = ""
+	case "eui64", "stable-privacy":
+		if len(v) != 1 {
+			return p, fmt.Errorf("%v doesn't take an argument", p.Kind)
+		}
+	case "prefix":
+		if len(v) != 2 {
+			return p, fmt.Errorf("prefix needs a prefix, e.g. 2001:db8:1::/48")
+		}
+		_, n, err := net.ParseCIDR(v[1])
+		if err != nil || n.IP.To4() != nil {
+			return p, fmt.Errorf("invalid IPv6 prefix %q", v[1])
+		}
+		p.Prefix = n
+	case "suffix":
+		if len(v) != 2 {
+			return p, fmt.Errorf("suffix needs an interface ID, e.g. ::1:2:3:4")
+		}
+		p.Suffix = net.ParseIP(v[1])
+		if p.Suffix == nil || p.Suffix.To4() != nil {
+			return p, fmt.Errorf("invalid IPv6 suffix %q", v[1])
+		}
+	default:
+		return p, fmt.Errorf("unknown policy %q; must be detected, eui64, stable-privacy, prefix, or suffix",
+			p.Kind)
+	}
+	return p, nil
+}
+
+// localAddr is a local IPv6 address.
+type localAddr struct {
+	IP    net.IP
+	Iface string
+
+	// Only known on Linux.
+	Temporary, StablePrivacy bool
+}
+
+// Address flags from linux/if_addr.h
+const (
+	ifaTemporary     = 0x01
+	ifaDadFailed     = 0x08
+	ifaDeprecated    = 0x20
+	ifaTentative     = 0x40
+	ifaStablePrivacy = 0x800
+)
+
+// localIPv6 gets all usable global IPv6 addresses on this host. The address
+// flags are read from /proc on Linux, since the net package doesn't expose
+// them; on other systems we just get the addresses.
+func localIPv6() ([]localAddr, error) {
+	var addrs []localAddr
+	usable := func(ip net.IP) bool {
+		return ip.To4() == nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+	}
+
+	fp, err := os.Open("/proc/net/if_inet6")
+	if err == nil {
+		defer fp.Close()
+
+		// 20010db8000000000000000000000001 02 40 00 80 eth0
+		scan := bufio.NewScanner(fp)
+		for scan.Scan() {
+			f := strings.Fields(scan.Text())
+			if len(f) < 6 {
+				continue
+			}
+			b, err := hex.DecodeString(f[0])
+			if err != nil || len(b) != 16 {
+				continue
+			}
+			flags, err := strconv.ParseUint(f[4], 16, 32)
+			if err != nil {
+				continue
+			}
+
+			ip := net.IP(b)
+			if !usable(ip) || flags&(ifaDeprecated|ifaTentative|ifaDadFailed) != 0 {
+				continue
+			}
+			addrs = append(addrs, localAddr{
+				IP:            ip,
+				Iface:         f[5],
+				Temporary:     flags&ifaTemporary != 0,
+				StablePrivacy: flags&ifaStablePrivacy != 0,
+			})
+		}
+		return addrs, scan.Err()
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		ia, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range ia {
+			if n, ok := a.(*net.IPNet); ok && usable(n.IP) {
+				addrs = append(addrs, localAddr{IP: n.IP, Iface: iface.Name})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// isEUI64 reports if the interface ID is a modified EUI-64 derived from a MAC
+// address (xx:xx:xx:ff:fe:xx:xx:xx).
+func isEUI64(ip net.IP) bool {
+	ip = ip.To16()
+	return ip[11] == 0xff && ip[12] == 0xfe
+}
+
+// selectIPv6 selects a local address according to policy p. If detected isn't
+// empty addresses in the same /64 are preferred, as that's the network we know
+// can reach the internet (except for the prefix policy, which already says
+// which network to use).
+func selectIPv6(p ipv6Policy, detected string, addrs []localAddr) (string, error) {
+	if p.Kind == "" {
+		return detected, nil
+	}
+
+	if d := net.ParseIP(detected); d != nil && p.Kind != "prefix" {
+		n := &net.IPNet{IP: d.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+		var same []localAddr
+		for _, a := range addrs {
+			if n.Contains(a.IP) {
+				same = append(same, a)
+			}
+		}
+		if len(same) > 0 {
+			addrs = same
+		}
+	}
+
+	match := func(a localAddr) bool {
+		switch p.Kind {
+		case "eui64":
+			return isEUI64(a.IP)
+		case "stable-privacy":
+			return a.StablePrivacy
+		case "prefix":
+			return p.Prefix.Contains(a.IP)
+		case "suffix":
+			return bytes.Equal(a.IP.To16()[8:], p.Suffix.To16()[8:])
+		}
+		return false
+	}
+
+	// Temporary addresses are never what you want in DNS.
+	for _, a := range addrs {
+		if !a.Temporary && match(a) {
+			return a.IP.String(), nil
+		}
+	}
+
+	// We can only see the stable-privacy flag on Linux; fall back to anything
+	// that's not EUI-64.
+	if p.Kind == "stable-privacy" {
+		for _, a := range addrs {
+			if !a.Temporary && !isEUI64(a.IP) {
+				return a.IP.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no local IPv6 address matches the %v policy", p.Kind)
+}
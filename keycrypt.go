@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// The key file can be encrypted, as written by e.g.:
+//
+//   openssl pkcs8 -topk8 -in plain.pem -out encrypted.pem
+//
+// The passphrase is the output of key-passphrase-cmd, or from the environment
+// variable in key-passphrase-env; if neither is set it's asked on the terminal.
+//
+//   key-passphrase-cmd pass show transip-key
+//
+// Both PKCS#8 keys ("BEGIN ENCRYPTED PRIVATE KEY"; PBES2 with AES-CBC) and the
+// older OpenSSL format ("Proc-Type: 4,ENCRYPTED") are supported.
+
+var errKeyEncrypted = errors.New("key is encrypted")
+
+var (
+	// The passphrase from the terminal, so it's only asked once.
+	typedPassphrase   []byte
+	typedPassphraseMu sync.Mutex
+)
+
+// decryptKeys reads the key files that were skipped when reading the config
+// because they're encrypted.
+func decryptKeys() error {
+	for _, k := range []struct {
+		file string
+		key  **rsa.PrivateKey
+	}{
+		{config.KeyFile, &config.key},
+		{config.SecondaryKeyFile, &config.secondaryKey},
+	} {
+		if k.file == "" || *k.key != nil || strings.HasPrefix(k.file, "$") {
+			continue
+		}
+		var err error
+		*k.key, err = readEncryptedKey(k.file)
+		if err != nil {
+			return fmt.Errorf("%v: %v", k.file, err)
+		}
+	}
+	return nil
+}
+
+// readEncryptedKey reads a key file, decrypting it if needed.
+func readEncryptedKey(file string) (*rsa.PrivateKey, error) {
+	data, err := keyData(file)
+	if err != nil {
+		return nil, err
+	}
+	return decryptKey(data)
+}
+
+// decryptKey parses a PEM-encoded key, decrypting it if needed.
+func decryptKey(data []byte) (*rsa.PrivateKey, error) {
+	key, err := parseKey(data)
+	if err != errKeyEncrypted {
+		return key, err
+	}
+
+	pass, typed, err := keyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	var der []byte
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err = decryptPKCS8(block.Bytes, pass)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	} else {
+		//lint:ignore SA1019 the format is insecure, but it's what older keys use.
+		der, err = x509.DecryptPEMBlock(block, pass)
+		block = &pem.Block{Type: block.Type, Bytes: der}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err = parseKey(pem.EncodeToMemory(block))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only remember a typed passphrase once it's known to be correct, so a typo
+	// doesn't make every reload fail.
+	if typed {
+		typedPassphraseMu.Lock()
+		typedPassphrase = pass
+		typedPassphraseMu.Unlock()
+	}
+	return key, nil
+}
+
+// keyPassphrase gets the passphrase for an encrypted key; typed is true if it
+// was asked on the terminal.
+func keyPassphrase() (pass []byte, typed bool, err error) {
+	switch {
+	case len(config.KeyPassphraseCmd) > 0:
+		c := exec.Command(config.KeyPassphraseCmd[0], config.KeyPassphraseCmd[1:]...)
+		c.Stderr = os.Stderr
+		out, err := c.Output()
+		if err != nil {
+			return nil, false, fmt.Errorf("key-passphrase-cmd: %v", err)
+		}
+		return bytes.TrimRight(out, "\r\n"), false, nil
+	case config.KeyPassphraseEnv != "":
+		p, ok := os.LookupEnv(config.KeyPassphraseEnv)
+		if !ok {
+			return nil, false, fmt.Errorf("key-passphrase-env: $%v is not set", config.KeyPassphraseEnv)
+		}
+		return []byte(p), false, nil
+	}
+
+	typedPassphraseMu.Lock()
+	defer typedPassphraseMu.Unlock()
+	if typedPassphrase != nil {
+		return typedPassphrase, true, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, false, errors.New("key is encrypted and there is no terminal to ask the passphrase; set key-passphrase-cmd or key-passphrase-env")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, "Passphrase for the TransIP key: ")
+	if stty(tty, "-echo") == nil {
+		defer func() {
+			stty(tty, "echo")
+			fmt.Fprintln(tty)
+		}()
+	}
+	l, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && l == "" {
+		return nil, false, fmt.Errorf("reading passphrase: %v", err)
+	}
+	return []byte(strings.TrimRight(l, "\r\n")), true, nil
+}
+
+func stty(tty *os.File, arg string) error {
+	c := exec.Command("stty", arg)
+	c.Stdin = tty
+	return c.Run()
+}
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// decryptPKCS8 decrypts an encrypted PKCS#8 key (RFC 5958), and returns the
+// unencrypted PKCS#8 key.
+func decryptPKCS8(der, pass []byte) ([]byte, error) {
+	var (
+		info struct {
+			Algo pkix.AlgorithmIdentifier
+			Data []byte
+		}
+		params struct {
+			KDF    pkix.AlgorithmIdentifier
+			Cipher pkix.AlgorithmIdentifier
+		}
+		kdf struct {
+			Salt   []byte
+			Iter   int
+			KeyLen int                      `asn1:"optional"`
+			PRF    pkix.AlgorithmIdentifier `asn1:"optional"`
+		}
+		iv []byte
+	)
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %v", err)
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption %v; only PBES2 is supported", info.Algo.Algorithm)
+	}
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %v", err)
+	}
+	if !params.KDF.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %v; only PBKDF2 is supported", params.KDF.Algorithm)
+	}
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %v", err)
+	}
+	if _, err := asn1.Unmarshal(params.Cipher.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key: %v", err)
+	}
+
+	var h func() hash.Hash
+	switch p := kdf.PRF.Algorithm; {
+	case len(p) == 0, p.Equal(oidHMACSHA1):
+		h = sha1.New
+	case p.Equal(oidHMACSHA256):
+		h = sha256.New
+	case p.Equal(oidHMACSHA512):
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 hash %v", p)
+	}
+	var keyLen int
+	switch c := params.Cipher.Algorithm; {
+	case c.Equal(oidAES128CBC):
+		keyLen = 16
+	case c.Equal(oidAES192CBC):
+		keyLen = 24
+	case c.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher %v; only AES-CBC is supported", c)
+	}
+
+	block, err := aes.NewCipher(pbkdf2(h, pass, kdf.Salt, kdf.Iter, keyLen))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.Data) == 0 || len(info.Data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted key")
+	}
+	out := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, info.Data)
+
+	// Remove the PKCS#7 padding; a wrong passphrase almost always gives
+	// invalid padding.
+	n := int(out[len(out)-1])
+	if n == 0 || n > block.BlockSize() || !bytes.Equal(out[len(out)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("wrong passphrase")
+	}
+	return out[:len(out)-n], nil
+}
+
+// pbkdf2 derives a key from the passphrase (RFC 8018).
+func pbkdf2(h func() hash.Hash, pass, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(h, pass)
+	var (
+		key []byte
+		buf [4]byte
+	)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], block)
+		prf.Write(buf[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// keygen generates a new key pair; the private key is written to path in the
+// PKCS#8 format that key-file expects, and the public key is printed on stdout.
+func keygen(path string) error {
+	if path == "" {
+		return errors.New("need a file to write the private key to")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	// Never overwrite an existing key.
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(fp, &pem.Block{Type: "PRIVATE KEY", Bytes: priv})
+	if cErr := fp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "transip-dynamic: wrote private key to %v; add this public key in the TransIP control panel:\n\n", path)
+	return pem.Encode(os.Stdout, &pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+}
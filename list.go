@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// The list command prints all records in a domain as they're stored in TransIP:
+//
+//   transip-dynamic list example.com
+//   transip-dynamic list -json example.com example.net
+//
+// Without a domain all domains from the config are listed.
+
+type listDomain struct {
+	Domain  string     `json:"domain"`
+	Meta    domainMeta `json:"meta"`
+	Records []Info     `json:"records"`
+}
+
+func list(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	names, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var domains []string
+	for _, n := range names {
+		d, _, err := splitRecord(n)
+		if err != nil {
+			return err
+		}
+		domains = append(domains, d)
+	}
+	if len(domains) == 0 {
+		domains = sortedDomains()
+	}
+	if len(domains) == 0 {
+		return errors.New("no domains in the config; give the domain to list as an argument")
+	}
+
+	var l []listDomain
+	for _, d := range domains {
+		info, err := fetchDomain(ctx, d)
+		if err != nil {
+			return fmt.Errorf("cannot get domain %v: %v", toUnicode(d), err)
+		}
+		m, _ := getDomainMeta(d)
+		l = append(l, listDomain{Domain: d, Meta: m, Records: info})
+	}
+
+	if *asJSON {
+		j, err := json.MarshalIndent(l, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(j, '\n'))
+		return err
+	}
+
+	for i, d := range l {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("; %v", toUnicode(d.Domain))
+		if d.Meta.RenewalDate != "" {
+			fmt.Printf(" (renewal on %v)", d.Meta.RenewalDate)
+		}
+		fmt.Println()
+		for _, r := range d.Records {
+			fmt.Println(r)
+		}
+	}
+	return nil
+}
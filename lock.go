@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// If several hosts behind the same connection run transip-dynamic they'll all
+// write the same zone. With LockRecord a TXT record with the hostname and time
+// is written, and a host skips the update if another host wrote it less than
+// LockTimeout ago. If that host stops running another one takes over after
+// LockTimeout.
+//
+// The lock record is set in the same request as the update if it's in one of
+// the domains that's updated. If nothing needs to be updated it's only written
+// when it's older than half of LockTimeout, so the other hosts don't take over.
+//
+// This is a best-effort lock: two hosts starting at the same time may both
+// update once, which is harmless as they'll write the same addresses.
+
+// heartbeat is the content of a lock record.
+type heartbeat struct {
+	Host string
+	Time time.Time
+}
+
+func (h heartbeat) String() string {
+	return fmt.Sprintf("transip-dynamic host=%v time=%v", h.Host, h.Time.UTC().Format(time.RFC3339))
+}
+
+// parseHeartbeat parses the content of a lock record.
+func parseHeartbeat(s string) (heartbeat, bool) {
+	var h heartbeat
+	for _, f := range strings.Fields(strings.Trim(s, `"`)) {
+		switch {
+		case strings.HasPrefix(f, "host="):
+			h.Host = f[5:]
+		case strings.HasPrefix(f, "time="):
+			h.Time, _ = time.Parse(time.RFC3339, f[5:])
+		}
+	}
+	return h, h.Host != "" && !h.Time.IsZero()
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return h
+}
+
+// setTXT sets the TXT record fqdn in info to content, adding it if it doesn't
+// exist yet.
+func setTXT(info []Info, domain, fqdn, content string) []Info {
+	for i := range info {
+		if info[i].FQDN == fqdn && info[i].Type == "TXT" {
+			info[i].Content = content
+			return info
+		}
+	}
+	info = append(info, Info{Name: recordName(fqdn, domain), Expire: 60, Type: "TXT", Content: content})
+	setFQDN(info[len(info)-1:], domain)
+	return info
+}
+
+// takeLock reports if we can update; update is set if records need to be
+// updated, rather than only refreshing the lock.
+//
+// The lock record is set to us with the update if it's in one of the domains
+// from Records (see addLock), and written here otherwise.
+func takeLock(ctx context.Context, update bool) (bool, error) {
+	if config.LockRecord == "" || (!update && !lockDue()) {
+		return true, nil
+	}
+
+	domain, fqdn, err := splitRecord(config.LockRecord)
+	if err != nil {
+		return false, err
+	}
+	// Don't use the cache, as that's what this is all about.
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return false, fmt.Errorf("cannot get lock record: %v", err)
+	}
+
+	me := hostname()
+	for _, i := range info {
+		if i.FQDN != fqdn || i.Type != "TXT" {
+			continue
+		}
+		h, ok := parseHeartbeat(i.Content)
+		if ok && h.Host != me && time.Since(h.Time) < config.LockTimeout {
+			infof("not updating: %v wrote %v at %v, less than %v ago",
+				h.Host, config.LockRecord, h.Time.Format(time.RFC3339), config.LockTimeout)
+			return false, nil
+		}
+	}
+
+	if _, ok := config.Records[domain]; ok && update {
+		return true, nil
+	}
+	err = sendUpdate(ctx, domain, addHeartbeat(domain, addLock(domain, info)))
+	if err != nil {
+		return false, fmt.Errorf("cannot set lock record: %v", err)
+	}
+	wroteLock(domain)
+	wroteHeartbeat(domain)
+	return true, nil
+}
+
+// lockDue reports if the lock record needs to be written even if nothing else
+// changed.
+func lockDue() bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return time.Since(readState().Lock) >= config.LockTimeout/2
+}
+
+// addLock sets the lock record in info if it's in domain.
+func addLock(domain string, info []Info) []Info {
+	if config.LockRecord == "" {
+		return info
+	}
+	lDomain, fqdn, err := splitRecord(config.LockRecord)
+	if err != nil || lDomain != domain {
+		return info
+	}
+	return setTXT(info, domain, fqdn, heartbeat{Host: hostname(), Time: time.Now()}.String())
+}
+
+// wroteLock records that domain was written, if the lock record is in it.
+func wroteLock(domain string) {
+	if config.LockRecord == "" {
+		return
+	}
+	if lDomain, _, err := splitRecord(config.LockRecord); err != nil || lDomain != domain {
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	st.Lock = time.Now()
+	writeState(st)
+}
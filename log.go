@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// All messages are written to stderr with debugf, infof, noticef, and warnf;
+// -log-level sets which are shown, and -log-format json writes them as a line
+// of JSON with the time and level:
+//
+//   {"time":"2026-01-02T15:04:05Z","level":"warn","msg":"cannot write state: ..."}
+//
+// The debug level includes all API requests and responses, with the signature
+// and token redacted.
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// Set from the -log-level and -log-format flags.
+var (
+	logMin    = levelInfo
+	logJSON   bool
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
+
+// setLog sets the log level and format from the flags.
+func setLog(level, format string) error {
+	l, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("-log-level must be debug, info, warn, or error, not %q", level)
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("-log-format must be text or json, not %q", format)
+	}
+	logMin, logJSON = l, format == "json"
+	return nil
+}
+
+func debugf(format string, args ...interface{})  { logf(levelDebug, "debug", format, args...) }
+func infof(format string, args ...interface{})   { logf(levelInfo, "", format, args...) }
+func noticef(format string, args ...interface{}) { logf(levelInfo, "notice", format, args...) }
+func warnf(format string, args ...interface{})   { logf(levelWarn, "warning", format, args...) }
+func errorf(format string, args ...interface{})  { logf(levelError, "error", format, args...) }
+
+// logf writes a message if l is at least logMin; label is the text after
+// "transip-dynamic" in the text format.
+func logf(l logLevel, label, format string, args ...interface{}) {
+	if l < logMin {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	if !logJSON {
+		if label != "" {
+			label = " " + label
+		}
+		fmt.Fprintf(logOutput, "transip-dynamic%v: %v\n", label, msg)
+		return
+	}
+
+	name := "info"
+	for k, v := range logLevels {
+		if v == l {
+			name = k
+		}
+	}
+	if label == "notice" {
+		name = label
+	}
+	j, _ := json.Marshal(struct {
+		Time  time.Time `json:"time"`
+		Level string    `json:"level"`
+		Msg   string    `json:"msg"`
+	}{time.Now().UTC(), name, msg})
+	logOutput.Write(append(j, '\n'))
+}
+
+var (
+	// Headers and JSON fields with credentials.
+	redactHeaders = []string{"Authorization", "Signature", "Cookie"}
+	reRedactJSON  = regexp.MustCompile(`("(?:token|signature)"\s*:\s*)"[^"]*"`)
+)
+
+// logRequests logs all requests, and the full request and response if the
+// level is debug.
+func logRequests(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if logMin > levelDebug {
+			return next.RoundTrip(req)
+		}
+
+		var reqBody []byte
+		if req.GetBody != nil {
+			if b, err := req.GetBody(); err == nil {
+				reqBody, _ = io.ReadAll(b)
+				b.Close()
+			}
+		}
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		took := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			debugf("%v %v: %v (%v)\n%v", req.Method, req.URL, err, took, dumpHTTP(req.Header, reqBody))
+			return resp, err
+		}
+
+		respBody, rErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		if rErr != nil {
+			return nil, rErr
+		}
+		debugf("%v %v: %v (%v)\n%v\n%v", req.Method, req.URL, resp.Status, took,
+			dumpHTTP(req.Header, reqBody), dumpHTTP(resp.Header, respBody))
+		return resp, nil
+	})
+}
+
+// dumpHTTP formats the headers and body for the debug log.
+func dumpHTTP(h http.Header, body []byte) string {
+	h = h.Clone()
+	for _, k := range redactHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, "[redacted]")
+		}
+	}
+
+	var b bytes.Buffer
+	h.Write(&b)
+	b.WriteString("\n")
+	b.Write(reRedactJSON.ReplaceAll(bytes.TrimSpace(body), []byte(`$1"[redacted]"`)))
+	return b.String()
+}
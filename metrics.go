@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With metrics-listen the daemon serves Prometheus metrics on /metrics, so you
+// can alert when the updates silently stop working, for example with:
+//
+//   time() - transip_dynamic_record_last_success_timestamp_seconds > 3600
+//
+// There's no authentication, as the metrics don't contain anything secret
+// other than the current address.
+
+type metricsT struct {
+	lastSuccess    map[string]time.Time // "fqdn" → time.
+	updateDuration map[string]time.Duration
+	updateErrors   int
+	ipFailures     int
+}
+
+var (
+	metrics   = metricsT{lastSuccess: make(map[string]time.Time), updateDuration: make(map[string]time.Duration)}
+	metricsMu sync.Mutex
+)
+
+// metricsUpdate records the result of updating a domain.
+func metricsUpdate(domain string, records []string, took time.Duration, err error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics.updateDuration[domain] = took
+	if err != nil {
+		metrics.updateErrors++
+		return
+	}
+	now := time.Now()
+	for _, r := range records {
+		metrics.lastSuccess[r] = now
+	}
+}
+
+// metricsUnchanged records that all records are already correct.
+func metricsUnchanged() {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	now := time.Now()
+	for _, records := range config.Records {
+		for _, r := range records {
+			metrics.lastSuccess[r] = now
+		}
+	}
+}
+
+// metricsIPFailure records that the IP couldn't be detected.
+func metricsIPFailure() {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics.ipFailures++
+}
+
+func serveMetrics() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(writeMetrics()))
+	})
+	configMu.RLock()
+	l, err := sdListen("metrics", config.MetricsListen)
+	configMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	infof("metrics listening on %v", l.Addr())
+	return http.Serve(l, mux)
+}
+
+// writeMetrics writes all metrics in the Prometheus text format.
+func writeMetrics() string {
+	var b strings.Builder
+	metric := func(name, typ, help string, values ...string) {
+		fmt.Fprintf(&b, "# HELP transip_dynamic_%v %v\n# TYPE transip_dynamic_%v %v\n", name, help, name, typ)
+		for _, v := range values {
+			fmt.Fprintf(&b, "transip_dynamic_%v%v\n", name, v)
+		}
+	}
+
+	statusMu.Lock()
+	st := status
+	statusMu.Unlock()
+	api := getAPIStats()
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	var success []string
+	for r, t := range metrics.lastSuccess {
+		success = append(success, fmt.Sprintf(`{record=%q} %d`, strings.TrimSuffix(r, "."), t.Unix()))
+	}
+	sort.Strings(success)
+	metric("record_last_success_timestamp_seconds", "gauge",
+		"Time the record was last confirmed to have the current address.", success...)
+
+	var took []string
+	for d, t := range metrics.updateDuration {
+		took = append(took, fmt.Sprintf(`{domain=%q} %g`, d, t.Seconds()))
+	}
+	sort.Strings(took)
+	metric("update_duration_seconds", "gauge", "Time the last update of the domain took.", took...)
+	metric("update_errors_total", "counter", "Number of failed domain updates.", fmt.Sprintf(" %d", metrics.updateErrors))
+	metric("ip_detection_failures_total", "counter", "Number of times the IP couldn't be detected.",
+		fmt.Sprintf(" %d", metrics.ipFailures))
+
+	var ips []string
+	if st.IP != nil {
+		for _, f := range [][2]string{{"ipv4", st.IP.IPv4}, {"ipv6", st.IP.IPv6}} {
+			if f[1] != "" {
+				ips = append(ips, fmt.Sprintf(`{family=%q,address=%q} 1`, f[0], f[1]))
+			}
+		}
+	}
+	metric("ip_info", "gauge", "Currently detected address.", ips...)
+	metric("ip_changes_total", "counter", "Number of times the detected address changed.", fmt.Sprintf(" %d", st.IPChanges))
+
+	metric("api_requests_total", "counter", "Number of TransIP API requests.", fmt.Sprintf(" %d", api.Requests))
+	metric("api_errors_total", "counter", "Number of failed TransIP API requests.", fmt.Sprintf(" %d", api.Errors))
+	metric("api_request_duration_seconds_total", "counter", "Total time spent on TransIP API requests.",
+		fmt.Sprintf(" %g", api.Latency.Seconds()))
+
+	metric("runs_total", "counter", "Number of runs.", fmt.Sprintf(" %d", st.Runs))
+	var last []string
+	if !st.LastRun.IsZero() {
+		last = append(last, fmt.Sprintf(" %d", st.LastRun.Unix()))
+	}
+	metric("last_run_timestamp_seconds", "gauge", "Time of the last run.", last...)
+	return b.String()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// With "-mock record" all API responses and the detected IP are stored in
+// -mock-dir, and "-mock replay" uses those instead of connecting to anything,
+// so you can try config changes without touching the real zones.
+//
+// Only the responses are stored, and never the request headers with the
+// signature. Responses are found by the method and domain name; the request body
+// is ignored, so changes to the records will still "work" when replaying.
+
+// Set from the -mock and -mock-dir flags.
+var mockMode, mockDir string
+
+// mockResponse is a recorded API response.
+type mockResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// setupMock checks the flags and sets up the config for the mock mode.
+func setupMock() error {
+	switch mockMode {
+	case "":
+		return nil
+	case "record":
+		err := os.MkdirAll(mockDir, 0700)
+		if err != nil {
+			return err
+		}
+	case "replay":
+		// Don't touch the real state.
+		config.StateDir = filepath.Join(mockDir, "state")
+	default:
+		return fmt.Errorf("-mock must be record or replay, not %q", mockMode)
+	}
+
+	// Always get the zones from the API (or recording).
+	config.CacheTTL = 0
+	return nil
+}
+
+func mockPath(name string) string {
+	return filepath.Join(mockDir, strings.NewReplacer("/", "_", "\\", "_").Replace(name)+".json")
+}
+
+// mock is a middleware which records or replays API responses.
+func mock(next http.RoundTripper) http.RoundTripper {
+	if mockMode == "" {
+		return next
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var name string
+		if call, ok := transip.CallFromContext(req.Context()); ok {
+			name = call.Service + "." + call.Method
+			if len(call.Params) > 0 {
+				name += "." + call.Params[0]
+			}
+		} else if p := strings.TrimPrefix(req.URL.Path, "/v6"); p != req.URL.Path {
+			// The token isn't recorded.
+			if p == "/auth" && mockMode == "replay" {
+				return mockResp(req, http.StatusOK, `{"token":"mock"}`), nil
+			}
+			if p == "/auth" {
+				return next.RoundTrip(req)
+			}
+			name = "rest." + req.Method + p
+		} else {
+			return next.RoundTrip(req)
+		}
+		path := mockPath(name)
+
+		if mockMode == "replay" {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("no recorded response for %v: %v", name, err)
+			}
+			var m mockResponse
+			err = json.Unmarshal(data, &m)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", path, err)
+			}
+			return mockResp(req, m.Status, m.Body), nil
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		data, err := json.MarshalIndent(mockResponse{Status: resp.StatusCode, Body: string(body)}, "", "\t")
+		if err == nil {
+			err = writeFileAtomic(path, data, 0600)
+		}
+		if err != nil {
+			warnf("cannot record %v: %v", name, err)
+		}
+		return resp, nil
+	})
+}
+
+func mockResp(req *http.Request, status int, body string) *http.Response {
+	ct := "text/xml; charset=utf-8"
+	if _, ok := transip.CallFromContext(req.Context()); !ok {
+		ct = "application/json"
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %v", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  http.Header{"Content-Type": {ct}},
+		Body:    ioutil.NopCloser(strings.NewReader(body)),
+		Request: req,
+	}
+}
+
+// replayIP gets the recorded IP.
+func replayIP() (*ipT, error) {
+	data, err := ioutil.ReadFile(mockPath("ip"))
+	if err != nil {
+		return nil, fmt.Errorf("no recorded IP: %v", err)
+	}
+	var ip ipT
+	err = json.Unmarshal(data, &ip)
+	return &ip, err
+}
+
+// recordIP stores the detected IP when recording.
+func recordIP(ip *ipT) {
+	if mockMode != "record" {
+		return
+	}
+	data, err := json.MarshalIndent(ip, "", "\t")
+	if err == nil {
+		err = writeFileAtomic(mockPath("ip"), data, 0600)
+	}
+	if err != nil {
+		warnf("cannot record IP: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// In monitor mode (-monitor) the daemon only detects the IP and checks for
+// drift, and records what it would do in the audit log; the changes are never
+// sent to the API. This is useful to see what it would do on a staging host, or
+// while migrating from another updater.
+
+var (
+	// Set from the -monitor flag.
+	monitorOnly bool
+
+	// Records that are currently different from TransIP; protected by runMu.
+	monitorDrifts = make(map[string]*drift)
+)
+
+// auditEntry is a line in the audit log.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+}
+
+// audit writes an event to the AuditLog file as a line of JSON, or prints it to
+// stderr if it's not set.
+func audit(event, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if config.AuditLog == "" {
+		infof("%v", msg)
+		return
+	}
+
+	j, err := json.Marshal(auditEntry{Time: time.Now(), Event: event, Message: msg})
+	if err != nil {
+		warnf("cannot write audit log: %v", err)
+		return
+	}
+
+	fp, err := os.OpenFile(config.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = fp.Write(append(j, '\n'))
+		if cErr := fp.Close(); err == nil {
+			err = cErr
+		}
+	}
+	if err != nil {
+		warnf("cannot write audit log: %v", err)
+	}
+}
+
+// monitor detects the IP, records what would be changed and checks the records
+// for drift. It never writes anything to the API.
+func monitor(ctx context.Context, prev *ipT) (*ipT, error) {
+	ip, err := getIP(ctx)
+	if err != nil {
+		metricsIPFailure()
+		return nil, err
+	}
+
+	changed := prev == nil || *prev != *ip
+	if changed {
+		if prev == nil {
+			audit("ip", "IP is %v", ip)
+		} else {
+			audit("ip", "IP changed from %v to %v", prev, ip)
+		}
+	}
+	checkOrigin(ctx, *ip)
+
+	var recs []published
+	for _, domain := range sortedDomains() {
+		info, err := getDomain(ctx, domain)
+		if err != nil {
+			return ip, fmt.Errorf("cannot get domain %v: %v", domain, err)
+		}
+
+		_, changes, err := planDomain(config.Records[domain], info, *ip)
+		if err != nil {
+			return ip, fmt.Errorf("cannot check domain %v: %v", domain, err)
+		}
+		if changed {
+			for _, c := range changes {
+				audit("would-update", "would update %v %v from %v to %v", c.FQDN, c.Type, c.Old, c.New)
+			}
+		}
+
+		for _, i := range info {
+			if (i.Type == "A" || i.Type == "AAAA") && inList(config.Records[domain], i.FQDN) {
+				recs = append(recs, published{
+					FQDN: i.FQDN, Type: i.Type, Content: i.Content, TTL: i.Expire})
+			}
+		}
+	}
+
+	checkDrift(ctx, monitorDrifts, recs, time.Now())
+	return ip, nil
+}
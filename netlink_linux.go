@@ -0,0 +1,170 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// With -watch-netlink the daemon also listens for address and default route
+// changes from the kernel (rtnetlink), and updates within a few seconds of the
+// change rather than waiting for the next interval:
+//
+//   transip-dynamic -watch-netlink
+//
+// Changes are debounced with netlink-debounce (5s by default): the update runs
+// once there were no changes for that long, so a reconnect that removes and
+// adds addresses a few times only gives one update. Only global addresses and
+// the default route are looked at; the update still gets the address from
+// get-ip as usual. This is only supported on Linux.
+
+// From linux/rtnetlink.h; the syscall package doesn't have them.
+const (
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// watchNetlink sends on changed after the addresses or default route changed.
+func watchNetlink(changed chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("netlink socket", err)
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	})
+	if err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("netlink bind", err)
+	}
+
+	// The kernel also sends RTM_NEWADDR when the lifetime of an IPv6 address
+	// is refreshed, so keep track of which addresses we already have.
+	known := make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				known[n.IP.String()] = true
+			}
+		}
+	}
+
+	events := make(chan string, 16)
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EINTR || err == syscall.ENOBUFS {
+					// ENOBUFS means we missed messages; update to be sure.
+					events <- "netlink buffer overrun"
+					continue
+				}
+				warnf("netlink: %v; not watching for changes any more", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				debugf("netlink: %v", err)
+				continue
+			}
+			for _, m := range msgs {
+				if e := netlinkEvent(m, known); e != "" {
+					events <- e
+				}
+			}
+		}
+	}()
+
+	go func() {
+		var (
+			t       *time.Timer
+			pending <-chan time.Time
+		)
+		for {
+			select {
+			case e := <-events:
+				configMu.RLock()
+				wait := config.NetlinkDebounce
+				configMu.RUnlock()
+				debugf("netlink: %v; updating in %v if nothing else changes", e, wait)
+				if t != nil {
+					t.Stop()
+				}
+				t = time.NewTimer(wait)
+				pending = t.C
+			case <-pending:
+				pending = nil
+				select {
+				case changed <- struct{}{}:
+				default: // Already one waiting.
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// netlinkEvent describes the change in the netlink message, or returns "" if
+// it's not a change we care about.
+func netlinkEvent(m syscall.NetlinkMessage, known map[string]bool) string {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return ""
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if ifa.Scope != syscall.RT_SCOPE_UNIVERSE {
+			return ""
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return ""
+		}
+		var addr net.IP
+		for _, a := range attrs {
+			// IFA_LOCAL is the local address on point-to-point links, where
+			// IFA_ADDRESS is the other end.
+			if a.Attr.Type == syscall.IFA_LOCAL || (a.Attr.Type == syscall.IFA_ADDRESS && addr == nil) {
+				addr = net.IP(a.Value)
+			}
+		}
+		if addr == nil || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			return ""
+		}
+
+		a := addr.String()
+		if m.Header.Type == syscall.RTM_DELADDR {
+			delete(known, a)
+			return "removed address " + a
+		}
+		if known[a] {
+			return ""
+		}
+		known[a] = true
+		return "new address " + a
+
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return ""
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Dst_len != 0 || rt.Table != syscall.RT_TABLE_MAIN {
+			return ""
+		}
+		if m.Header.Type == syscall.RTM_DELROUTE {
+			return "removed default route"
+		}
+		return "new default route"
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func watchNetlink(changed chan<- struct{}) error {
+	return errors.New("-watch-netlink is only supported on Linux")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// notify sends a notification to everything in the Notify setting, or prints
+// it to stderr if there's nothing.
+//
+// Errors are printed as a warning rather than returned; a failing notification
+// shouldn't fail the update. This is called from many places that don't have a
+// context, so it uses stopCtx: SIGTERM doesn't wait for a notification.
+func notify(title, msg string) {
+	if len(config.Notify) == 0 {
+		noticef("%v: %v", title, msg)
+		return
+	}
+
+	for _, n := range config.Notify {
+		var err error
+		switch n[0] {
+		case "exec":
+			err = notifyExec(n[1:], title, msg)
+		case "webhook":
+			err = notifyWebhook(n[1], title, msg)
+		case "ntfy":
+			err = notifyNtfy(n[1:], title, msg)
+		case "pushover":
+			err = notifyPushover(n[1], n[2], title, msg)
+		case "telegram":
+			err = notifyTelegram(n[1], n[2], title, msg)
+		}
+		if err != nil {
+			warnf("cannot send notification with %v: %v",
+				n[0], err)
+		}
+	}
+}
+
+// validNotify checks if a notify setting from the config is valid.
+func validNotify(v []string) error {
+	if len(v) < 2 {
+		return fmt.Errorf("need a type and argument: %q", v)
+	}
+	switch v[0] {
+	case "exec":
+	case "webhook":
+		if len(v) != 2 {
+			return fmt.Errorf("webhook needs a single URL: %q", v)
+		}
+	case "ntfy":
+		if len(v) != 2 && len(v) != 3 {
+			return fmt.Errorf("ntfy needs a topic URL and optionally an access token: %q", v)
+		}
+	case "pushover":
+		if len(v) != 3 {
+			return fmt.Errorf("pushover needs an application token and user key: %q", v)
+		}
+	case "telegram":
+		if len(v) != 3 {
+			return fmt.Errorf("telegram needs a bot token and chat ID: %q", v)
+		}
+	default:
+		return fmt.Errorf("unknown notify type %q", v[0])
+	}
+	return nil
+}
+
+// notifyExec runs a command, with the title and message in the environment
+// as $TRANSIP_NOTIFY_TITLE and $TRANSIP_NOTIFY_MESSAGE.
+func notifyExec(cmd []string, title, msg string) error {
+	c := exec.CommandContext(stopCtx, cmd[0], cmd[1:]...)
+	c.Env = append(os.Environ(),
+		"TRANSIP_NOTIFY_TITLE="+title,
+		"TRANSIP_NOTIFY_MESSAGE="+msg)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// notifyWebhook POSTs a JSON object with the title and message to url.
+func notifyWebhook(url, title, msg string) error {
+	j, err := json.Marshal(map[string]string{"title": title, "message": msg})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(stopCtx, "POST", url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return notifySend(req)
+}
+
+// notifyNtfy publishes the message to a ntfy topic; the first argument is the
+// topic URL (e.g. https://ntfy.sh/mytopic), and the optional second argument
+// an access token.
+func notifyNtfy(args []string, title, msg string) error {
+	req, err := http.NewRequestWithContext(stopCtx, "POST", args[0], strings.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	if len(args) > 1 {
+		req.Header.Set("Authorization", "Bearer "+args[1])
+	}
+	return notifySend(req)
+}
+
+// notifyPushover sends the message with Pushover.
+func notifyPushover(token, user, title, msg string) error {
+	req, err := http.NewRequestWithContext(stopCtx, "POST", "https://api.pushover.net/1/messages.json", strings.NewReader(url.Values{
+		"token":   {token},
+		"user":    {user},
+		"title":   {title},
+		"message": {msg},
+	}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return notifySend(req)
+}
+
+// notifyTelegram sends the message to a chat with a Telegram bot.
+func notifyTelegram(token, chat, title, msg string) error {
+	req, err := http.NewRequestWithContext(stopCtx, "POST", "https://api.telegram.org/bot"+token+"/sendMessage", strings.NewReader(url.Values{
+		"chat_id": {chat},
+		"text":    {title + "\n\n" + msg},
+	}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return notifySend(req)
+}
+
+func notifySend(req *http.Request) error {
+	resp, err := httpClient(10 * time.Second).Do(req)
+	if err != nil {
+		// The URL can contain a token.
+		return redactURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
+
+// notifyChange sends a notification if the address changed from old to ip;
+// this isn't printed if there's no Notify setting, as the update is already
+// logged.
+func notifyChange(old, ip ipT) {
+	if !config.NotifyChange || len(config.Notify) == 0 || old == ip || old == (ipT{}) || mockMode == "replay" {
+		return
+	}
+
+	var records []string
+	for _, domain := range sortedDomains() {
+		for _, r := range config.Records[domain] {
+			records = append(records, toUnicode(strings.TrimSuffix(r, ".")))
+		}
+	}
+
+	var changed []string
+	for _, f := range [][2]string{{old.IPv4, ip.IPv4}, {old.IPv6, ip.IPv6}} {
+		if f[0] != f[1] {
+			changed = append(changed, fmt.Sprintf("%v -> %v", orNone(f[0]), orNone(f[1])))
+		}
+	}
+	notify("address changed", fmt.Sprintf("%v; updated %v", strings.Join(changed, ", "), strings.Join(records, ", ")))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// With CheckOrigin the network (AS number) and country of a new address are
+// looked up with Team Cymru's IP to ASN service over DNS, and a notification is
+// sent if they're different from the previous address. A different network
+// usually means the IP detection picked up the address of a VPN or proxy,
+// rather than your ISP giving you a new address.
+
+// ipOrigin is the network an address is in.
+type ipOrigin struct {
+	IP      string `json:"ip"`
+	ASN     string `json:"asn"`
+	Country string `json:"country"`
+}
+
+func (o ipOrigin) String() string { return fmt.Sprintf("AS%v (%v)", o.ASN, o.Country) }
+
+// lookupOrigin gets the origin of ip.
+func lookupOrigin(ctx context.Context, ip string) (ipOrigin, error) {
+	o := ipOrigin{IP: ip}
+	p := net.ParseIP(ip)
+	if p == nil {
+		return o, fmt.Errorf("invalid IP %q", ip)
+	}
+
+	// 5.113.0.203.origin.asn.cymru.com, or every nibble for IPv6.
+	var name string
+	if p4 := p.To4(); p4 != nil {
+		name = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", p4[3], p4[2], p4[1], p4[0])
+	} else {
+		h := hex.EncodeToString(p.To16())
+		n := make([]string, 0, 32)
+		for i := len(h) - 1; i >= 0; i-- {
+			n = append(n, h[i:i+1])
+		}
+		name = strings.Join(n, ".") + ".origin6.asn.cymru.com"
+	}
+
+	var txt []string
+	if config.Resolver == "" {
+		var err error
+		txt, err = net.DefaultResolver.LookupTXT(ctx, name)
+		if err != nil {
+			return o, err
+		}
+	} else {
+		rrs, err := dnsQuery(ctx, config.Resolver, name, typeTXT, classIN, true)
+		if err != nil {
+			return o, err
+		}
+		for _, rr := range rrs {
+			if rr.Type == typeTXT {
+				txt = append(txt, rr.Value)
+			}
+		}
+	}
+	if len(txt) == 0 {
+		return o, errors.New("no ASN found")
+	}
+
+	// "64496 | 203.0.113.0/24 | NL | ripencc | 2010-01-01"
+	f := strings.Split(txt[0], "|")
+	if len(f) < 3 || len(strings.Fields(f[0])) == 0 {
+		return o, fmt.Errorf("unexpected response %q", txt[0])
+	}
+	// Multiple origins are space-separated; just use the first.
+	o.ASN = strings.Fields(f[0])[0]
+	o.Country = strings.TrimSpace(f[2])
+	return o, nil
+}
+
+// checkOrigin looks up the origin of the addresses in ip if they changed, and
+// sends a notification if it's different from the previous one. It returns a
+// description of the changes.
+func checkOrigin(ctx context.Context, ip ipT) []string {
+	if !config.CheckOrigin {
+		return nil
+	}
+
+	stateMu.Lock()
+	prev := readState().Origin
+	stateMu.Unlock()
+
+	var changes []string
+	cur := make(map[string]ipOrigin, 2)
+	for fam, addr := range map[string]string{"ipv4": ip.IPv4, "ipv6": ip.IPv6} {
+		if addr == "" {
+			continue
+		}
+		p, ok := prev[fam]
+		if ok && p.IP == addr {
+			cur[fam] = p
+			continue
+		}
+
+		o, err := lookupOrigin(ctx, addr)
+		if err != nil {
+			warnf("cannot look up network of %v: %v", addr, err)
+			continue
+		}
+		cur[fam] = o
+		if ok && (o.ASN != p.ASN || o.Country != p.Country) {
+			c := fmt.Sprintf("the new address %v is in %v, but the previous address %v was in %v", addr, o, p.IP, p)
+			changes = append(changes, c)
+			notify("IP from another network", c+"; is it the address of a VPN or proxy?")
+		}
+	}
+
+	stateMu.Lock()
+	st := readState()
+	for fam, o := range cur {
+		st.Origin[fam] = o
+	}
+	writeState(st)
+	stateMu.Unlock()
+	return changes
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// plan is a list of changes we intend to make, which can be reviewed before
+// it's applied.
+type plan struct {
+	Created time.Time  `json:"created"`
+	IP      ipT        `json:"ip"`
+	Zones   []planZone `json:"zones"`
+}
+
+// planZone is the plan for a single zone.
+type planZone struct {
+	Domain string `json:"domain"`
+
+	// Hash of the zone as it was when the plan was made; the plan won't be
+	// applied if it changed.
+	Hash string `json:"hash"`
+
+	Changes []planChange `json:"changes"`
+
+	// The full list of entries to send to the API.
+	Entries []Info `json:"entries"`
+}
+
+// planChange is a single changed record.
+type planChange struct {
+	FQDN string `json:"fqdn"`
+	Type string `json:"type"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+
+	// Old and new TTL, if it changed.
+	OldTTL int `json:"old_ttl,omitempty"`
+	TTL    int `json:"ttl,omitempty"`
+}
+
+// zoneHash gets a hash of all the records in the zone.
+func zoneHash(info []Info) string {
+	lines := make([]string, 0, len(info))
+	for _, i := range info {
+		lines = append(lines, fmt.Sprintf("%v\t%v\t%v\t%v", i.Name, i.Expire, i.Type, i.Content))
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, l := range lines {
+		h.Write([]byte(l + "\n"))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// makePlan gets the current IP and zones, and works out what needs to change.
+func makePlan(ctx context.Context) (*plan, error) {
+	ip, err := getIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := sortedDomains()
+	for d := range config.ZoneFiles {
+		if !inList(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	for _, d := range staticDomains() {
+		if !inList(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	sort.Strings(domains)
+
+	p := &plan{Created: time.Now().UTC(), IP: *ip}
+	for _, domain := range domains {
+		info, err := getDomain(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get domain %v: %v", domain, err)
+		}
+
+		var (
+			entries []Info
+			changes []planChange
+		)
+		if path, ok := config.ZoneFiles[domain]; ok {
+			entries, changes, err = planZoneFile(domain, path, info, *ip)
+		} else {
+			var adopted, srv, static []planChange
+			entries, changes, err = planDomain(config.Records[domain], info, *ip)
+			entries, adopted = adoptRecords(domain, config.Records[domain], entries, *ip)
+			entries, srv = addSRV(domain, entries)
+			entries, static = addStatic(domain, entries)
+			changes = append(append(append(changes, adopted...), srv...), static...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot plan domain %v: %v", domain, err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		p.Zones = append(p.Zones, planZone{
+			Domain:  domain,
+			Hash:    zoneHash(info),
+			Changes: changes,
+			Entries: entries,
+		})
+	}
+
+	return p, nil
+}
+
+// writePlan writes the plan as JSON to path, or stdout if path is "" or "-".
+func writePlan(path string) error {
+	ctx := context.Background()
+	p, err := makePlan(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, z := range p.Zones {
+		for _, c := range z.Changes {
+			fmt.Fprintf(os.Stderr, "%-24v %-5v %v -> %v", toUnicode(c.FQDN), c.Type, c.Old, c.New)
+			if c.TTL != 0 {
+				fmt.Fprintf(os.Stderr, " (TTL %v -> %v)", c.OldTTL, c.TTL)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	if len(p.Zones) == 0 {
+		infof("no changes")
+	}
+
+	j, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+
+	if path == "" || path == "-" {
+		_, err = os.Stdout.Write(j)
+		return err
+	}
+	return ioutil.WriteFile(path, j, 0644)
+}
+
+// check prints the records that would be changed in the zone format, and
+// returns an error if there are any.
+func check() error {
+	ctx := context.Background()
+	p, err := makePlan(ctx)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, z := range p.Zones {
+		printChanges(z.Changes, z.Entries)
+		n += len(z.Changes)
+	}
+	if n > 0 {
+		return fmt.Errorf("%d records would be changed", n)
+	}
+	infof("no changes")
+	return nil
+}
+
+// printChanges prints the changes in the zone format, with "-" for the old
+// record and "+" for the new one. The entries are the new zone.
+func printChanges(changes []planChange, entries []Info) {
+	for _, c := range changes {
+		if c.New == "" { // Deleted.
+			fmt.Println("-", Info{FQDN: toUnicode(c.FQDN), Expire: c.OldTTL, Type: c.Type, Content: c.Old})
+			continue
+		}
+		for _, e := range entries {
+			if e.FQDN != c.FQDN || e.Type != c.Type || e.Content != c.New {
+				continue
+			}
+			e.FQDN = toUnicode(e.FQDN)
+			if c.Old != "" {
+				old := e
+				old.Content = c.Old
+				if c.TTL != 0 {
+					old.Expire = c.OldTTL
+				}
+				fmt.Println("-", old)
+			}
+			fmt.Println("+", e)
+			break
+		}
+	}
+}
+
+// applyPlan applies the plan from path. All zones are checked before anything
+// is sent, and it will refuse to apply anything if any of the zones changed
+// since the plan was made.
+func applyPlan(path string) error {
+	ctx := context.Background()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var p plan
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return fmt.Errorf("cannot read plan %v: %v", path, err)
+	}
+
+	for _, z := range p.Zones {
+		info, err := fetchDomain(ctx, z.Domain)
+		if err != nil {
+			return fmt.Errorf("cannot get domain %v: %v", z.Domain, err)
+		}
+		if zoneHash(info) != z.Hash {
+			return fmt.Errorf("zone %v changed since the plan was made at %v; make a new plan",
+				z.Domain, p.Created.Format(time.RFC3339))
+		}
+	}
+
+	for _, z := range p.Zones {
+		err := sendUpdate(ctx, z.Domain, z.Entries)
+		if err != nil {
+			return fmt.Errorf("cannot update domain %v: %v", z.Domain, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+func TestDiffZone(t *testing.T) {
+	zone := func(l ...string) []Info {
+		var info []Info
+		for _, r := range l {
+			// "name ttl type content"
+			f := strings.SplitN(r, " ", 4)
+			ttl, _ := strconv.Atoi(f[1])
+			info = append(info, Info{Name: f[0], Expire: ttl, Type: f[2], Content: f[3]})
+		}
+		setFQDN(info, "example.com")
+		return info
+	}
+
+	tests := []struct {
+		name       string
+		have, want []Info
+		changes    []planChange
+	}{
+		{"same", zone("@ 300 A 192.0.2.1", "www 300 CNAME @"), zone("www 300 CNAME @", "@ 300 A 192.0.2.1"), nil},
+		{"empty", nil, nil, nil},
+
+		{"add", zone("@ 300 A 192.0.2.1"), zone("@ 300 A 192.0.2.1", "www 300 CNAME @"), []planChange{
+			{FQDN: "www.example.com.", Type: "CNAME", New: "@"},
+		}},
+		{"add to a set", zone("@ 300 MX 10 a."), zone("@ 300 MX 10 a.", "@ 300 MX 20 b."), []planChange{
+			{FQDN: "example.com.", Type: "MX", New: "20 b."},
+		}},
+		{"delete", zone("@ 300 A 192.0.2.1", "www 300 CNAME @"), zone("@ 300 A 192.0.2.1"), []planChange{
+			{FQDN: "www.example.com.", Type: "CNAME", Old: "@", OldTTL: 300},
+		}},
+		{"delete all", zone("@ 300 A 192.0.2.1", "@ 60 AAAA 2001:db8::1"), nil, []planChange{
+			{FQDN: "example.com.", Type: "A", Old: "192.0.2.1", OldTTL: 300},
+			{FQDN: "example.com.", Type: "AAAA", Old: "2001:db8::1", OldTTL: 60},
+		}},
+		{"change", zone("home 300 A 192.0.2.1"), zone("home 300 A 192.0.2.2"), []planChange{
+			{FQDN: "home.example.com.", Type: "A", Old: "192.0.2.1", New: "192.0.2.2"},
+		}},
+		{"change TTL", zone("home 300 A 192.0.2.1"), zone("home 60 A 192.0.2.1"), []planChange{
+			{FQDN: "home.example.com.", Type: "A", Old: "192.0.2.1", New: "192.0.2.1", OldTTL: 300, TTL: 60},
+		}},
+		{"change one in a set", zone("@ 300 MX 10 a.", "@ 300 MX 20 b."), zone("@ 300 MX 20 b.", "@ 300 MX 30 c."), []planChange{
+			{FQDN: "example.com.", Type: "MX", Old: "10 a.", New: "30 c."},
+		}},
+		{"change and delete", zone("@ 300 MX 10 a.", "@ 300 MX 20 b.", "@ 300 MX 30 c."), zone("@ 300 MX 40 d."), []planChange{
+			{FQDN: "example.com.", Type: "MX", Old: "10 a.", New: "40 d."},
+			{FQDN: "example.com.", Type: "MX", Old: "20 b.", OldTTL: 300},
+			{FQDN: "example.com.", Type: "MX", Old: "30 c.", OldTTL: 300},
+		}},
+		{"type changed", zone("www 300 A 192.0.2.1"), zone("www 300 CNAME @"), []planChange{
+			{FQDN: "www.example.com.", Type: "A", Old: "192.0.2.1", OldTTL: 300},
+			{FQDN: "www.example.com.", Type: "CNAME", New: "@"},
+		}},
+		{"sorted", zone("z 300 A 192.0.2.1", "a 300 A 192.0.2.1"), zone("b 300 A 192.0.2.1"), []planChange{
+			{FQDN: "a.example.com.", Type: "A", Old: "192.0.2.1", OldTTL: 300},
+			{FQDN: "b.example.com.", Type: "A", New: "192.0.2.1"},
+			{FQDN: "z.example.com.", Type: "A", Old: "192.0.2.1", OldTTL: 300},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have := diffZone(tt.have, tt.want)
+			if !reflect.DeepEqual(have, tt.changes) {
+				t.Errorf("\nhave: %+v\nwant: %+v", have, tt.changes)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	srv := testConfig(t, "")
+	manualIP4 = addrsFlag{"198.51.100.1"}
+	defer func() { manualIP4 = nil }()
+
+	p, err := makePlan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Zones) != 1 || p.Zones[0].Domain != "example.com" {
+		t.Fatalf("zones: %+v", p.Zones)
+	}
+	want := []planChange{{FQDN: "home.example.com.", Type: "A", Old: "192.0.2.1", New: "198.51.100.1"}}
+	if !reflect.DeepEqual(p.Zones[0].Changes, want) {
+		t.Errorf("\nhave: %+v\nwant: %+v", p.Zones[0].Changes, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refuse to apply anything if the zone changed since the plan was made.
+	d, _ := srv.Domain("example.com")
+	orig := d
+	d.DNSEntries = append(append([]transip.DNSEntry(nil), d.DNSEntries...), transip.DNSEntry{Name: "new", Expire: 300, Type: "A", Content: "192.0.2.9"})
+	srv.SetDomain(d)
+	n := len(srv.Calls())
+	err = applyPlan(path)
+	if err == nil || !strings.Contains(err.Error(), "zone example.com changed since the plan was made") {
+		t.Fatalf("wrong error: %v", err)
+	}
+	for _, c := range srv.Calls()[n:] {
+		if c.Method == "setDnsEntries" {
+			t.Errorf("stale plan was applied")
+		}
+	}
+	if d, _ := srv.Domain("example.com"); len(d.DNSEntries) != 4 {
+		t.Errorf("zone was changed: %v", d.DNSEntries)
+	}
+
+	// A TTL change also makes it stale.
+	d = orig
+	d.DNSEntries = append([]transip.DNSEntry(nil), orig.DNSEntries...)
+	d.DNSEntries[2].Expire = 60
+	srv.SetDomain(d)
+	if err := applyPlan(path); err == nil {
+		t.Fatal("no error for a changed TTL")
+	}
+
+	// Applied if it's the same.
+	srv.SetDomain(orig)
+	if err := applyPlan(path); err != nil {
+		t.Fatal(err)
+	}
+	d, _ = srv.Domain("example.com")
+	if d.DNSEntries[1].Content != "198.51.100.1" || len(d.DNSEntries) != 3 {
+		t.Errorf("not applied: %v", d.DNSEntries)
+	}
+}
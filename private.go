@@ -0,0 +1,58 @@
+package main
+
+import "net"
+
+// Addresses from get-ip that can't be reached from the internet are rejected,
+// rather than publishing them in public DNS:
+//
+//   - 100.64.0.0/10, which ISPs use for carrier-grade NAT;
+//   - private addresses (10/8, 172.16/12, 192.168/16, and IPv6 ULA fc00::/7),
+//     usually because get-ip is a service on the LAN;
+//   - link-local, loopback, and other non-global addresses.
+//
+// The family is then skipped like a get-ip service without an address for it.
+// Set AllowPrivate for split-horizon setups that really want to publish such an
+// address; record-from and -ip4/-ip6 are never checked.
+
+// notPublic gets why ip can't be reached from the internet, or "" if it can.
+func notPublic(ip net.IP) string {
+	switch {
+	case isCGNAT(ip):
+		return "a carrier-grade NAT (CGNAT) address; you appear to be behind CGNAT, and the address can't be reached from the internet"
+	case ip.IsPrivate():
+		return "a private address; get-ip probably returned the address of the local network rather than the public one"
+	case ip.IsLinkLocalUnicast():
+		return "a link-local address"
+	case ip.IsLoopback():
+		return "a loopback address"
+	case !ip.IsGlobalUnicast():
+		return "not a global unicast address"
+	}
+	return ""
+}
+
+// rejectPrivate clears the families of ip that aren't public addresses.
+func rejectPrivate(ip *ipT) {
+	for _, f := range []struct {
+		name string
+		addr *string
+	}{
+		{"IPv4", &ip.IPv4},
+		{"IPv6", &ip.IPv6},
+	} {
+		addr := net.ParseIP(*f.addr)
+		if addr == nil {
+			continue
+		}
+		why := notPublic(addr)
+		switch {
+		case why == "":
+		case config.AllowPrivate:
+			debugf("publishing %v address %v with allow-private; it's %v", f.name, addr, why)
+		default:
+			warnf("not using %v address %v from get-ip: it's %v (set allow-private to publish it anyway)",
+				f.name, addr, why)
+			*f.addr = ""
+		}
+	}
+}
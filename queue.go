@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// If a domain can't be updated because the network or API is down the update
+// is stored in the queue, and we keep trying in later runs until it succeeds.
+// Updates for the same domain replace each other, since we only care about the
+// latest IP.
+//
+// This is mostly useful when the IP can't be detected in a later run, as we
+// still know which IP we wanted to send.
+
+// pendingUpdate is an update waiting in the queue.
+type pendingUpdate struct {
+	IP        ipT       `json:"ip"`
+	Queued    time.Time `json:"queued"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+}
+
+func queuePath() string { return filepath.Join(config.StateDir, "queue.json") }
+
+// readQueue reads the queue, indexed by domain name.
+func readQueue() map[string]pendingUpdate {
+	q := make(map[string]pendingUpdate)
+	if config.StateDir == "" {
+		return q
+	}
+
+	data, err := ioutil.ReadFile(queuePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf("cannot read queue: %v", err)
+		}
+		return q
+	}
+	err = json.Unmarshal(data, &q)
+	if err != nil {
+		warnf("cannot read queue: %v", err)
+	}
+	return q
+}
+
+func writeQueue(q map[string]pendingUpdate) {
+	if config.StateDir == "" {
+		return
+	}
+
+	var err error
+	if len(q) == 0 {
+		err = os.Remove(queuePath())
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		var data []byte
+		data, err = json.MarshalIndent(q, "", "\t")
+		if err == nil {
+			err = writeFileAtomic(queuePath(), data, 0600)
+		}
+	}
+	if err != nil {
+		warnf("cannot write queue: %v", err)
+	}
+}
+
+// isTemporary reports if err is a network error, a record that can't be
+// changed yet because of the WriteInterval, an address that isn't reachable
+// yet, a rate limit, or a request that was cancelled because the daemon is
+// stopping, in which case it may work if we try again later.
+func isTemporary(err error) bool {
+	var (
+		wErr *writeLimitError
+		rErr *unreachableError
+		fErr *transip.FaultError
+	)
+	if errors.As(err, &wErr) || errors.As(err, &rErr) || errors.Is(err, errStopped) ||
+		errors.Is(err, context.Canceled) {
+		return true
+	}
+	if errors.As(err, &fErr) {
+		return fErr.Temporary()
+	}
+
+	// url.Error implements net.Error, but can wrap anything.
+	var uErr *url.Error
+	if errors.As(err, &uErr) {
+		err = uErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// queueUpdate adds the update to the queue.
+func queueUpdate(q map[string]pendingUpdate, domain string, ip ipT, err error) {
+	p, ok := q[domain]
+	if !ok || p.IP != ip {
+		p = pendingUpdate{IP: ip, Queued: time.Now()}
+	}
+	p.Attempts++
+	p.LastError = err.Error()
+	q[domain] = p
+}
+
+// flushQueue tries to send all updates in the queue; this is used if we can't
+// get the current IP.
+func flushQueue(ctx context.Context) error {
+	q := readQueue()
+	if len(q) == 0 {
+		return nil
+	}
+
+	domains := make([]string, 0, len(q))
+	for d := range q {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	var errs []string
+	for _, domain := range domains {
+		records, ok := config.Records[domain]
+		if !ok {
+			// Removed from the config.
+			delete(q, domain)
+			continue
+		}
+
+		p := q[domain]
+		err := updateDomain(ctx, domain, records, p.IP)
+		if err != nil {
+			// Trying again won't fix errors such as a missing record.
+			if isTemporary(err) {
+				queueUpdate(q, domain, p.IP, err)
+			} else {
+				delete(q, domain)
+			}
+			errs = append(errs, err.Error())
+			continue
+		}
+		delete(q, domain)
+		notifyFlushed(domain, p)
+	}
+
+	writeQueue(q)
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot flush queue: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func notifyFlushed(domain string, p pendingUpdate) {
+	notify("queued update sent",
+		fmt.Sprintf("the update for %v to %v queued at %v was sent after %d attempts",
+			domain, p.IP, p.Queued.Format(time.RFC3339), p.Attempts+1))
+}
+
+func (ip ipT) String() string {
+	switch {
+	case ip.IPv4 != "" && ip.IPv6 != "":
+		return ip.IPv4 + " and " + ip.IPv6
+	case ip.IPv4 != "":
+		return ip.IPv4
+	default:
+		return ip.IPv6
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// With CheckPorts a new address is only published once these TCP ports answer
+// on it, so that DNS isn't pointed to an address where the service isn't
+// reachable (e.g. when the IP was detected through a VPN, or the port
+// forwarding isn't set up yet). The update is queued and tried again in the
+// next run.
+//
+// This connects to our own public address, so the router needs to support
+// hairpin NAT for IPv4.
+
+// unreachableError is used if a new address doesn't answer on one of the
+// CheckPorts.
+type unreachableError struct {
+	Addr string
+	Err  error
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("not publishing new address: %v doesn't answer: %v", e.Addr, e.Err)
+}
+
+func validPort(p string) error {
+	n, err := strconv.Atoi(p)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", p)
+	}
+	return nil
+}
+
+// checkReachable checks that the CheckPorts answer on all new addresses in
+// changes.
+func checkReachable(changes []planChange) error {
+	if len(config.CheckPorts) == 0 {
+		return nil
+	}
+
+	checked := make(map[string]bool)
+	for _, c := range changes {
+		if c.New == "" || checked[c.New] {
+			continue
+		}
+		checked[c.New] = true
+
+		for _, p := range config.CheckPorts {
+			addr := net.JoinHostPort(c.New, p)
+			conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+			if err != nil {
+				return &unreachableError{Addr: addr, Err: err}
+			}
+			conn.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// Keys can be restricted to read-only in the TransIP control panel. Everything
+// that only reads (diff, drift, -monitor) works as usual, but writes are
+// rejected. Once that happens it's stored in the state, and further writes with
+// the same key fail right away for a day, without sending anything. With
+// ReadOnly set writes always fail right away, and REST tokens are requested as
+// read-only.
+
+var errReadOnly = errors.New("credentials are read-only; use a key which isn't restricted to read-only in the TransIP control panel")
+
+var reReadOnly = regexp.MustCompile(`(?i)read[- ]?only`)
+
+type readOnlyState struct {
+	Key   string    `json:"key,omitempty"` // Fingerprint of the key.
+	Since time.Time `json:"since"`
+}
+
+// isWrite reports if req changes anything.
+func isWrite(req *http.Request) bool {
+	if call, ok := transip.CallFromContext(req.Context()); ok {
+		return !strings.HasPrefix(call.Method, "get")
+	}
+	return req.Method != "GET" && req.Method != "HEAD" && !strings.HasSuffix(req.URL.Path, "/auth")
+}
+
+// isReadOnly reports if we know the current key is read-only.
+func isReadOnly() bool {
+	if config.ReadOnly {
+		return true
+	}
+	key := keyFingerprint()
+	stateMu.Lock()
+	ro := readAPIFlags().ReadOnly
+	stateMu.Unlock()
+	return ro.Key == key && time.Since(ro.Since) < 24*time.Hour
+}
+
+// readOnly is a middleware which fails writes if the key is read-only.
+func readOnly(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !isWrite(req) {
+			return next.RoundTrip(req)
+		}
+		if isReadOnly() {
+			return nil, errReadOnly
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		_, soap := transip.CallFromContext(req.Context())
+		if soap {
+			if f := transip.ParseFault(body); f == nil || !reReadOnly.MatchString(f.Message) {
+				return resp, nil
+			}
+		} else if resp.StatusCode < 400 || !reReadOnly.Match(body) {
+			return resp, nil
+		}
+
+		key := keyFingerprint()
+		stateMu.Lock()
+		st := readState()
+		st.ReadOnly = readOnlyState{Key: key, Since: time.Now()}
+		writeState(st)
+		stateMu.Unlock()
+
+		notify("read-only credentials", fmt.Sprintf(
+			"%v; not sending any changes to the API for a day", errReadOnly))
+		return nil, errReadOnly
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// With -report a JSON report of the run is written to a file, with the result
+// for every record, so that CI pipelines and configuration management can see
+// exactly what happened. In daemon mode it's overwritten after every run.
+//
+// With -ansible the report is printed to stdout as a single JSON object with
+// "changed" and "failed", so it can be used as an Ansible module.
+
+var (
+	reportPath string
+	ansible    bool
+)
+
+type reportT struct {
+	Start    time.Time      `json:"start"`
+	Duration string         `json:"duration"`
+	OK       bool           `json:"ok"`
+	Error    string         `json:"error,omitempty"`
+	IP       *ipT           `json:"ip"`
+	Domains  []reportDomain `json:"domains"`
+}
+
+type reportDomain struct {
+	Domain   string         `json:"domain"`
+	Duration string         `json:"duration"`
+	Error    string         `json:"error,omitempty"`
+	Records  []reportRecord `json:"records"`
+}
+
+// reportRecord is the result for a record; Result is "changed", "unchanged",
+// "queued" (failed, but will be retried), or "failed".
+type reportRecord struct {
+	FQDN   string `json:"fqdn"`
+	Type   string `json:"type,omitempty"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+	Result string `json:"result"`
+}
+
+var (
+	report   *reportT
+	reportMu sync.Mutex
+)
+
+// startReport starts the report for a run.
+func startReport() {
+	if reportPath == "" && !ansible {
+		return
+	}
+	reportMu.Lock()
+	report = &reportT{Start: time.Now()}
+	reportMu.Unlock()
+}
+
+// reportUpdate adds the result of updating a domain to the report. The info
+// is the new zone, and may be nil if it failed before that.
+func reportUpdate(domain string, records []string, info []Info, changes []planChange, took time.Duration, err error) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if report == nil {
+		return
+	}
+
+	d := reportDomain{Domain: domain, Duration: took.Round(time.Millisecond).String()}
+	failed := ""
+	if err != nil {
+		d.Error = err.Error()
+		failed = "failed"
+		if isTemporary(err) {
+			failed = "queued"
+		}
+	}
+
+	for _, r := range records {
+		found := false
+		for _, i := range info {
+			if i.FQDN != r || (i.Type != "A" && i.Type != "AAAA") {
+				continue
+			}
+			found = true
+
+			rr := reportRecord{FQDN: r, Type: i.Type, Old: i.Content, New: i.Content, Result: "unchanged"}
+			for _, c := range changes {
+				if c.FQDN == r && c.Type == i.Type && c.New == i.Content {
+					rr.Old, rr.Result = c.Old, "changed"
+				}
+			}
+			if failed != "" {
+				rr.Result = failed
+			}
+			d.Records = append(d.Records, rr)
+		}
+		if !found { // Only if it failed before planDomain.
+			d.Records = append(d.Records, reportRecord{FQDN: r, Result: failed})
+		}
+	}
+	for _, c := range changes {
+		if c.Type == "SRV" {
+			rr := reportRecord{FQDN: c.FQDN, Type: c.Type, Old: c.Old, New: c.New, Result: "changed"}
+			if failed != "" {
+				rr.Result = failed
+			}
+			d.Records = append(d.Records, rr)
+		}
+	}
+	report.Domains = append(report.Domains, d)
+}
+
+// writeReport writes the report for a run to reportPath, and prints it for
+// -ansible.
+func writeReport(ip *ipT, runErr error) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if report == nil {
+		return
+	}
+
+	report.Duration = time.Since(report.Start).Round(time.Millisecond).String()
+	report.OK = runErr == nil
+	report.IP = ip
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+
+	if ansible {
+		printAnsible(report)
+	}
+	if reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "\t")
+		if err == nil {
+			err = writeFileAtomic(reportPath, data, 0644)
+		}
+		if err != nil {
+			warnf("cannot write report: %v", err)
+		}
+	}
+	report = nil
+}
+
+// printAnsible prints the report as an Ansible module result: "changed" is
+// true if any record was changed, and "failed" if there was any error.
+func printAnsible(r *reportT) {
+	changed := false
+	for _, d := range r.Domains {
+		for _, rr := range d.Records {
+			if rr.Result == "changed" {
+				changed = true
+			}
+		}
+	}
+
+	msg := "all records are up to date"
+	switch {
+	case !r.OK:
+		msg = r.Error
+	case changed:
+		msg = "records changed"
+	}
+
+	if r.Domains == nil {
+		r.Domains = []reportDomain{}
+	}
+	json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+		"changed": changed,
+		"failed":  !r.OK,
+		"msg":     msg,
+		"ip":      r.IP,
+		"domains": r.Domains,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// Some things are only in the REST API (v6), such as the VPS firewall, and with
+// "transport rest" it's also used for the DNS records instead of SOAP. This
+// uses the same user and key as the SOAP API; see transip.RESTClient.
+//
+// The token is valid for 30 minutes, and is stored in the state so that runs
+// from cron don't all need to request a new one.
+
+var (
+	restClientCache *transip.RESTClient
+	restClientMu    sync.Mutex
+)
+
+// restClient gets the client for the REST API. The same client is used as long
+// as the config and key don't change, so the token is kept in memory.
+func restClient() *transip.RESTClient {
+	restClientMu.Lock()
+	defer restClientMu.Unlock()
+
+	c, key, hc := restClientCache, signingKey(), apiClient()
+	if c == nil || c.PrivateKey != key || c.HTTPClient != hc || c.Login != config.User ||
+		c.Endpoint != config.API || c.ReadOnly != config.ReadOnly {
+		restClientCache = &transip.RESTClient{
+			Login:      config.User,
+			PrivateKey: key,
+			Endpoint:   config.API,
+			HTTPClient: hc,
+			ReadOnly:   config.ReadOnly,
+			Label:      "transip-dynamic",
+			Tokens:     stateTokens{},
+		}
+	}
+	return restClientCache
+}
+
+// forgetRESTClient forgets the client and the token in it; the token in the
+// state is only used if it's for the same key.
+func forgetRESTClient() {
+	restClientMu.Lock()
+	defer restClientMu.Unlock()
+	restClientCache = nil
+}
+
+// stateTokens stores the REST token in the state; this isn't done with -mock.
+type stateTokens struct{}
+
+func (stateTokens) Load() (transip.Token, error) {
+	if mockMode != "" {
+		return transip.Token{}, nil
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return readState().RESTToken, nil
+}
+
+func (stateTokens) Store(t transip.Token) error {
+	if mockMode != "" {
+		return nil
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	st.RESTToken = t
+	writeState(st)
+	return nil
+}
+
+// restFetchDomain gets a domain from the REST API; see fetchDomain.
+func restFetchDomain(ctx context.Context, name string) ([]Info, error) {
+	d, err := restClient().GetInfo(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	checkExpiry(domainMeta{
+		Name:             name,
+		IsLocked:         d.IsLocked,
+		RegistrationDate: d.RegistrationDate,
+		RenewalDate:      d.RenewalDate,
+	})
+
+	info := make([]Info, 0, len(d.DNSEntries))
+	for _, e := range d.DNSEntries {
+		info = append(info, Info{Name: e.Name, Expire: e.Expire, Type: e.Type, Content: e.Content})
+	}
+	setFQDN(info, name)
+	return info, nil
+}
+
+// restSetDNS replaces all records of domain with info.
+func restSetDNS(ctx context.Context, domain string, info []Info) error {
+	entries := make([]transip.DNSEntry, 0, len(info))
+	for _, i := range info {
+		entries = append(entries, transip.DNSEntry{Name: i.Name, Expire: i.Expire, Type: i.Type, Content: i.Content})
+	}
+	return restClient().SetDNSEntries(ctx, domain, entries)
+}
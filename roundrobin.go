@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// With round-robin a record is set to the addresses from several sources, for
+// example a host with two WAN links:
+//
+//   round-robin www.example.com interface:wan0 interface:wan1
+//
+// The sources are the same as for record-from, and get-ip for the address from
+// GetIP. There will be an A (and AAAA) record for every address, and records
+// with other addresses are removed. The record is left alone if one of the
+// sources fails.
+//
+// Giving several addresses to -ip4 or -ip6 does the same for all records
+// without a record-from or round-robin:
+//
+//   transip-dynamic -ip4 203.0.113.5 -ip4 198.51.100.7
+//   transip-dynamic -ip4 203.0.113.5,198.51.100.7
+//
+// Internally the addresses are kept in a ipT joined with ", ", sorted; this
+// is what serves() returns as well, so the diff and watch commands work
+// without changes.
+
+// The addresses from -ip4 and -ip6, if more than one was given for a family.
+var manualAll *ipT
+
+// addrsFlag is a flag that can be given more than once, and accepts a list
+// separated by commas.
+type addrsFlag []string
+
+func (f *addrsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *addrsFlag) Set(v string) error {
+	for _, a := range strings.Split(v, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			*f = append(*f, a)
+		}
+	}
+	return nil
+}
+
+// roundRobinIP gets the addresses for all the sources of a round-robin record.
+// It returns false if a source failed.
+func roundRobinIP(sources []string, ip ipT) (ipT, bool) {
+	var v4, v6 []string
+	for _, s := range sources {
+		sip := &ip
+		if s != "get-ip" {
+			var ok bool
+			sourceIPsMu.Lock()
+			sip, ok = sourceIPs[s]
+			sourceIPsMu.Unlock()
+			if !ok {
+				return ipT{}, false
+			}
+		}
+		if sip.IPv4 != "" && !inList(v4, sip.IPv4) {
+			v4 = append(v4, sip.IPv4)
+		}
+		if sip.IPv6 != "" && !inList(v6, sip.IPv6) {
+			v6 = append(v6, sip.IPv6)
+		}
+	}
+	return joinAddrs(v4, v6), true
+}
+
+// joinAddrs gets a ipT for a list of IPv4 and IPv6 addresses.
+func joinAddrs(v4, v6 []string) ipT {
+	sort.Strings(v4)
+	sort.Strings(v6)
+	return ipT{IPv4: strings.Join(v4, ", "), IPv6: strings.Join(v6, ", ")}
+}
+
+// isMulti reports if the address is a list from joinAddrs.
+func isMulti(addr string) bool { return strings.Contains(addr, ", ") }
+
+// planSet sets the records of type typ for record to exactly the contents in
+// addrs; idx are the indexes of the current records for record (other types
+// are skipped). Records that aren't needed any more are set in del, and records
+// that are added are appended to info. This is also used for static records.
+func planSet(info []Info, idx []int, record, typ string, addrs []string, del map[int]bool) ([]Info, []planChange) {
+	ttl, setTTL := config.TTL[record]
+	want := make(map[string]bool)
+	for _, a := range addrs {
+		want[a] = true
+	}
+
+	var (
+		changes []planChange
+		reuse   []int
+		name    string
+		newTTL  = int(config.CreateTTL)
+	)
+	for _, i := range idx {
+		if info[i].Type != typ {
+			continue
+		}
+		// New records get the same TTL as the existing ones.
+		name, newTTL = info[i].Name, info[i].Expire
+		if !want[info[i].Content] {
+			reuse = append(reuse, i)
+			continue
+		}
+		delete(want, info[i].Content)
+		if setTTL && info[i].Expire != ttl {
+			changes = append(changes, planChange{FQDN: record, Type: typ, Old: info[i].Content,
+				New: info[i].Content, OldTTL: info[i].Expire, TTL: ttl})
+			info[i].Expire = ttl
+		}
+	}
+	if setTTL {
+		newTTL = ttl
+	}
+
+	domain, _, _ := splitRecord(record)
+	if name == "" {
+		name = recordName(record, domain)
+	}
+	for _, a := range addrs {
+		if !want[a] {
+			continue
+		}
+		// Change a record with an address we don't want any more, rather than
+		// removing it and adding a new one.
+		if len(reuse) > 0 {
+			i := reuse[0]
+			reuse = reuse[1:]
+			c := planChange{FQDN: record, Type: typ, Old: info[i].Content, New: a}
+			if setTTL && info[i].Expire != ttl {
+				c.OldTTL, c.TTL = info[i].Expire, ttl
+				info[i].Expire = ttl
+			}
+			info[i].Content = a
+			changes = append(changes, c)
+			continue
+		}
+		info = append(info, Info{Name: name, Expire: newTTL, Type: typ, Content: a})
+		setFQDN(info[len(info)-1:], domain)
+		changes = append(changes, planChange{FQDN: record, Type: typ, New: a})
+	}
+	for _, i := range reuse {
+		del[i] = true
+		changes = append(changes, planChange{FQDN: record, Type: typ, Old: info[i].Content, OldTTL: info[i].Expire})
+	}
+	return info, changes
+}
+
+// removeEntries removes the entries set in del from info.
+func removeEntries(info []Info, del map[int]bool) []Info {
+	if len(del) == 0 {
+		return info
+	}
+	keep := make([]Info, 0, len(info)-len(del))
+	for i := range info {
+		if !del[i] {
+			keep = append(keep, info[i])
+		}
+	}
+	return keep
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The router get-ip source asks the router for its WAN address, with UPnP IGD
+// (GetExternalIPAddress) or NAT-PMP, so it doesn't need an external service:
+//
+//   get-ip router icanhazip.com
+//   get-ip router:192.168.1.1
+//
+// The gateway is the default route by default (Linux only); this is only used
+// for NAT-PMP. Routers with PCP also answer NAT-PMP requests. This only gives
+// an IPv4 address.
+//
+// If the WAN address is a private or CGNAT (100.64.0.0/10) address the router
+// is behind another NAT and the address is useless; this is an error, so the
+// next get-ip service is used (which will also show the address really used on
+// the internet).
+
+// How long to wait for a reply from the router.
+const routerTimeout = 2 * time.Second
+
+func isRouter(source string) bool {
+	return source == "router" || strings.HasPrefix(source, "router:")
+}
+
+// validRouter checks the get-ip router source.
+func validRouter(source string) error {
+	if gw := strings.TrimPrefix(strings.TrimPrefix(source, "router"), ":"); gw != "" {
+		if ip := net.ParseIP(gw); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("%v: %q is not an IPv4 address", source, gw)
+		}
+	}
+	return nil
+}
+
+// ipFromRouter gets the WAN address from the router; the return values are
+// the same as ipFromService.
+func ipFromRouter(ctx context.Context, source string) (*ipT, bool, bool, error) {
+	var gw net.IP
+	if g := strings.TrimPrefix(strings.TrimPrefix(source, "router"), ":"); g != "" {
+		gw = net.ParseIP(g)
+	}
+
+	addr, err := upnpExternalIP(ctx, gw)
+	if err != nil {
+		debugf("router: UPnP: %v; trying NAT-PMP", err)
+		if gw == nil {
+			gw, err = defaultGateway()
+			if err != nil {
+				return nil, true, false, fmt.Errorf("no UPnP, and %v", err)
+			}
+		}
+		var pmpErr error
+		addr, pmpErr = natpmpExternalIP(ctx, gw)
+		if pmpErr != nil {
+			return nil, true, false, fmt.Errorf("router doesn't support UPnP (%v) or NAT-PMP (%v)", err, pmpErr)
+		}
+	}
+
+	switch {
+	case isCGNAT(addr):
+		return nil, true, false, fmt.Errorf("WAN address of the router is %v, which is carrier-grade NAT; "+
+			"the router isn't directly connected to the internet", addr)
+	case addr.IsPrivate() || !addr.IsGlobalUnicast():
+		return nil, true, false, fmt.Errorf("WAN address of the router is %v, which is a private address; "+
+			"the router is behind another router", addr)
+	}
+	return &ipT{IPv4: addr.String()}, true, false, nil
+}
+
+// upnpExternalIP finds an Internet Gateway Device with SSDP, and asks the
+// WAN address. Only a device at gw is used if it's not nil.
+func upnpExternalIP(ctx context.Context, gw net.IP) (net.IP, error) {
+	loc, err := ssdpSearch(ctx, gw)
+	if err != nil {
+		return nil, err
+	}
+	control, service, err := upnpControlURL(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf(`<?xml version="1.0"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:GetExternalIPAddress xmlns:u="%v"/></s:Body></s:Envelope>`, service)
+	req, err := http.NewRequestWithContext(ctx, "POST", control, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%v#GetExternalIPAddress"`, service))
+
+	resp, err := (&http.Client{Timeout: routerTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetExternalIPAddress: %v", resp.Status)
+	}
+
+	var env struct {
+		Addr string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.Unmarshal(data, &env)
+	if err != nil {
+		return nil, fmt.Errorf("GetExternalIPAddress: %v", err)
+	}
+	addr := net.ParseIP(strings.TrimSpace(env.Addr))
+	if addr == nil || addr.To4() == nil {
+		return nil, fmt.Errorf("GetExternalIPAddress: not an IPv4 address: %q", env.Addr)
+	}
+	return addr.To4(), nil
+}
+
+// ssdpSearch finds the description URL of an Internet Gateway Device.
+func ssdpSearch(ctx context.Context, gw net.IP) (string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	dst := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	// UDP; send it twice in case one gets lost.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(msg), dst); err != nil {
+			return "", err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(routerTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", errors.New("no Internet Gateway Device found")
+		}
+		if gw != nil && !from.IP.Equal(gw) {
+			continue
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+// upnpControlURL gets the control URL and service type of the WAN connection
+// from the device description.
+func upnpControlURL(ctx context.Context, loc string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", loc, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := (&http.Client{Timeout: routerTimeout}).Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%v: %v", loc, resp.Status)
+	}
+
+	base, err := url.Parse(loc)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The services are nested in a few levels of devices, so just look at all
+	// of them.
+	d := xml.NewDecoder(resp.Body)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", "", fmt.Errorf("%v: no WANIPConnection or WANPPPConnection service", loc)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "URLBase":
+			var u string
+			if d.DecodeElement(&u, &start) == nil {
+				if b, err := url.Parse(strings.TrimSpace(u)); err == nil {
+					base = b
+				}
+			}
+		case "service":
+			var s struct {
+				Type    string `xml:"serviceType"`
+				Control string `xml:"controlURL"`
+			}
+			if d.DecodeElement(&s, &start) != nil {
+				continue
+			}
+			if strings.HasPrefix(s.Type, "urn:schemas-upnp-org:service:WANIPConnection:") ||
+				strings.HasPrefix(s.Type, "urn:schemas-upnp-org:service:WANPPPConnection:") {
+				c, err := base.Parse(strings.TrimSpace(s.Control))
+				if err != nil {
+					return "", "", err
+				}
+				return c.String(), s.Type, nil
+			}
+		}
+	}
+}
+
+// natpmpExternalIP asks the WAN address with NAT-PMP (RFC 6886).
+func natpmpExternalIP(ctx context.Context, gw net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: 5351})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	// The RFC says to start at 250ms and double it every time; don't wait
+	// the full 64 seconds it says though.
+	buf := make([]byte, 16)
+	for wait := 250 * time.Millisecond; wait <= routerTimeout; wait *= 2 {
+		if _, err := conn.Write([]byte{0, 0}); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if n < 12 || buf[0] != 0 || buf[1] != 128 {
+			return nil, fmt.Errorf("invalid response from %v: %v", gw, hex.EncodeToString(buf[:n]))
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("%v returned result code %d", gw, code)
+		}
+		return net.IPv4(buf[8], buf[9], buf[10], buf[11]).To4(), nil
+	}
+	return nil, fmt.Errorf("no response from %v", gw)
+}
+
+// defaultGateway gets the IPv4 gateway of the default route.
+func defaultGateway() (net.IP, error) {
+	data, err := ioutil.ReadFile("/proc/net/route")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("can't find the default gateway on this system; use router:ADDRESS")
+		}
+		return nil, err
+	}
+
+	// Iface Destination Gateway Flags ...; addresses are hex in host byte
+	// order (little-endian on everything Linux runs on, in practice).
+	for _, l := range strings.Split(string(data), "\n")[1:] {
+		f := strings.Fields(l)
+		if len(f) < 3 || f[1] != "00000000" {
+			continue
+		}
+		g, err := hex.DecodeString(f[2])
+		if err != nil || len(g) != 4 || f[2] == "00000000" {
+			continue
+		}
+		return net.IPv4(g[3], g[2], g[1], g[0]), nil
+	}
+	return nil, errors.New("there is no default gateway; use router:ADDRESS")
+}
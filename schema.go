@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The schema command prints a JSON Schema of the config, for editors and
+// validation tools. The config file isn't JSON, so it describes the config as
+// an object with the setting names as keys:
+//
+//   - a simple setting is a string or integer;
+//   - a setting with several values is an array of strings;
+//   - a setting that can be given more than once with several values each
+//     (e.g. notify) is an array of arrays.
+//
+// It's generated from configT, so it stays in sync with the settings.
+
+// Keys that aren't the field name with dashes.
+var configKeys = map[string]string{
+	"Records":     "record",
+	"Zones":       "zone",
+	"DyndnsUsers": "dyndns-user",
+	"IPv6Policy":  "ipv6-policy",
+	"IPHeaders":   "ip-header",
+	"SrvRecords":  "srv-record",
+	"ZoneFiles":   "zone-file",
+	"CAFile":      "CAFile",
+	"CAPath":      "CAPath",
+}
+
+// Extra schema properties, for enums and settings with a handler.
+var schemaExtra = map[string]map[string]interface{}{
+	"Records": {"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 1},
+	"DyndnsUsers": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 3},
+	},
+	"RecordFrom": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 2},
+	},
+	"RoundRobin": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 3},
+	},
+	"ZoneFiles": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 2, "maxItems": 2},
+	},
+	"TTL": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 2},
+	},
+	"IPHeaders": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 3},
+	},
+	"Static": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 3},
+	},
+	"SrvRecords": {
+		"type":  "array",
+		"items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 5, "maxItems": 5},
+	},
+	"IPv6Policy": {
+		"type": "array",
+		"prefixItems": []interface{}{
+			map[string]interface{}{
+				"enum": []string{"detected", "eui64", "stable-privacy", "prefix", "suffix"}},
+			map[string]interface{}{"type": "string"},
+		},
+		"items":    false,
+		"minItems": 1,
+	},
+	"IPPreference":  {"enum": []string{"ipv4", "ipv6", "none"}},
+	"Transport":     {"enum": []string{"soap", "rest"}},
+	"MissingFamily": {"enum": []string{"skip", "error"}},
+	"VpsFirewall": {"items": map[string]interface{}{
+		"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 2}},
+	"Notify": {"items": map[string]interface{}{
+		"type":        "array",
+		"prefixItems": []interface{}{map[string]interface{}{"enum": []string{"exec", "webhook"}}},
+		"items":       map[string]interface{}{"type": "string"},
+		"minItems":    2,
+	}},
+	"OnChange": {"items": map[string]interface{}{
+		"type":        "array",
+		"prefixItems": []interface{}{map[string]interface{}{"enum": []string{"exec", "webhook"}}},
+		"items":       map[string]interface{}{"type": "string"},
+		"minItems":    2,
+	}},
+	"DriftResolvers": {"default": defaultDriftResolvers},
+	// Depends on the system.
+	"StateDir": {"default": nil},
+}
+
+// schema prints the JSON Schema of the config.
+func schema() error {
+	setDefaults()
+	v := reflect.ValueOf(config)
+
+	props := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" { // Unexported
+			continue
+		}
+
+		p := schemaType(v.Field(i))
+		for k, e := range schemaExtra[f.Name] {
+			if e == nil {
+				delete(p, k)
+				continue
+			}
+			p[k] = e
+		}
+
+		props[configKey(f.Name)] = p
+	}
+
+	j, err := json.MarshalIndent(map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "transip-dynamic config",
+		"type":                 "object",
+		"required":             []string{"user", "key-file", "api"},
+		"additionalProperties": false,
+		"properties":           props,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(j))
+	return nil
+}
+
+func schemaType(v reflect.Value) map[string]interface{} {
+	p := make(map[string]interface{})
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		p["type"] = "string"
+		p["pattern"] = `^([0-9.]+(ns|us|µs|ms|s|m|h))+$`
+		if !v.IsZero() {
+			p["default"] = time.Duration(v.Int()).String()
+		}
+	case v.Kind() == reflect.String:
+		p["type"] = "string"
+		if !v.IsZero() {
+			p["default"] = v.String()
+		}
+	case v.Kind() == reflect.Bool:
+		p["type"] = "boolean"
+	case v.Kind() == reflect.Int64:
+		p["type"] = "integer"
+		if !v.IsZero() {
+			p["default"] = v.Int()
+		}
+	case v.Kind() == reflect.Slice:
+		p["type"] = "array"
+		p["items"] = schemaType(reflect.New(v.Type().Elem()).Elem())
+	}
+	return p
+}
+
+// configKey gets the config key for a field name: "APIRetries" becomes
+// "api-retries".
+func configKey(field string) string {
+	if k, ok := configKeys[field]; ok {
+		return k
+	}
+
+	r := []rune(field)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) &&
+			(unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// The set command changes a single record, for one-off edits without the
+// control panel:
+//
+//   transip-dynamic set home.example.com A 203.0.113.7 -ttl 300
+//   transip-dynamic set example.com MX 10 mail.example.com.
+//
+// All existing records with that name and type are replaced with the value;
+// other records in the zone are left alone. The record doesn't need to be in
+// Records.
+
+// Record types the API accepts.
+var recordTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT", "SRV",
+	"SSHFP", "TLSA", "CAA", "NAPTR", "DS"}
+
+const defaultSetTTL = 300
+
+func setRecord(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	ttl := fs.Int("ttl", 0, "TTL in seconds; default: keep the current TTL, or 300 for new records")
+
+	pos, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 3 {
+		return fmt.Errorf("usage: %v set NAME TYPE VALUE [-ttl SECONDS]", os.Args[0])
+	}
+	if *ttl < 0 {
+		return fmt.Errorf("invalid TTL: %v", *ttl)
+	}
+
+	domain, fqdn, err := splitRecord(pos[0])
+	if err != nil {
+		return err
+	}
+	typ := strings.ToUpper(pos[1])
+	if !inList(recordTypes, typ) {
+		return fmt.Errorf("unknown record type %q; must be one of %v", pos[1], strings.Join(recordTypes, ", "))
+	}
+	value := strings.Join(pos[2:], " ")
+	if typ == "A" || typ == "AAAA" {
+		ip := net.ParseIP(value)
+		if ip == nil || (ip.To4() != nil) != (typ == "A") {
+			return fmt.Errorf("%q is not a valid address for an %v record", value, typ)
+		}
+		value = ip.String()
+	}
+
+	// Get the current zone rather than the cached one, to not send back
+	// anything that was changed in the meanwhile.
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+	}
+
+	var (
+		newInfo = make([]Info, 0, len(info)+1)
+		old     []string
+		oldTTL  int
+	)
+	for _, i := range info {
+		if i.FQDN == fqdn && i.Type == typ {
+			old = append(old, i.Content)
+			oldTTL = i.Expire
+			continue
+		}
+		newInfo = append(newInfo, i)
+	}
+	expire := *ttl
+	switch {
+	case expire == 0 && oldTTL > 0:
+		expire = oldTTL
+	case expire == 0:
+		expire = defaultSetTTL
+	}
+	if len(old) == 1 && old[0] == value && expire == oldTTL {
+		infof("%v %v is already %v", toUnicode(fqdn), typ, value)
+		return nil
+	}
+
+	newInfo = append(newInfo, Info{Name: recordName(fqdn, domain), Expire: expire, Type: typ, Content: value})
+	setFQDN(newInfo[len(newInfo)-1:], domain)
+	err = sendUpdate(ctx, domain, newInfo)
+	if err != nil {
+		return fmt.Errorf("cannot update domain %v: %v", toUnicode(domain), err)
+	}
+
+	// Make sure the next update doesn't skip it if it's one of our records.
+	if inList(config.Records[domain], fqdn) {
+		storeUpdated(nil)
+	}
+
+	infof("set %v %v from %v to %v (TTL %v)",
+		toUnicode(fqdn), typ, orNone(strings.Join(old, ", ")), value, expire)
+	return nil
+}
+
+// parseFlags parses the flags in fs, and returns the other arguments. The
+// flags can be anywhere, as in "set name A ip -ttl 300".
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
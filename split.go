@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The RecordFrom setting sets records to the address from another source than
+// GetIP, so that the public address and e.g. the LAN address can be published
+// to different records in the same run (split-horizon). The source is one of:
+//
+//   lan              address of the interface with the default route.
+//   interface:NAME   address of a network interface.
+//   hostname         a "what's my IP" service, like GetIP; this can also be
+//                    one of the DNS services from ipdns.go, or router.
+//
+// All sources are detected in getIP; records for a source that fails are left
+// alone.
+
+var (
+	sourceIPs   map[string]*ipT
+	sourceIPsMu sync.Mutex
+)
+
+// resolveSources gets the addresses for all sources in RecordFrom and
+// RoundRobin.
+func resolveSources(ctx context.Context) {
+	seen := map[string]bool{"get-ip": true}
+	var sources []string
+	for _, s := range config.RecordFrom {
+		if !seen[s] {
+			seen[s] = true
+			sources = append(sources, s)
+		}
+	}
+	for _, l := range config.RoundRobin {
+		for _, s := range l {
+			if !seen[s] {
+				seen[s] = true
+				sources = append(sources, s)
+			}
+		}
+	}
+	sort.Strings(sources)
+
+	ips := make(map[string]*ipT, len(sources))
+	for _, s := range sources {
+		ip, err := sourceIP(ctx, s)
+		if err != nil {
+			warnf("cannot get address from %v; not updating its records: %v", s, err)
+			continue
+		}
+		ips[s] = ip
+	}
+
+	sourceIPsMu.Lock()
+	sourceIPs = ips
+	sourceIPsMu.Unlock()
+}
+
+func sourceIP(ctx context.Context, source string) (*ipT, error) {
+	if source == "lan" {
+		return lanIP()
+	}
+	if strings.HasPrefix(source, "interface:") {
+		return interfaceIP(strings.TrimPrefix(source, "interface:"))
+	}
+
+	ip, _, _, err := ipFromHost(ctx, source)
+	if err == nil && ip.IPv4 == "" && ip.IPv6 == "" {
+		err = errors.New("no IP addresses found")
+	}
+	return ip, err
+}
+
+// ipForRecord gets the address to use for a record; this is ip unless it's set
+// to another source with RecordFrom, or to several addresses with RoundRobin or
+// -ip4 and -ip6 (see roundrobin.go). It returns false if a source failed.
+//
+// The address for a family that's not updated for this record is cleared.
+func ipForRecord(record string, ip ipT) (ipT, bool) {
+	if sources, ok := config.RoundRobin[record]; ok {
+		if ip, ok = roundRobinIP(sources, ip); !ok {
+			return ipT{}, false
+		}
+	} else if s, ok := config.RecordFrom[record]; ok {
+		sourceIPsMu.Lock()
+		sip, ok := sourceIPs[s]
+		sourceIPsMu.Unlock()
+		if !ok {
+			return ipT{}, false
+		}
+		ip = *sip
+	} else if manualAll != nil && mockMode != "replay" {
+		ip = *manualAll
+	}
+
+	if !updatesType(record, "A") {
+		ip.IPv4 = ""
+	}
+	if !updatesType(record, "AAAA") {
+		ip.IPv6 = ""
+	}
+	return ip, true
+}
+
+// updatesType reports if records of type typ ("A" or "AAAA") are updated for
+// the record.
+func updatesType(record, typ string) bool {
+	if (typ == "A" && !config.UpdateIPv4) || (typ == "AAAA" && !config.UpdateIPv6) {
+		return false
+	}
+	t, ok := config.recordType[record]
+	return !ok || t == typ
+}
+
+// interfaceIP gets the addresses of a network interface. Private addresses are
+// fine here, as that's mostly what this is used for.
+func interfaceIP(name string) (*ipT, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ip := &ipT{}
+	var v6 []localAddr
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || !n.IP.IsGlobalUnicast() {
+			continue
+		}
+		if n.IP.To4() != nil {
+			if ip.IPv4 == "" {
+				ip.IPv4 = n.IP.String()
+			}
+			continue
+		}
+		v6 = append(v6, localAddr{IP: n.IP, Iface: name})
+	}
+
+	// Use the flags from localIPv6 to skip temporary addresses where we can.
+	if local, err := localIPv6(); err == nil {
+		temp := make(map[string]bool)
+		for _, l := range local {
+			temp[l.IP.String()] = l.Temporary
+		}
+		for i := range v6 {
+			v6[i].Temporary = temp[v6[i].IP.String()]
+		}
+	}
+	for _, a := range v6 {
+		if !a.Temporary {
+			ip.IPv6 = a.IP.String()
+			break
+		}
+	}
+
+	if ip.IPv4 == "" && ip.IPv6 == "" {
+		return nil, fmt.Errorf("interface %v has no addresses", name)
+	}
+	return ip, nil
+}
+
+// ipFromInterface gets the public addresses of a network interface, for
+// "get-ip interface:NAME". There's usually no NAT for IPv6, so the address of
+// the interface is the public one, and this avoids getting a temporary
+// (privacy) address from a HTTP service. Stable addresses are preferred over
+// temporary ones. The interface usually has a private IPv4 address, in which
+// case the next get-ip service is used for IPv4. The return values are the
+// same as ipFromService.
+func ipFromInterface(name string) (*ipT, bool, bool, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, false, false, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	ip := &ipT{}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if ok && n.IP.To4() != nil && n.IP.IsGlobalUnicast() && !n.IP.IsPrivate() && !isCGNAT(n.IP) {
+			ip.IPv4 = n.IP.String()
+			break
+		}
+	}
+
+	local, err := localIPv6()
+	if err != nil {
+		return nil, false, false, err
+	}
+	var temp string
+	for _, l := range local {
+		if l.Iface != name {
+			continue
+		}
+		if !l.Temporary {
+			ip.IPv6 = l.IP.String()
+			break
+		}
+		if temp == "" {
+			temp = l.IP.String()
+		}
+	}
+	if ip.IPv6 == "" {
+		ip.IPv6 = temp
+	}
+
+	if ip.IPv4 == "" && ip.IPv6 == "" {
+		return ip, hasFamily("udp4"), true, fmt.Errorf("interface %v has no public addresses", name)
+	}
+	return ip, hasFamily("udp4"), true, nil
+}
+
+// isCGNAT reports if ip is in the shared address space for carrier-grade NAT
+// (100.64.0.0/10).
+func isCGNAT(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64
+}
+
+// lanIP gets the addresses of the interface with the default route; this is
+// the source address the OS picks for a connection to the internet. Connecting
+// a UDP socket doesn't send anything.
+func lanIP() (*ipT, error) {
+	ip := &ipT{}
+	for _, dst := range []string{"1.1.1.1:53", "[2606:4700:4700::1111]:53"} {
+		c, err := net.Dial("udp", dst)
+		if err != nil {
+			continue // No route for this family.
+		}
+		local := c.LocalAddr().(*net.UDPAddr).IP
+		c.Close()
+		if !local.IsGlobalUnicast() {
+			continue
+		}
+		if local.To4() != nil {
+			ip.IPv4 = local.String()
+			continue
+		}
+
+		// Don't use a temporary address, but another one on the same
+		// interface if there is one.
+		ip.IPv6 = local.String()
+		if addrs, err := localIPv6(); err == nil {
+			for _, a := range addrs {
+				if a.IP.Equal(local) && a.Temporary {
+					if iip, err := interfaceIP(a.Iface); err == nil && iip.IPv6 != "" {
+						ip.IPv6 = iip.IPv6
+					}
+				}
+			}
+		}
+	}
+
+	if ip.IPv4 == "" && ip.IPv6 == "" {
+		return nil, errors.New("no default route")
+	}
+	return ip, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SRV records point to one of the dynamic records, for example for a game
+// server or SIP:
+//
+//   srv-record _minecraft._tcp.example.com 0 5 25565 home.example.com
+//
+// The target is updated as usual; the SRV record itself is created if it
+// doesn't exist yet, and reset to the priority, weight, and port from the
+// config if it was changed. Several srv-record lines with the same name are
+// all kept, and other SRV records with that name are removed. The SRV record
+// can be in another domain than the target. New SRV records get the TTL of the
+// target.
+
+type srvRecord struct {
+	FQDN    string
+	Domain  string
+	Content string // "priority weight port target."
+}
+
+func parseSRV(v []string) (srvRecord, error) {
+	if len(v) != 5 {
+		return srvRecord{}, errors.New("need a name, priority, weight, port, and target")
+	}
+	domain, fqdn, err := splitRecord(v[0])
+	if err != nil {
+		return srvRecord{}, err
+	}
+	for i, n := range []string{"priority", "weight", "port"} {
+		x, err := strconv.ParseUint(v[i+1], 10, 16)
+		if err != nil || (n == "port" && x == 0) {
+			return srvRecord{}, fmt.Errorf("invalid %v: %q", n, v[i+1])
+		}
+	}
+	_, target, err := splitRecord(v[4])
+	if err != nil {
+		return srvRecord{}, err
+	}
+	return srvRecord{
+		FQDN:    fqdn,
+		Domain:  domain,
+		Content: strings.Join(append(v[1:4:4], target), " "),
+	}, nil
+}
+
+// checkSRV checks that the SRV targets are records we update.
+func checkSRV() error {
+	for _, s := range config.SrvRecords {
+		target := s.Content[strings.LastIndexByte(s.Content, ' ')+1:]
+		domain, _, _ := splitRecord(target)
+		if !inList(config.Records[domain], target) {
+			return fmt.Errorf("srv-record %v: target %v is not one of the records",
+				toUnicode(s.FQDN), toUnicode(target))
+		}
+	}
+	return nil
+}
+
+// addSRV sets the SRV records for domain in info. The records with the same
+// name are a set, like static records: several targets or priorities for one
+// name are all kept, and SRV records that aren't in the config are removed.
+func addSRV(domain string, info []Info) ([]Info, []planChange) {
+	var (
+		want  = make(map[string][]string)
+		names []string
+	)
+	for _, s := range config.SrvRecords {
+		if s.Domain != domain {
+			continue
+		}
+		if _, ok := want[s.FQDN]; !ok {
+			names = append(names, s.FQDN)
+		}
+		want[s.FQDN] = append(want[s.FQDN], s.Content)
+	}
+
+	var (
+		changes []planChange
+		del     = make(map[int]bool)
+		n       = len(info)
+	)
+	for _, fqdn := range names {
+		var idx []int
+		for i := range info {
+			if info[i].FQDN == fqdn && info[i].Type == "SRV" {
+				idx = append(idx, i)
+			}
+		}
+		var c []planChange
+		info, c = planSet(info, idx, fqdn, "SRV", want[fqdn], del)
+		changes = append(changes, c...)
+	}
+
+	// New SRV records get the TTL of the target.
+	for i := n; i < len(info); i++ {
+		for _, s := range config.SrvRecords {
+			if s.FQDN == info[i].FQDN && s.Content == info[i].Content {
+				info[i].Expire = srvTTL(s, info)
+			}
+		}
+	}
+	return removeEntries(info, del), changes
+}
+
+// srvTTL gets the TTL for a new SRV record: the ttl of the target if it's set,
+// the TTL of the target in info if it's in the same domain, or create-ttl.
+func srvTTL(s srvRecord, info []Info) int {
+	target := s.Content[strings.LastIndexByte(s.Content, ' ')+1:]
+	if ttl, ok := config.TTL[target]; ok {
+		return ttl
+	}
+	for _, i := range info {
+		if i.FQDN == target && (i.Type == "A" || i.Type == "AAAA") {
+			return i.Expire
+		}
+	}
+	return int(config.CreateTTL)
+}
+
+// writeSRV writes the SRV records in domains that aren't updated.
+func writeSRV(ctx context.Context) error {
+	done := make(map[string]bool)
+	var errs []string
+	for _, s := range config.SrvRecords {
+		if _, ok := config.Records[s.Domain]; ok || done[s.Domain] {
+			continue
+		}
+		done[s.Domain] = true
+
+		info, err := fetchDomain(ctx, s.Domain)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot get domain %v: %v", toUnicode(s.Domain), err))
+			continue
+		}
+		info, changes := addSRV(s.Domain, info)
+		if len(changes) == 0 {
+			continue
+		}
+		err = sendUpdate(ctx, s.Domain, info)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot update domain %v: %v", toUnicode(s.Domain), err))
+			continue
+		}
+		for _, c := range changes {
+			publishEvent(c)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("writing SRV records: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddSRV(t *testing.T) {
+	config = configT{}
+	setDefaults()
+	for _, v := range [][]string{
+		{"_sip._udp.example.com", "10", "100", "5060", "home.example.com"},
+		{"_sip._udp.example.com", "20", "100", "5060", "backup.example.com"},
+		{"_xmpp._tcp.example.com", "0", "5", "5222", "home.example.com"},
+	} {
+		s, err := parseSRV(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.SrvRecords = append(config.SrvRecords, s)
+	}
+
+	info := []Info{
+		{Name: "home", Expire: 60, Type: "A", Content: "192.0.2.1"},
+		{Name: "backup", Expire: 120, Type: "A", Content: "192.0.2.2"},
+		{Name: "_sip._udp", Expire: 600, Type: "SRV", Content: "30 100 5060 old.example.com."},
+		{Name: "_sip._udp", Expire: 600, Type: "SRV", Content: "10 100 5060 home.example.com."},
+		{Name: "_sip._udp", Expire: 600, Type: "SRV", Content: "40 100 5060 older.example.com."},
+	}
+	setFQDN(info, "example.com")
+
+	info, changes := addSRV("example.com", info)
+	want := []Info{
+		{Name: "home", Expire: 60, Type: "A", Content: "192.0.2.1"},
+		{Name: "backup", Expire: 120, Type: "A", Content: "192.0.2.2"},
+		{Name: "_sip._udp", Expire: 600, Type: "SRV", Content: "20 100 5060 backup.example.com."},
+		{Name: "_sip._udp", Expire: 600, Type: "SRV", Content: "10 100 5060 home.example.com."},
+		{Name: "_xmpp._tcp", Expire: 60, Type: "SRV", Content: "0 5 5222 home.example.com."},
+	}
+	setFQDN(want, "example.com")
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("\nhave: %v\nwant: %v", info, want)
+	}
+	wantChanges := []planChange{
+		{FQDN: "_sip._udp.example.com.", Type: "SRV", Old: "30 100 5060 old.example.com.", New: "20 100 5060 backup.example.com."},
+		{FQDN: "_sip._udp.example.com.", Type: "SRV", Old: "40 100 5060 older.example.com.", OldTTL: 600},
+		{FQDN: "_xmpp._tcp.example.com.", Type: "SRV", New: "0 5 5222 home.example.com."},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("\nhave: %v\nwant: %v", changes, wantChanges)
+	}
+
+	// Nothing to do on the next run.
+	again, changes := addSRV("example.com", info)
+	if len(changes) > 0 {
+		t.Errorf("changes on the second run: %v", changes)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("\nhave: %v\nwant: %v", again, want)
+	}
+}
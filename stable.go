@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// With -stable-for a new address is only published once it was detected for
+// that long, so that a connection which keeps reconnecting with different
+// addresses doesn't update the records every time. In daemon mode the address
+// is checked again in the next run; otherwise it's checked every 30 seconds
+// until it's stable (or changes again, which starts the wait over).
+
+var (
+	stableFor  time.Duration // Set from the -stable-for flag.
+	daemonMode bool
+)
+
+// seenIP is a new address, and when we first saw it.
+type seenIP struct {
+	IP    ipT       `json:"ip"`
+	Since time.Time `json:"since"`
+}
+
+// stableWait gets how much longer we need to see ip before it's stable for d.
+func stableWait(ip ipT, d time.Duration) time.Duration {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	if st.LastIP.IP == (ipT{}) || st.LastIP.IP == ip {
+		return 0
+	}
+	if st.Seen.IP != ip {
+		st.Seen = seenIP{IP: ip, Since: time.Now()}
+		writeState(st)
+	}
+	return d - time.Since(st.Seen.Since)
+}
+
+// stableIP returns the address once it's stable; it returns false in daemon
+// mode if it's not stable yet. If the address is flapping (see flap.go) it
+// needs to be stable for FlapDampen, and it always returns right away; the next
+// run checks it again.
+func stableIP(ctx context.Context, ip *ipT) (*ipT, bool, error) {
+	var prev ipT
+	for {
+		if why := flapping(*ip); why != "" && config.FlapDampen > stableFor && !force {
+			wait := stableWait(*ip, config.FlapDampen)
+			if wait <= 0 {
+				return ip, true, nil
+			}
+			infof("address is flapping: %v; not publishing %v until it's stable for %v (another %v)",
+				why, ip, config.FlapDampen, wait.Round(time.Second))
+			return ip, false, nil
+		}
+
+		if stableFor <= 0 {
+			return ip, true, nil
+		}
+		wait := stableWait(*ip, stableFor)
+		if wait <= 0 {
+			return ip, true, nil
+		}
+		if *ip != prev {
+			infof("new address %v; waiting until it's stable for %v", ip, stableFor)
+			prev = *ip
+		}
+		if daemonMode {
+			return ip, false, nil
+		}
+
+		if wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, false, ctx.Err()
+		}
+
+		var err error
+		ip, err = getIP(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// stateT is persistent state, stored as JSON in the StateDir.
+type stateT struct {
+	// What we last sent to the API for every record from the config, indexed
+	// by "fqdn type".
+	Published map[string]published `json:"published"`
+
+	// Authentication failures and the fingerprint of the key we switched to;
+	// see breaker.go.
+	Auth      authState `json:"auth"`
+	ActiveKey string    `json:"active_key,omitempty"`
+
+	// Addresses we added to VPS firewall rules, indexed by "vps/rule".
+	Firewall map[string][]string `json:"firewall"`
+
+	// Date we last warned about a domain expiring, indexed by domain.
+	ExpiryWarned map[string]string `json:"expiry_warned"`
+
+	// When a write was rejected because the key is read-only; see
+	// readonly.go.
+	ReadOnly readOnlyState `json:"read_only"`
+
+	// Token for the REST API, so that not every run requests a new one; see
+	// rest.go.
+	RESTToken transip.Token `json:"rest_token"`
+
+	// Network of the last address, indexed by "ipv4" or "ipv6"; see
+	// origin.go.
+	Origin map[string]ipOrigin `json:"origin"`
+
+	// The last address we published, and the address we're holding until
+	// it's confirmed; see hold.go.
+	LastIP lastIP  `json:"last_ip"`
+	Held   *heldIP `json:"held,omitempty"`
+
+	// A new address we're waiting for to be stable; see stable.go.
+	Seen seenIP `json:"seen"`
+
+	// Recent changes of the address; see flap.go.
+	Flaps []flapChange `json:"flaps,omitempty"`
+
+	// Address of every record after the last successful update, indexed by
+	// FQDN.
+	Updated map[string]ipT `json:"updated"`
+
+	// When we last wrote LockRecord and HeartbeatRecord; see lock.go and
+	// heartbeat.go.
+	Lock      time.Time `json:"lock"`
+	Heartbeat time.Time `json:"heartbeat"`
+
+	// Hash of the static and SRV records from the config that were last
+	// written; see recordsHash().
+	RecordsHash string `json:"records_hash,omitempty"`
+}
+
+// published is a record we sent to the API; Time is when it was last changed.
+type published struct {
+	FQDN    string    `json:"fqdn"`
+	Type    string    `json:"type"`
+	Content string    `json:"content"`
+	TTL     int       `json:"ttl"`
+	Time    time.Time `json:"time"`
+}
+
+var (
+	stateMu sync.Mutex
+
+	// The part of the state the authBreaker and readOnly middleware check on
+	// every API request. The daemon keeps this in memory (with memoryCache),
+	// as nothing else writes the state while it's running. Protected by
+	// stateMu.
+	apiFlagsCache *apiFlags
+)
+
+type apiFlags struct {
+	Auth     authState
+	ReadOnly readOnlyState
+}
+
+func statePath() string { return filepath.Join(config.StateDir, "state.json") }
+
+// readState reads the state; a missing or unreadable file gives an empty
+// state.
+func readState() stateT {
+	st := stateT{
+		Published:    make(map[string]published),
+		Firewall:     make(map[string][]string),
+		ExpiryWarned: make(map[string]string),
+		Origin:       make(map[string]ipOrigin),
+	}
+	if config.StateDir == "" {
+		return st
+	}
+
+	data, err := ioutil.ReadFile(statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf("cannot read state: %v", err)
+		}
+		return st
+	}
+	err = json.Unmarshal(data, &st)
+	if err != nil {
+		warnf("cannot read state: %v", err)
+	}
+	if st.Published == nil {
+		st.Published = make(map[string]published)
+	}
+	if st.Firewall == nil {
+		st.Firewall = make(map[string][]string)
+	}
+	if st.ExpiryWarned == nil {
+		st.ExpiryWarned = make(map[string]string)
+	}
+	if st.Origin == nil {
+		st.Origin = make(map[string]ipOrigin)
+	}
+	return st
+}
+
+// readAPIFlags gets the authentication and read-only state; the caller must
+// hold stateMu.
+func readAPIFlags() apiFlags {
+	if memoryCache && apiFlagsCache != nil {
+		return *apiFlagsCache
+	}
+	st := readState()
+	f := apiFlags{Auth: st.Auth, ReadOnly: st.ReadOnly}
+	if memoryCache {
+		apiFlagsCache = &f
+	}
+	return f
+}
+
+func writeState(st stateT) {
+	if memoryCache {
+		apiFlagsCache = &apiFlags{Auth: st.Auth, ReadOnly: st.ReadOnly}
+	}
+	if config.StateDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err == nil {
+		err = writeFileAtomic(statePath(), data, 0600)
+	}
+	if err != nil {
+		warnf("cannot write state: %v", err)
+	}
+}
+
+// storePublished records the A and AAAA records from the config we just sent
+// to the API for domain.
+func storePublished(domain string, info []Info) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	info = append([]Info(nil), info...)
+	setFQDN(info, domain)
+
+	st := readState()
+	now := time.Now()
+	for _, i := range info {
+		if (i.Type != "A" && i.Type != "AAAA") || !inList(config.Records[domain], i.FQDN) {
+			continue
+		}
+
+		k := i.FQDN + " " + i.Type
+		if p, ok := st.Published[k]; ok && p.Content == i.Content && p.TTL == i.Expire {
+			continue
+		}
+		st.Published[k] = published{
+			FQDN:    i.FQDN,
+			Type:    i.Type,
+			Content: i.Content,
+			TTL:     i.Expire,
+			Time:    now,
+		}
+	}
+	writeState(st)
+}
+
+// writeLimitError is used if a record was changed less than WriteInterval ago.
+type writeLimitError struct {
+	FQDN, Type string
+	Until      time.Time
+}
+
+func (e *writeLimitError) Error() string {
+	return fmt.Sprintf("not changing %v %v until %v, as it was changed less than %v ago",
+		e.FQDN, e.Type, e.Until.Format(time.RFC3339), config.WriteInterval)
+}
+
+// checkWriteInterval checks that none of the records in changes were changed
+// less than WriteInterval ago, so that a flapping IP doesn't send a flood of
+// updates.
+func checkWriteInterval(changes []planChange) error {
+	if config.WriteInterval <= 0 || len(changes) == 0 {
+		return nil
+	}
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+
+	now := time.Now()
+	for _, c := range changes {
+		p, ok := st.Published[c.FQDN+" "+c.Type]
+		if ok && now.Sub(p.Time) < config.WriteInterval {
+			return &writeLimitError{FQDN: c.FQDN, Type: c.Type, Until: p.Time.Add(config.WriteInterval)}
+		}
+	}
+	return nil
+}
+
+// recordIPs gets the address for every record.
+func recordIPs(ip ipT) map[string]ipT {
+	want := make(map[string]ipT)
+	for _, records := range config.Records {
+		for _, r := range records {
+			if rip, ok := ipForRecord(r, ip); ok {
+				want[r] = rip
+			}
+		}
+	}
+	return want
+}
+
+// unchanged reports if the records don't need to be updated, as the addresses
+// and static and SRV records are the same as in the last successful update; this way
+// frequent runs from cron don't need to use the API at all. The API is always used with -force,
+// if there's something in the queue, or if the lock or heartbeat record need
+// to be written.
+func unchanged(want map[string]ipT) bool {
+	if force || len(adoptFrom) > 0 || len(readQueue()) > 0 ||
+		(config.EnforceTTL && len(config.TTL) > 0) {
+		return false
+	}
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+	updated := st.Updated
+	if len(updated) == 0 || st.RecordsHash != recordsHash() {
+		return false
+	}
+	for r, ip := range want {
+		if u, ok := updated[r]; !ok || u != ip {
+			return false
+		}
+	}
+	return true
+}
+
+// storeUpdated stores the addresses after a successful update.
+func storeUpdated(want map[string]ipT) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	st.Updated = want
+	writeState(st)
+}
+
+// recordsHash gets a hash of the static and SRV records in the config, so we
+// know to write them again if they changed.
+func recordsHash() string {
+	if len(config.Static) == 0 && len(config.SrvRecords) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, s := range config.Static {
+		fmt.Fprintf(h, "static %q %q %q\n", s.FQDN, s.Type, s.Content)
+	}
+	for _, s := range config.SrvRecords {
+		fmt.Fprintf(h, "srv %q %q\n", s.FQDN, s.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// storeRecordsHash stores the hash of the static and SRV records after they
+// were written.
+func storeRecordsHash() {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	st := readState()
+	st.RecordsHash = recordsHash()
+	writeState(st)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Static records are kept at the content from the config, for records that
+// aren't dynamic but should be in the zone anyway:
+//
+//   static mail.example.com CNAME www.example.com.
+//   static example.com      MX    10 mail.example.com.
+//   static example.com      TXT   "v=spf1 mx -all"
+//
+// All records with the same name and type are the set of records for that
+// name: they're created if they don't exist, changed if they were changed, and
+// records with the same name and type that aren't in the config are removed.
+// The content is in the same format as the output of the list command.
+//
+// They're written on the first run after they were added or changed in the
+// config, even if the address didn't change.
+
+type staticRecord struct {
+	FQDN    string
+	Domain  string
+	Type    string
+	Content string
+}
+
+func parseStatic(v []string) (staticRecord, error) {
+	if len(v) < 3 {
+		return staticRecord{}, errors.New("need a name, type, and content")
+	}
+	domain, fqdn, err := splitRecord(v[0])
+	if err != nil {
+		return staticRecord{}, err
+	}
+	s := staticRecord{FQDN: fqdn, Domain: domain, Type: strings.ToUpper(v[1])}
+	if !inList(recordTypes, s.Type) {
+		return staticRecord{}, fmt.Errorf("unsupported record type %v; supported are %v",
+			v[1], strings.Join(recordTypes, ", "))
+	}
+
+	s.Content = strings.Join(v[2:], " ")
+	switch s.Type {
+	case "TXT":
+		s.Content = unquoteTXT(zoneFields(s.Content))
+	case "CNAME":
+		if len(v) != 3 {
+			return staticRecord{}, errors.New("a CNAME needs a single target")
+		}
+	}
+	return s, nil
+}
+
+// checkStatic checks that the static records don't conflict with the dynamic
+// records, and that a CNAME is the only record for its name.
+func checkStatic() error {
+	for _, s := range config.Static {
+		dynamic := inList(config.Records[s.Domain], s.FQDN)
+		if dynamic && (s.Type == "A" || s.Type == "AAAA" || s.Type == "CNAME") {
+			return fmt.Errorf("static %v: %v is also one of the records", s.Type, toUnicode(s.FQDN))
+		}
+		if s.Type != "CNAME" {
+			continue
+		}
+		for _, o := range config.Static {
+			if o.FQDN == s.FQDN && o != s {
+				return fmt.Errorf("static CNAME %v: can't have other records with the same name",
+					toUnicode(s.FQDN))
+			}
+		}
+	}
+	return nil
+}
+
+// staticDomains gets the domains with static records.
+func staticDomains() []string {
+	var domains []string
+	for _, s := range config.Static {
+		if !inList(domains, s.Domain) {
+			domains = append(domains, s.Domain)
+		}
+	}
+	return domains
+}
+
+// addStatic sets the static records for domain in info.
+func addStatic(domain string, info []Info) ([]Info, []planChange) {
+	type key struct{ fqdn, typ string }
+	var (
+		want = make(map[key][]string)
+		keys []key
+	)
+	for _, s := range config.Static {
+		if s.Domain != domain {
+			continue
+		}
+		k := key{s.FQDN, s.Type}
+		if _, ok := want[k]; !ok {
+			keys = append(keys, k)
+		}
+		want[k] = append(want[k], s.Content)
+	}
+
+	var (
+		changes []planChange
+		del     = make(map[int]bool)
+	)
+	for _, k := range keys {
+		var idx []int
+		for i := range info {
+			if info[i].FQDN == k.fqdn && info[i].Type == k.typ {
+				idx = append(idx, i)
+			}
+		}
+		var c []planChange
+		info, c = planSet(info, idx, k.fqdn, k.typ, want[k], del)
+		changes = append(changes, c...)
+	}
+	return removeEntries(info, del), changes
+}
+
+// writeStatic writes the static records in domains that aren't updated.
+func writeStatic(ctx context.Context) error {
+	var errs []string
+	for _, domain := range staticDomains() {
+		if _, ok := config.Records[domain]; ok {
+			continue
+		}
+
+		info, err := fetchDomain(ctx, domain)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot get domain %v: %v", toUnicode(domain), err))
+			continue
+		}
+		info, changes := addStatic(domain, info)
+		if len(changes) == 0 {
+			continue
+		}
+		err = sendUpdate(ctx, domain, info)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("cannot update domain %v: %v", toUnicode(domain), err))
+			continue
+		}
+		for _, c := range changes {
+			publishEvent(c)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("writing static records: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The status command shows the detected address and the value in TransIP for
+// every record, and exits with 1 if any of them don't match; this can be used
+// as a Nagios or Icinga check:
+//
+//   transip-dynamic status
+//   transip-dynamic status -json
+//
+// Records for a family without a detected address (e.g. on an IPv4-only host)
+// are shown as "unknown", which isn't an error. Unlike diff this doesn't ask
+// the nameservers, so it's quick and only needs the API.
+
+type recordStatus struct {
+	FQDN     string `json:"fqdn"`
+	Type     string `json:"type"`
+	Detected string `json:"detected"`
+	TransIP  string `json:"transip"`
+	TTL      int    `json:"ttl"`
+	Status   string `json:"status"` // ok, mismatch, or unknown
+}
+
+func statusCmd(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %q", rest)
+	}
+
+	ip, err := getIP(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		l        []recordStatus
+		mismatch int
+	)
+	for _, domain := range sortedDomains() {
+		info, err := fetchDomain(ctx, domain)
+		if err != nil {
+			return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+		}
+
+		for _, record := range config.Records[domain] {
+			rip, ok := ipForRecord(record, *ip)
+			if !ok {
+				rip = ipT{}
+			}
+			for _, t := range []string{"A", "AAAA"} {
+				if !updatesType(record, t) {
+					continue
+				}
+				s := recordStatus{FQDN: toUnicode(strings.TrimSuffix(record, ".")), Type: t, Detected: rip.IPv4}
+				if t == "AAAA" {
+					s.Detected = rip.IPv6
+				}
+
+				var stored []string
+				for _, i := range info {
+					if i.FQDN == record && i.Type == t {
+						stored = append(stored, i.Content)
+						if s.TTL == 0 {
+							s.TTL = i.Expire
+						}
+					}
+				}
+				if s.Detected == "" && len(stored) == 0 {
+					continue
+				}
+				sort.Strings(stored)
+				s.TransIP = strings.Join(stored, ", ")
+
+				switch {
+				case s.Detected == "":
+					s.Status = "unknown"
+				case s.Detected == s.TransIP:
+					s.Status = "ok"
+				default:
+					s.Status = "mismatch"
+					mismatch++
+				}
+				l = append(l, s)
+			}
+		}
+	}
+
+	if *asJSON {
+		j, err := json.MarshalIndent(l, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(j, '\n'))
+		if err != nil {
+			return err
+		}
+	} else {
+		w := 0
+		for _, s := range l {
+			if len(s.FQDN) > w {
+				w = len(s.FQDN)
+			}
+		}
+		for _, s := range l {
+			ttl := "-"
+			if s.TTL > 0 {
+				ttl = fmt.Sprintf("%d", s.TTL)
+			}
+			fmt.Printf("%-8v %-*v  %-4v  %-6v  detected %v, TransIP %v\n",
+				s.Status, w, s.FQDN, s.Type, ttl, orNone(s.Detected), orNone(s.TransIP))
+		}
+	}
+
+	if mismatch > 0 {
+		return fmt.Errorf("%d of %d records don't match the detected address", mismatch, len(l))
+	}
+	return nil
+}
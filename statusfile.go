@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// After every run a small status file is written to StatusFile (status.json in
+// the StateDir by default), so that monitoring checks and scripts can see when
+// it last ran and if it worked without parsing the logs.
+
+type statusFileT struct {
+	Time      time.Time                `json:"time"`
+	OK        bool                     `json:"ok"`
+	Error     string                   `json:"error,omitempty"`
+	IP        *ipT                     `json:"ip"`
+	Published []published              `json:"published"`
+	Pending   map[string]pendingUpdate `json:"pending"`
+}
+
+func statusFilePath() string {
+	if config.StatusFile != "" || config.StateDir == "" {
+		return config.StatusFile
+	}
+	return filepath.Join(config.StateDir, "status.json")
+}
+
+// writeStatusFile writes the status file for a run.
+func writeStatusFile(ip *ipT, runErr error) {
+	path := statusFilePath()
+	if path == "" {
+		return
+	}
+
+	s := statusFileT{
+		Time:      time.Now(),
+		OK:        runErr == nil,
+		IP:        ip,
+		Published: publishedRecords(),
+		Pending:   readQueue(),
+	}
+	if runErr != nil {
+		s.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		warnf("cannot write status file: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The daemon and dyndns server support systemd's Type=notify: READY=1 is sent
+// once the daemon did its first update (or the dyndns server is listening), the
+// STATUS= is set after every update, and RELOADING=1 and STOPPING=1 are sent on
+// SIGHUP and SIGTERM. With WatchdogSec= WATCHDOG=1 is sent at half the interval,
+// unless an update is stuck for longer than the watchdog interval.
+//
+//   [Service]
+//   Type=notify
+//   ExecStart=/usr/bin/transip-dynamic -config /etc/transip-dynamic -daemon
+//   WatchdogSec=5min
+//
+// The dyndns, metrics, and control listeners can also be passed by socket
+// activation, with the FileDescriptorName= as dyndns, metrics, or control:
+//
+//   [Socket]
+//   ListenStream=8245
+//   FileDescriptorName=dyndns
+//   Service=transip-dynamic.service
+//
+// A listener that isn't passed uses the address from the config.
+
+var (
+	// Time the current update started; zero if there's no update running.
+	sdRunStart   time.Time
+	sdRunStartMu sync.Mutex
+
+	sdListenersOnce sync.Once
+	sdListeners     map[string]net.Listener
+)
+
+// sdNotify sends a message to systemd's $NOTIFY_SOCKET; it does nothing if
+// that's not set.
+func sdNotify(state ...string) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return
+	}
+	if sock[0] == '@' { // Abstract namespace.
+		sock = "\x00" + sock[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		debugf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	if err != nil {
+		debugf("sd_notify: %v", err)
+	}
+}
+
+// sdStatus gets the STATUS= for the result of an update.
+func sdStatus(ip *ipT, err error) string {
+	switch {
+	case err != nil:
+		return "STATUS=update failed: " + strings.Replace(err.Error(), "\n", " ", -1)
+	case ip == nil:
+		return "STATUS=updated at " + time.Now().Format("15:04:05")
+	default:
+		return fmt.Sprintf("STATUS=%v at %v", ip, time.Now().Format("15:04:05"))
+	}
+}
+
+// sdRunning records that an update started (true) or finished (false), for the
+// watchdog.
+func sdRunning(running bool) {
+	sdRunStartMu.Lock()
+	defer sdRunStartMu.Unlock()
+	if running {
+		sdRunStart = time.Now()
+	} else {
+		sdRunStart = time.Time{}
+	}
+}
+
+// sdWatchdog sends WATCHDOG=1 at half the WatchdogSec= interval, as long as no
+// update has been running for longer than the interval.
+func sdWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	timeout := time.Duration(usec) * time.Microsecond
+	debugf("systemd watchdog enabled; timeout %v", timeout)
+	for range time.Tick(timeout / 2) {
+		sdRunStartMu.Lock()
+		start := sdRunStart
+		sdRunStartMu.Unlock()
+		if !start.IsZero() && time.Since(start) > timeout {
+			warnf("update is running for %v; not sending the watchdog ping", time.Since(start).Round(time.Second))
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// sdListen gets the listener passed by systemd with the name, or listens on
+// addr if there isn't one.
+func sdListen(name, addr string) (net.Listener, error) {
+	sdListenersOnce.Do(func() { sdListeners = sdActivated() })
+	if l, ok := sdListeners[name]; ok {
+		infof("using the %v socket from systemd", name)
+		return l, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// sdActivated gets the sockets from systemd's socket activation, indexed by
+// the FileDescriptorName.
+func sdActivated() map[string]net.Listener {
+	defer func() {
+		// Don't pass them on to on-change and such.
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		fd := 3 + i // SD_LISTEN_FDS_START
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			warnf("socket %v (fd %d) from systemd: %v", name, fd, err)
+			continue
+		}
+		listeners[name] = l
+	}
+	return listeners
+}
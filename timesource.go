@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The API rejects requests if the timestamp in the signature is off by more
+// than a few seconds. Many small routers have no RTC and boot with a wrong
+// time, so the TimeSource setting gets the time from a NTP server or the Date
+// header of a HTTPS server instead.
+
+var (
+	clockOffset   time.Duration
+	clockChecked  time.Time
+	clockOffsetMu sync.Mutex
+)
+
+// signingTime gets the time to use in the signature.
+func signingTime() time.Time {
+	if config.TimeSource == "" {
+		return time.Now()
+	}
+
+	clockOffsetMu.Lock()
+	defer clockOffsetMu.Unlock()
+	if time.Since(clockChecked) > time.Hour {
+		off, err := getClockOffset(config.TimeSource)
+		if err != nil {
+			warnf("cannot get the time from %v; using the local clock: %v",
+				config.TimeSource, err)
+		} else {
+			clockOffset = off
+			clockChecked = time.Now()
+		}
+	}
+	return time.Now().Add(clockOffset)
+}
+
+// getClockOffset gets the difference between the local clock and src, which is
+// either a https:// URL or a NTP server.
+func getClockOffset(src string) (time.Duration, error) {
+	if strings.HasPrefix(src, "https://") {
+		return httpClockOffset(src)
+	}
+	return ntpClockOffset(src)
+}
+
+// httpClockOffset gets the time from the Date header. This only has a
+// resolution of a second, which is good enough for the API.
+func httpClockOffset(url string) (time.Duration, error) {
+	start := time.Now()
+	resp, err := httpClient(10 * time.Second).Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+
+	d, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header: %v", err)
+	}
+	return d.Sub(start.Add(rtt / 2)), nil
+}
+
+// ntpClockOffset gets the time with SNTP (RFC 4330).
+func ntpClockOffset(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // Version 4, client mode.
+	start := time.Now()
+	_, err = conn.Write(req)
+	if err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if n < 48 {
+		return 0, errors.New("NTP response is too short")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server is unsynchronized (kiss-o'-death)")
+	}
+
+	// Transmit timestamp, in seconds since 1900.
+	sec := binary.BigEndian.Uint32(resp[40:])
+	frac := binary.BigEndian.Uint32(resp[44:])
+	t := time.Unix(int64(sec)-2208988800, int64(frac)*1e9>>32)
+	return t.Sub(start.Add(rtt / 2)), nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+	"github.com/Carpetsmoker/transip-dynamic/transip/transiptest"
+)
+
+// testConfig starts a fake API and reads a config for it; the extra lines are
+// added to the config.
+func testConfig(t testing.TB, extra string) *transiptest.Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := transiptest.NewServer(&key.PublicKey)
+	t.Cleanup(srv.Close)
+	srv.SetDomain(transip.Domain{Name: "example.com", DNSEntries: []transip.DNSEntry{
+		{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.1"},
+		{Name: "home", Expire: 300, Type: "A", Content: "192.0.2.1"},
+		{Name: "www", Expire: 300, Type: "CNAME", Content: "@"},
+	}})
+
+	dir := t.TempDir()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	keyFile := write("key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	caFile := write("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	cfg := write("config", []byte(fmt.Sprintf(
+		"user test\nkey-file %v\napi %v\nCAFile %v\nstate-dir %v\nrecord home.example.com\n%v\n",
+		keyFile, srv.Endpoint(), caFile, filepath.Join(dir, "state"), extra)))
+
+	config, activeKey, restClientCache = configT{}, nil, nil
+	if err := parseConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestUpdateDomain(t *testing.T) {
+	tests := []struct {
+		transport string
+		want      []string
+	}{
+		{"soap", []string{"getInfo", "setDnsEntries"}},
+		{"rest", []string{"auth", "getDomain", "getDnsEntries", "setDnsEntries"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			srv := testConfig(t, "transport "+tt.transport)
+			ctx := context.Background()
+			err := updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d, _ := srv.Domain("example.com")
+			want := []transip.DNSEntry{
+				{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.1"},
+				{Name: "home", Expire: 300, Type: "A", Content: "198.51.100.1"},
+				{Name: "www", Expire: 300, Type: "CNAME", Content: "@"},
+			}
+			if !reflect.DeepEqual(d.DNSEntries, want) {
+				t.Errorf("\nhave: %v\nwant: %v", d.DNSEntries, want)
+			}
+
+			var methods []string
+			for _, c := range srv.Calls() {
+				if c.Fault != nil {
+					t.Errorf("fault for %v: %v", c.Method, c.Fault)
+				}
+				if c.REST != (tt.transport == "rest") {
+					t.Errorf("%v sent to the wrong API", c.Method)
+				}
+				methods = append(methods, c.Method)
+			}
+			if !reflect.DeepEqual(methods, tt.want) {
+				t.Errorf("calls: %v; want %v", methods, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateDomainFail(t *testing.T) {
+	tests := []struct {
+		transport, method, fault string
+		want                     []string
+	}{
+		// Faults aren't retried, but the next update works; with a new nonce
+		// for SOAP.
+		{"soap", "setDnsEntries", "Internal error",
+			[]string{"getInfo", "setDnsEntries (fault)", "getInfo", "setDnsEntries"}},
+		// The token is re-used for the next update.
+		{"rest", "setDnsEntries", "Internal error",
+			[]string{"auth", "getDomain", "getDnsEntries", "setDnsEntries (fault)",
+				"getDomain", "getDnsEntries", "setDnsEntries"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			srv := testConfig(t, "transport "+tt.transport)
+			srv.Fail(tt.method, transip.FaultError{Code: "100", Message: tt.fault})
+			ctx := context.Background()
+
+			err := updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+			if err == nil || !strings.Contains(err.Error(), tt.fault) {
+				t.Fatalf("wrong error: %v", err)
+			}
+			if f := new(transip.FaultError); tt.transport == "soap" && !errors.As(err, &f) {
+				t.Errorf("not a *transip.FaultError: %#v", err)
+			}
+
+			err = updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, _ := srv.Domain("example.com"); d.DNSEntries[1].Content != "198.51.100.1" {
+				t.Errorf("not updated: %v", d.DNSEntries)
+			}
+
+			var methods []string
+			for _, c := range srv.Calls() {
+				if c.Fault != nil {
+					c.Method += " (fault)"
+				}
+				methods = append(methods, c.Method)
+			}
+			if !reflect.DeepEqual(methods, tt.want) {
+				t.Errorf("calls: %v; want %v", methods, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateDomainStop(t *testing.T) {
+	srv := testConfig(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stop as soon as the write is sent; it should still finish.
+	next := config.apiTransport
+	config.apiTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if strings.Contains(r.Header.Get("SOAPAction"), "setDnsEntries") {
+			cancel()
+		}
+		return next.RoundTrip(r)
+	})
+	config.apiClient = newAPIClient()
+
+	err := updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("not cancelled")
+	}
+	if d, _ := srv.Domain("example.com"); d.DNSEntries[1].Content != "198.51.100.1" {
+		t.Errorf("not updated: %v", d.DNSEntries)
+	}
+
+	// But the fetch for the next one is cancelled.
+	err = updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.2"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestTakeLock(t *testing.T) {
+	srv := testConfig(t, "lock-record _lock.example.com")
+	ctx := context.Background()
+	methods := func(n int) []string {
+		var m []string
+		for _, c := range srv.Calls()[n:] {
+			m = append(m, c.Method)
+		}
+		return m
+	}
+
+	// The lock is written with the update.
+	ok, err := takeLock(ctx, true)
+	if !ok || err != nil {
+		t.Fatalf("takeLock: %v %v", ok, err)
+	}
+	err = updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := methods(0), []string{"getInfo", "getInfo", "setDnsEntries"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+	d, _ := srv.Domain("example.com")
+	if l := d.DNSEntries[len(d.DNSEntries)-1]; l.Name != "_lock" || !strings.Contains(l.Content, "host="+hostname()) {
+		t.Errorf("no lock record: %v", d.DNSEntries)
+	}
+
+	// Nothing changed and the lock is recent: no requests.
+	n := len(srv.Calls())
+	ok, err = takeLock(ctx, false)
+	if !ok || err != nil {
+		t.Fatalf("takeLock: %v %v", ok, err)
+	}
+	if have := methods(n); len(have) != 0 {
+		t.Errorf("calls: %v", have)
+	}
+
+	// Refreshed once it's older than half of LockTimeout.
+	stateMu.Lock()
+	st := readState()
+	st.Lock = time.Now().Add(-config.LockTimeout / 2)
+	writeState(st)
+	stateMu.Unlock()
+	ok, err = takeLock(ctx, false)
+	if !ok || err != nil {
+		t.Fatalf("takeLock: %v %v", ok, err)
+	}
+	if have, want := methods(n), []string{"getInfo", "setDnsEntries"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+
+	// Another host has the lock.
+	d, _ = srv.Domain("example.com")
+	d.DNSEntries[len(d.DNSEntries)-1].Content = heartbeat{Host: "other", Time: time.Now()}.String()
+	srv.SetDomain(d)
+	n = len(srv.Calls())
+	ok, err = takeLock(ctx, true)
+	if ok || err != nil {
+		t.Fatalf("takeLock: %v %v", ok, err)
+	}
+	if have, want := methods(n), []string{"getInfo"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+func TestWriteHeartbeat(t *testing.T) {
+	srv := testConfig(t, "heartbeat-record _hb.example.com")
+	ctx := context.Background()
+	methods := func(n int) []string {
+		var m []string
+		for _, c := range srv.Calls()[n:] {
+			m = append(m, c.Method)
+		}
+		return m
+	}
+
+	// Written if nothing changed, but only once every half HeartbeatInterval.
+	for i, want := range [][]string{{"getInfo", "setDnsEntries"}, nil} {
+		n := len(srv.Calls())
+		if err := writeHeartbeat(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		if have := methods(n); !reflect.DeepEqual(have, want) {
+			t.Errorf("%d: calls: %v; want %v", i, have, want)
+		}
+	}
+	d, _ := srv.Domain("example.com")
+	if h := d.DNSEntries[len(d.DNSEntries)-1]; h.Name != "_hb" || !strings.Contains(h.Content, "host="+hostname()) {
+		t.Errorf("no heartbeat record: %v", d.DNSEntries)
+	}
+
+	// Sent with the update, and not written again after it.
+	stateMu.Lock()
+	st := readState()
+	st.Heartbeat = time.Time{}
+	writeState(st)
+	stateMu.Unlock()
+	n := len(srv.Calls())
+	err := updateDomain(ctx, "example.com", config.Records["example.com"], ipT{IPv4: "198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHeartbeat(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := methods(n), []string{"getInfo", "setDnsEntries"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+	if heartbeatDue() {
+		t.Error("heartbeat still due after the update")
+	}
+}
+
+func TestSplitRecord(t *testing.T) {
+	config = configT{Domains: []string{"dyn.example.net", "example.gov.uk"}}
+	tests := []struct {
+		in, domain, fqdn, err string
+	}{
+		{"home.example.com", "example.com", "home.example.com.", ""},
+		{"Home.Example.COM", "example.com", "home.example.com.", ""},
+		{"home.example.com.", "example.com", "home.example.com.", ""},
+		{" HOME.example.com.. ", "example.com", "home.example.com.", ""},
+		{"example.com", "example.com", "example.com.", ""},
+		{"a.b.example.com", "example.com", "a.b.example.com.", ""},
+		{"*.example.com", "example.com", "*.example.com.", ""},
+		{"_acme.example.com", "example.com", "_acme.example.com.", ""},
+		{"home.example.co.uk", "example.co.uk", "home.example.co.uk.", ""},
+		{"home.dyn.example.net", "dyn.example.net", "home.dyn.example.net.", ""},
+		{"example.uk", "example.uk", "example.uk.", ""},
+		{"home.example.gov.uk", "example.gov.uk", "home.example.gov.uk.", ""},
+		{"www.example.uk", "", "", "can't tell if the domain is example.uk or www.example.uk"},
+		{"home.example.ac.uk", "", "", "can't tell if the domain is ac.uk or example.ac.uk"},
+		{"Bücher.example.com", "example.com", "xn--bcher-kva.example.com.", ""},
+
+		{"", "", "", "doesn't look like a valid FQDN"},
+		{"com", "", "", "doesn't look like a valid FQDN"},
+		{"home..example.com", "", "", "doesn't look like a valid FQDN"},
+		{"-home.example.com", "", "", `invalid label "-home"`},
+		{"home.exa_mple.com", "", "", `invalid label "exa_mple"`},
+		{"a.*.example.com", "", "", `invalid label "*"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			domain, fqdn, err := splitRecord(tt.in)
+			if !errorContains(err, tt.err) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.err)
+			}
+			if domain != tt.domain || fqdn != tt.fqdn {
+				t.Errorf("\nhave: %q, %q\nwant: %q, %q", domain, fqdn, tt.domain, tt.fqdn)
+			}
+		})
+	}
+}
+
+func TestParseConfigDomain(t *testing.T) {
+	tests := []struct {
+		in, wantErr string
+	}{
+		{"record www.example.uk\ndomain example.uk\n", ""},
+		{"domain example.uk\nrecord www.example.uk\n", ""},
+		{"record www.example.uk\n", "record www.example.uk: can't tell if the domain is example.uk or www.example.uk"},
+		{"record www.example.uk\ndomain other.uk\n", "can't tell if the domain is"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			cfg := filepath.Join(t.TempDir(), "config")
+			if err := ioutil.WriteFile(cfg, []byte(tt.in), 0600); err != nil {
+				t.Fatal(err)
+			}
+			config = configT{}
+			err := parseConfig(cfg)
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(config.Records, map[string][]string{"example.uk": {"www.example.uk."}}) {
+				t.Errorf("records: %v", config.Records)
+			}
+		})
+	}
+}
+
+func TestPlanDomain(t *testing.T) {
+	config = configT{}
+	setDefaults()
+
+	// As setFQDN does, but with the case and dot from the test.
+	zone := func(fqdn string) []Info {
+		return []Info{
+			{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.1", FQDN: "example.com."},
+			{Name: "home", Expire: 300, Type: "A", Content: "192.0.2.1", FQDN: fqdn},
+			{Name: "home", Expire: 300, Type: "TXT", Content: "x", FQDN: fqdn},
+		}
+	}
+	tests := []struct {
+		record, fqdn string
+	}{
+		{"home.example.com", "home.example.com."},
+		{"home.example.com", "home.example.com"},
+		{"home.example.com", "Home.Example.COM"},
+		{"home.example.com", "Home.Example.COM."},
+		{"Home.Example.COM", "home.example.com."},
+		{"Home.Example.COM.", "HOME.EXAMPLE.COM"},
+		{"HOME.example.com", "Home.Example.COM."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.record+" "+tt.fqdn, func(t *testing.T) {
+			_, record, err := splitRecord(tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, changes, err := planDomain([]string{record}, zone(tt.fqdn), ipT{IPv4: "198.51.100.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := zone(tt.fqdn)
+			want[1].Content = "198.51.100.1"
+			if !reflect.DeepEqual(info, want) {
+				t.Errorf("\nhave: %v\nwant: %v", info, want)
+			}
+			wantChanges := []planChange{{FQDN: "home.example.com.", Type: "A", Old: "192.0.2.1", New: "198.51.100.1"}}
+			if !reflect.DeepEqual(changes, wantChanges) {
+				t.Errorf("\nhave: %v\nwant: %v", changes, wantChanges)
+			}
+		})
+	}
+
+	// From the API, with the name in a different case.
+	t.Run("setFQDN", func(t *testing.T) {
+		info := []Info{{Name: "Home", Expire: 300, Type: "A", Content: "192.0.2.1"}}
+		setFQDN(info, "Example.COM")
+		_, changes, err := planDomain([]string{"home.example.com."}, info, ipT{IPv4: "198.51.100.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 1 {
+			t.Errorf("changes: %v", changes)
+		}
+	})
+
+	// Not found in any case.
+	t.Run("missing", func(t *testing.T) {
+		_, _, err := planDomain([]string{"other.example.com."}, zone("Home.Example.COM"), ipT{IPv4: "198.51.100.1"})
+		if !errorContains(err, "other.example.com. (doesn't exist)") {
+			t.Errorf("wrong error: %v", err)
+		}
+		_, _, err = planDomain([]string{"home2.example.com."},
+			[]Info{{Name: "home2", Type: "TXT", Content: "x", FQDN: "HOME2.example.com"}}, ipT{IPv4: "198.51.100.1"})
+		if !errorContains(err, "home2.example.com. (only TXT)") {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
+
+// errorContains reports if err contains want, or if err is nil and want is "".
+func errorContains(err error, want string) bool {
+	if err == nil {
+		return want == ""
+	}
+	return want != "" && strings.Contains(err.Error(), want)
+}
+
+// benchZone makes a zone with n records of the common types.
+func benchZone(n int) []Info {
+	info := make([]Info, 0, n)
+	for i := 0; len(info) < n; i++ {
+		host := fmt.Sprintf("host%d", i)
+		info = append(info,
+			Info{Name: host, Expire: 300, Type: "A", Content: fmt.Sprintf("192.0.2.%d", i%256)},
+			Info{Name: host, Expire: 300, Type: "AAAA", Content: fmt.Sprintf("2001:db8::%x", i)},
+			Info{Name: "www." + host, Expire: 3600, Type: "CNAME", Content: host},
+			Info{Name: host, Expire: 3600, Type: "MX", Content: "10 mail." + host},
+			Info{Name: host, Expire: 3600, Type: "TXT", Content: `"v=spf1 a mx -all"`},
+		)
+	}
+	info = info[:n]
+	setFQDN(info, "example.com")
+	return info
+}
+
+func BenchmarkPlanDomain(b *testing.B) {
+	info := benchZone(500)
+	var records []string
+	for i := 0; i < 20; i++ {
+		records = append(records, fmt.Sprintf("host%d.example.com.", i*5))
+	}
+	ip := ipT{IPv4: "198.51.100.1", IPv6: "2001:db8:1::1"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, changes, err := planDomain(records, info, ip)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(changes) != 40 {
+			b.Fatalf("%d changes", len(changes))
+		}
+	}
+}
+
+func TestAPIFlagsCache(t *testing.T) {
+	testConfig(t, "")
+	memoryCache, apiFlagsCache = true, nil
+	defer func() { memoryCache, apiFlagsCache = false, nil }()
+
+	authFailed("Authentication failed")
+	if err := os.Remove(statePath()); err != nil {
+		t.Fatal(err)
+	}
+	stateMu.Lock()
+	a := readAPIFlags().Auth
+	stateMu.Unlock()
+	if a.Failures != 1 || a.LastError != "Authentication failed" {
+		t.Fatalf("not cached: %+v", a)
+	}
+
+	// Taken from the state again once it's forgotten.
+	apiFlagsCache = nil
+	stateMu.Lock()
+	a = readAPIFlags().Auth
+	stateMu.Unlock()
+	if a.Failures != 0 {
+		t.Fatalf("still cached: %+v", a)
+	}
+}
+
+func BenchmarkAPIClient(b *testing.B) {
+	testConfig(b, "")
+	config.apiTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			Request:    r,
+		}, nil
+	})
+	config.apiClient = newAPIClient()
+	stateMu.Lock()
+	writeState(readState())
+	stateMu.Unlock()
+
+	for _, mem := range []bool{false, true} {
+		b.Run(fmt.Sprintf("memory=%t", mem), func(b *testing.B) {
+			memoryCache, apiFlagsCache = mem, nil
+			defer func() { memoryCache, apiFlagsCache = false, nil }()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				// A write, so both authBreaker and readOnly check the state.
+				req, err := http.NewRequest("PUT", "https://api.transip.nl/v6/domains/example.com/dns", strings.NewReader("{}"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp, err := apiClient().Do(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+	}
+}
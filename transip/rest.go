@@ -0,0 +1,305 @@
+package transip
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The REST API (v6) uses the same login and key as the SOAP API; a token is
+// requested with a signed request to /auth, and sent as a bearer token for
+// everything else. Tokens are valid for 30 minutes.
+
+// RESTClient is a client for the REST API (v6).
+type RESTClient struct {
+	// Username and private key from the TransIP control panel.
+	Login      string
+	PrivateKey *rsa.PrivateKey
+
+	// Hostname of the API; DefaultEndpoint if it's empty.
+	Endpoint string
+
+	// Send requests with this client; the default is a client with a timeout
+	// of 30 seconds. Unlike the SOAP API nothing needs to be signed in the
+	// transport.
+	HTTPClient *http.Client
+
+	// Request read-only tokens.
+	ReadOnly bool
+
+	// Label for new tokens, which is shown in the control panel; the time is
+	// appended to make it unique. Default is "transip".
+	Label string
+
+	// Store tokens here, so they can be re-used by other processes; tokens
+	// are only kept in memory if it's nil.
+	Tokens TokenStore
+
+	mu    sync.Mutex
+	token Token
+}
+
+// Token is an access token for the REST API.
+type Token struct {
+	Key      string    `json:"key,omitempty"` // Fingerprint of the login and key.
+	ReadOnly bool      `json:"read_only,omitempty"`
+	Token    string    `json:"token,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// TokenStore stores tokens for a RESTClient.
+type TokenStore interface {
+	// Load gets the stored token, or the zero Token if there isn't one.
+	Load() (Token, error)
+
+	// Store stores a new token; the zero Token means the stored token should
+	// be forgotten.
+	Store(Token) error
+}
+
+// StatusError is an error response from the REST API.
+type StatusError struct {
+	Method, Path string
+	Code         int
+	Message      string // Error from the response, or the HTTP status.
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("transip: %v %v: %v", e.Method, e.Path, e.Message)
+}
+
+func (c *RESTClient) endpoint() string {
+	if c.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return c.Endpoint
+}
+
+func (c *RESTClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+	return c.HTTPClient
+}
+
+// keyID gets the fingerprint of the login and key; a token made with a
+// different key is never used.
+func (c *RESTClient) keyID() string {
+	if c.PrivateKey == nil {
+		return ""
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&c.PrivateKey.PublicKey)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(append([]byte(c.Login+"\x00"), pub...))
+	return hex.EncodeToString(h[:8])
+}
+
+// Token gets a token, requesting a new one if there isn't a valid one.
+func (c *RESTClient) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.keyID()
+	valid := func(t Token) bool {
+		return t.Token != "" && t.Key == key && t.ReadOnly == c.ReadOnly && time.Now().Before(t.Expires)
+	}
+	if valid(c.token) {
+		return c.token.Token, nil
+	}
+	if c.Tokens != nil {
+		if t, err := c.Tokens.Load(); err == nil && valid(t) {
+			c.token = t
+			return t.Token, nil
+		}
+	}
+
+	if c.Login == "" || c.PrivateKey == nil {
+		return "", errors.New("transip: need a Login and PrivateKey")
+	}
+	b := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return "", err
+	}
+	label := c.Label
+	if label == "" {
+		label = "transip"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"login":           c.Login,
+		"nonce":           fmt.Sprintf("%x", b),
+		"read_only":       c.ReadOnly,
+		"expiration_time": "30 minutes",
+		"label":           fmt.Sprintf("%v %d", label, time.Now().Unix()),
+		"global_key":      true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	h := sha512.Sum512(body)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA512, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%v/v6/auth", c.endpoint()), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Signature", base64.StdEncoding.EncodeToString(sig))
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err = c.do(req, &resp)
+	if err != nil {
+		return "", fmt.Errorf("cannot get REST API token: %w", err)
+	}
+
+	// Renew it a bit before it expires.
+	c.token = Token{Key: key, ReadOnly: c.ReadOnly, Token: resp.Token, Expires: time.Now().Add(25 * time.Minute)}
+	if c.Tokens != nil {
+		c.Tokens.Store(c.token)
+	}
+	return c.token.Token, nil
+}
+
+// ForgetToken forgets the token, for example if it was revoked.
+func (c *RESTClient) ForgetToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = Token{}
+	if c.Tokens != nil {
+		c.Tokens.Store(Token{})
+	}
+}
+
+// Do sends a request to the API; path is relative to /v6, and in is sent as
+// JSON if it's not nil. The response is read in to out if it's not nil.
+//
+// Error responses are returned as a *StatusError; the token is forgotten on a
+// 401 response.
+func (c *RESTClient) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		j, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(j)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("https://%v/v6%v", c.endpoint(), path), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	err = c.do(req, out)
+	var sErr *StatusError
+	if errors.As(err, &sErr) && sErr.Code == http.StatusUnauthorized {
+		c.ForgetToken()
+	}
+	return err
+}
+
+func (c *RESTClient) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		sErr := &StatusError{Method: req.Method, Path: req.URL.Path, Code: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			sErr.Message = e.Error
+		}
+		return sErr
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// GetInfo gets the information for a domain; this is two requests, for the
+// domain and the DNS entries.
+func (c *RESTClient) GetInfo(ctx context.Context, domain string) (*Domain, error) {
+	var d struct {
+		Domain struct {
+			IsTransferLocked bool   `json:"isTransferLocked"`
+			RegistrationDate string `json:"registrationDate"`
+			RenewalDate      string `json:"renewalDate"`
+		} `json:"domain"`
+	}
+	err := c.Do(ctx, "GET", "/domains/"+url.PathEscape(domain), nil, &d)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.GetDNSEntries(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	return &Domain{
+		Name:             domain,
+		DNSEntries:       entries,
+		IsLocked:         d.Domain.IsTransferLocked,
+		RegistrationDate: d.Domain.RegistrationDate,
+		RenewalDate:      d.Domain.RenewalDate,
+	}, nil
+}
+
+// GetDNSEntries gets all DNS entries for a domain.
+func (c *RESTClient) GetDNSEntries(ctx context.Context, domain string) ([]DNSEntry, error) {
+	var resp struct {
+		DNSEntries []DNSEntry `json:"dnsEntries"`
+	}
+	err := c.Do(ctx, "GET", "/domains/"+url.PathEscape(domain)+"/dns", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.DNSEntries, nil
+}
+
+// SetDNSEntries replaces all DNS entries for a domain.
+func (c *RESTClient) SetDNSEntries(ctx context.Context, domain string, entries []DNSEntry) error {
+	if entries == nil {
+		entries = []DNSEntry{}
+	}
+	return c.Do(ctx, "PUT", "/domains/"+url.PathEscape(domain)+"/dns",
+		map[string]interface{}{"dnsEntries": entries}, nil)
+}
@@ -0,0 +1,315 @@
+package transip
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This is a very hacky and ad-hoc SOAP implementation that just happens to
+// work with the TransIP API.
+
+const (
+	clientVersion = "5.2"
+	mode          = "readwrite"
+	soapHeader    = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope
+	xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"
+	xmlns:ns1="http://www.transip.nl/soap"
+	xmlns:xsd="http://www.w3.org/2001/XMLSchema"
+	xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/"
+	SOAP-ENV:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"
+>
+	<SOAP-ENV:Body>`
+)
+
+// Call is a SOAP method call.
+type Call struct {
+	Service, Method string
+
+	// Parameters for the signature.
+	Params []string
+
+	// The method element in the SOAP body.
+	Body string
+}
+
+type callKey struct{}
+
+// CallFromContext gets the Call from the context of a request made with
+// Call.Request.
+func CallFromContext(ctx context.Context) (Call, bool) {
+	c, ok := ctx.Value(callKey{}).(Call)
+	return c, ok
+}
+
+// Request creates a HTTP request for the call; it still needs to be signed
+// with Client.Sign.
+func (c Call) Request(ctx context.Context, endpoint string) (*http.Request, error) {
+	req, err := http.NewRequest("POST",
+		fmt.Sprintf("https://%v/soap/?service=%v", endpoint, c.Service),
+		strings.NewReader(soapHeader+" "+c.Body+" </SOAP-ENV:Body> </SOAP-ENV:Envelope>"))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(context.WithValue(ctx, callKey{}, c))
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", fmt.Sprintf("urn:%v#%vServer#%v", c.Service, c.Service, c.Method))
+	return req, nil
+}
+
+// GetInfo is the call to get the information and DNS entries for a domain;
+// use ParseDomain to read the response.
+func GetInfo(domain string) Call {
+	return Call{
+		Service: "DomainService",
+		Method:  "getInfo",
+		Params:  []string{domain},
+		Body: fmt.Sprintf(`
+		<ns1:getInfo>
+			<domainName xsi:type="xsd:string">%v</domainName>
+		</ns1:getInfo>`, xmlEscape(domain)),
+	}
+}
+
+// GetDomainNames is the call to get the names of all domains in the account;
+// use ParseDomainNames to read the response.
+func GetDomainNames() Call {
+	return Call{
+		Service: "DomainService",
+		Method:  "getDomainNames",
+		Body:    `<ns1:getDomainNames></ns1:getDomainNames>`,
+	}
+}
+
+// SetDNSEntries is the call to replace all DNS entries for a domain.
+func SetDNSEntries(domain string, entries []DNSEntry) Call {
+	// This is about 300 bytes per record for the body, and 100 for the
+	// parameters; allocate it in one go, as zones can be quite large.
+	var body, params strings.Builder
+	body.Grow(300 + len(entries)*300)
+	params.Grow(len(entries) * 100)
+
+	fmt.Fprintf(&body, `
+		<ns1:setDnsEntries>
+			<domainName xsi:type="xsd:string">%v</domainName>
+			<dnsEntries SOAP-ENC:arrayType="ns1:DnsEntry[%v]" xsi:type="ns1:ArrayOfDnsEntry">
+	`, xmlEscape(domain), len(entries))
+
+	for c, e := range entries {
+		fmt.Fprintf(&body, `
+			<item xsi:type="ns1:DnsEntry">
+				<name xsi:type="xsd:string">%v</name>
+				<expire xsi:type="xsd:int">%v</expire>
+				<type xsi:type="xsd:string">%v</type>
+				<content xsi:type="xsd:string">%v</content>
+			</item>
+			`, xmlEscape(e.Name), e.Expire, xmlEscape(e.Type), xmlEscape(e.Content))
+
+		fmt.Fprintf(&params, "1[%v][name]=%v&", c, url.QueryEscape(e.Name))
+		fmt.Fprintf(&params, "1[%v][expire]=%v&", c, e.Expire)
+		fmt.Fprintf(&params, "1[%v][type]=%v&", c, url.QueryEscape(e.Type))
+		fmt.Fprintf(&params, "1[%v][content]=%v&", c, url.QueryEscape(e.Content))
+	}
+	body.WriteString("</dnsEntries></ns1:setDnsEntries>")
+
+	return Call{
+		Service: "DomainService",
+		Method:  "setDnsEntries",
+		Params:  []string{domain, params.String()},
+		Body:    body.String(),
+	}
+}
+
+// ParseDomain parses the response from GetInfo.
+func ParseDomain(domain string, data []byte) (*Domain, error) {
+	var env struct {
+		Body struct {
+			Return struct {
+				DNSEntries       []DNSEntry `xml:"dnsEntries>item"`
+				IsLocked         bool       `xml:"isLocked"`
+				RegistrationDate string     `xml:"registrationDate"`
+				RenewalDate      string     `xml:"renewalDate"`
+			} `xml:"getInfoResponse>return"`
+		}
+	}
+	err := xml.Unmarshal(data, &env)
+	if err != nil {
+		return nil, err
+	}
+	r := env.Body.Return
+	return &Domain{
+		Name:             domain,
+		DNSEntries:       r.DNSEntries,
+		IsLocked:         r.IsLocked,
+		RegistrationDate: r.RegistrationDate,
+		RenewalDate:      r.RenewalDate,
+	}, nil
+}
+
+// ParseDomainNames parses the response from GetDomainNames.
+func ParseDomainNames(data []byte) ([]string, error) {
+	var env struct {
+		Body struct {
+			Names []string `xml:"getDomainNamesResponse>return>item"`
+		}
+	}
+	err := xml.Unmarshal(data, &env)
+	if err != nil {
+		return nil, err
+	}
+	return env.Body.Names, nil
+}
+
+// Sign adds the authentication cookies and signature to a request made with
+// Call.Request. Other requests are returned as-is.
+//
+// The signature includes a nonce and the time, so this needs to be done again
+// if the request is retried.
+func (c *Client) Sign(req *http.Request) (*http.Request, error) {
+	call, ok := CallFromContext(req.Context())
+	if !ok {
+		return req, nil
+	}
+
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	ts := strconv.FormatInt(now().Unix(), 10)
+	b := make([]byte, 4)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return nil, err
+	}
+	nonce := fmt.Sprintf("%x", b)
+
+	sig, err := sign(c.PrivateKey, call, c.endpoint(), ts, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.AddCookie(&http.Cookie{Name: "login", Value: c.Login})
+	req.AddCookie(&http.Cookie{Name: "mode", Value: mode})
+	req.AddCookie(&http.Cookie{Name: "timestamp", Value: ts})
+	req.AddCookie(&http.Cookie{Name: "nonce", Value: nonce})
+	req.AddCookie(&http.Cookie{Name: "clientVersion", Value: clientVersion})
+	req.AddCookie(&http.Cookie{Name: "signature", Value: url.QueryEscape(sig)})
+	return req, nil
+}
+
+func sign(key *rsa.PrivateKey, call Call, endpoint, ts, nonce string) (string, error) {
+	if key == nil {
+		return "", fmt.Errorf("transip: no private key to sign %v", call.Method)
+	}
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA512, signHash(call, endpoint, ts, nonce))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature for a call, as the API does; sig is the
+// signature as in the cookie, after unescaping.
+func Verify(key *rsa.PublicKey, call Call, endpoint, ts, nonce, sig string) error {
+	b, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("transip: invalid signature: %w", err)
+	}
+	return rsa.VerifyPKCS1v15(key, crypto.SHA512, signHash(call, endpoint, ts, nonce), b)
+}
+
+func signHash(call Call, endpoint, ts, nonce string) []byte {
+	hash := sha512.New()
+	if len(call.Params) > 0 && call.Params[0] != "" {
+		fmt.Fprintf(hash, "0=%v&", call.Params[0])
+	}
+	if len(call.Params) > 1 && call.Params[1] != "" {
+		fmt.Fprintf(hash, "%v", call.Params[1])
+	}
+	fmt.Fprintf(hash, "__method=%v&__service=%v&__hostname=%v&__timestamp=%v&__nonce=%v",
+		call.Method, call.Service, endpoint, ts, nonce)
+	return hash.Sum(nil)
+}
+
+// FaultError is a SOAP fault from the API.
+//
+// The codes aren't documented and aren't very specific, so Auth and Temporary
+// recognize the common problems from the message.
+type FaultError struct {
+	Code    string
+	Message string
+}
+
+var (
+	// Faults which mean the key or user are wrong, or that the key isn't
+	// allowed to use the API from this IP.
+	reAuthFault     = regexp.MustCompile(`(?i)signature|authenticat|login|whitelist|not allowed`)
+	reReadOnlyFault = regexp.MustCompile(`(?i)read[- ]?only`)
+	reRateFault     = regexp.MustCompile(`(?i)rate.?limit|too many (requests|calls)`)
+)
+
+func (f *FaultError) Error() string {
+	return fmt.Sprintf("transip: API error %v: %v", f.Code, f.Message)
+}
+
+// Auth reports if this is an authentication failure: the key or user are
+// wrong, or the key is restricted to other IPs. A key which is restricted to
+// read-only isn't an authentication failure.
+func (f *FaultError) Auth() bool {
+	return reAuthFault.MatchString(f.Message) && !reReadOnlyFault.MatchString(f.Message)
+}
+
+// Temporary reports if the call may work if it's tried again later, because
+// the API rejected it for sending too many requests.
+func (f *FaultError) Temporary() bool { return reRateFault.MatchString(f.Message) }
+
+// ParseFault gets the fault from a SOAP response, or nil if it's not a fault.
+func ParseFault(body []byte) *FaultError {
+	if !bytes.Contains(body, []byte("Fault")) {
+		return nil
+	}
+
+	var env struct {
+		Body struct {
+			Fault *struct {
+				Code   string `xml:"faultcode"`
+				String string `xml:"faultstring"`
+			} `xml:"Fault"`
+		} `xml:"Body"`
+	}
+	if xml.Unmarshal(body, &env) != nil || env.Body.Fault == nil {
+		return nil
+	}
+	return &FaultError{
+		Code:    strings.TrimSpace(env.Body.Fault.Code),
+		Message: strings.TrimSpace(env.Body.Fault.String),
+	}
+}
+
+// xmlEscape escapes s for use in XML text; content from TXT records can
+// contain anything.
+func xmlEscape(s string) string {
+	if !strings.ContainsAny(s, "<>&'\"\r\n\t") {
+		return s
+	}
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package transip_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// benchZone makes a zone with n entries of the common types.
+func benchZone(n int) []transip.DNSEntry {
+	entries := make([]transip.DNSEntry, 0, n)
+	for i := 0; len(entries) < n; i++ {
+		host := fmt.Sprintf("host%d", i)
+		entries = append(entries,
+			transip.DNSEntry{Name: host, Expire: 300, Type: "A", Content: fmt.Sprintf("192.0.2.%d", i%256)},
+			transip.DNSEntry{Name: host, Expire: 300, Type: "AAAA", Content: fmt.Sprintf("2001:db8::%x", i)},
+			transip.DNSEntry{Name: "www." + host, Expire: 3600, Type: "CNAME", Content: host},
+			transip.DNSEntry{Name: host, Expire: 3600, Type: "MX", Content: "10 mail." + host},
+			transip.DNSEntry{Name: host, Expire: 3600, Type: "TXT", Content: `"v=spf1 a mx -all" & <more>`},
+		)
+	}
+	return entries[:n]
+}
+
+func BenchmarkParseDomain(b *testing.B) {
+	var items strings.Builder
+	for _, e := range benchZone(500) {
+		fmt.Fprintf(&items, "<item><name>%v</name><expire>%v</expire><type>%v</type><content>%v</content></item>",
+			e.Name, e.Expire, e.Type, strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(e.Content))
+	}
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="http://www.transip.nl/soap">` +
+		`<SOAP-ENV:Body><ns1:getInfoResponse><return><dnsEntries>` + items.String() + `</dnsEntries>` +
+		`<isLocked>false</isLocked><registrationDate>2010-01-01</registrationDate><renewalDate>2030-01-01</renewalDate>` +
+		`</return></ns1:getInfoResponse></SOAP-ENV:Body></SOAP-ENV:Envelope>`)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		d, err := transip.ParseDomain("example.com", data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(d.DNSEntries) != 500 {
+			b.Fatalf("%d entries", len(d.DNSEntries))
+		}
+	}
+}
+
+func BenchmarkSetDNSEntries(b *testing.B) {
+	entries := benchZone(500)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = transip.SetDNSEntries("example.com", entries)
+	}
+}
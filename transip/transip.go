@@ -0,0 +1,177 @@
+// Package transip is a client for the DNS part of the TransIP API.
+//
+// Basic usage:
+//
+//	c := &transip.Client{Login: "user", PrivateKey: key}
+//	entries, err := c.GetDNSEntries(ctx, "example.com")
+//	...
+//	entries = append(entries, transip.DNSEntry{Name: "www", Expire: 300, Type: "A", Content: "203.0.113.5"})
+//	err = c.SetDNSEntries(ctx, "example.com", entries)
+//
+// The API always replaces the entire zone, so SetDNSEntries needs all the
+// entries, not just the ones that changed.
+//
+// The Client doesn't retry anything. Requests are signed in the transport (see
+// Client.Transport), so a http.RoundTripper which retries requests can be used
+// as long as it's wrapped around it; the API won't accept the same signature
+// twice. Call, Client.Sign, ParseDomain, and ParseFault can be used to build
+// something more elaborate.
+//
+// RESTClient has the same methods for the REST API (v6). It requests a token
+// with the key when needed; set RESTClient.Tokens to share the token between
+// processes.
+package transip // import "github.com/Carpetsmoker/transip-dynamic/transip"
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultEndpoint is the hostname of the API.
+const DefaultEndpoint = "api.transip.nl"
+
+// DNSEntry is a single DNS record.
+type DNSEntry struct {
+	// Name relative to the domain, or "@" for the domain itself.
+	Name string `xml:"name" json:"name"`
+
+	// TTL in seconds.
+	Expire int `xml:"expire" json:"expire"`
+
+	// Type, such as "A" or "TXT", and the content as in a zone file.
+	Type    string `xml:"type" json:"type"`
+	Content string `xml:"content" json:"content"`
+}
+
+func (e DNSEntry) String() string {
+	return fmt.Sprintf("%-24v%-7v IN      %-7v %v", e.Name, e.Expire, e.Type, e.Content)
+}
+
+// Domain is the information for a domain from GetInfo.
+type Domain struct {
+	Name             string
+	DNSEntries       []DNSEntry
+	IsLocked         bool
+	RegistrationDate string // As YYYY-MM-DD.
+	RenewalDate      string
+}
+
+// Client for the API.
+type Client struct {
+	// Username and private key from the TransIP control panel.
+	Login      string
+	PrivateKey *rsa.PrivateKey
+
+	// Hostname of the API; DefaultEndpoint if it's empty.
+	Endpoint string
+
+	// Send requests with this client. The Transport needs to be wrapped with
+	// Client.Transport to sign the requests, for example:
+	//
+	//   c.HTTPClient = &http.Client{Timeout: 10 * time.Second, Transport: c.Transport(nil)}
+	//
+	// The default is a client with a timeout of 30 seconds.
+	HTTPClient *http.Client
+
+	// Get the time for the signature; time.Now if it's nil. The API rejects
+	// requests if the clock is off by more than a few seconds.
+	Now func() time.Time
+}
+
+func (c *Client) endpoint() string {
+	if c.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return c.Endpoint
+}
+
+// Transport wraps next to sign all requests for a Call; this is done for every
+// attempt, so next can be wrapped in something that retries requests.
+func (c *Client) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req, err := c.Sign(req)
+		if err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+// Do sends the call, and returns the response body. A SOAP fault is returned
+// as a *FaultError.
+func (c *Client) Do(ctx context.Context, call Call) ([]byte, error) {
+	if c.Login == "" || c.PrivateKey == nil {
+		return nil, errors.New("transip: need a Login and PrivateKey")
+	}
+	req, err := call.Request(ctx, c.endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second, Transport: c.Transport(nil)}
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if f := ParseFault(data); f != nil {
+		return nil, f
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("transip: %v: %v", call.Method, resp.Status)
+	}
+	return data, nil
+}
+
+// GetInfo gets the information for a domain.
+func (c *Client) GetInfo(ctx context.Context, domain string) (*Domain, error) {
+	data, err := c.Do(ctx, GetInfo(domain))
+	if err != nil {
+		return nil, err
+	}
+	return ParseDomain(domain, data)
+}
+
+// GetDomainNames gets the names of all domains in the account.
+func (c *Client) GetDomainNames(ctx context.Context) ([]string, error) {
+	data, err := c.Do(ctx, GetDomainNames())
+	if err != nil {
+		return nil, err
+	}
+	return ParseDomainNames(data)
+}
+
+// GetDNSEntries gets all DNS entries for a domain.
+func (c *Client) GetDNSEntries(ctx context.Context, domain string) ([]DNSEntry, error) {
+	d, err := c.GetInfo(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	return d.DNSEntries, nil
+}
+
+// SetDNSEntries replaces all DNS entries for a domain.
+func (c *Client) SetDNSEntries(ctx context.Context, domain string, entries []DNSEntry) error {
+	_, err := c.Do(ctx, SetDNSEntries(domain, entries))
+	return err
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,170 @@
+package transiptest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// The REST API (v6) only has the endpoints transip-dynamic uses:
+//
+//   POST /v6/auth                 auth
+//   GET  /v6/domains/{name}       getDomain
+//   GET  /v6/domains/{name}/dns   getDnsEntries
+//   PUT  /v6/domains/{name}/dns   setDnsEntries
+//
+// A token from /v6/auth is needed for the others. The token request is signed
+// with the body, and the nonce in it can't be used twice. Faults from Fail are
+// sent as {"error": "message"}, with 401 for Auth faults, 429 for Temporary
+// ones, and 500 for everything else.
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := Call{REST: true}
+	path := strings.TrimPrefix(r.URL.Path, "/v6")
+	switch {
+	case r.Method == "POST" && path == "/auth":
+		c.Method = "auth"
+	case r.Method == "GET" && strings.HasPrefix(path, "/domains/") && strings.HasSuffix(path, "/dns"):
+		c.Method = "getDnsEntries"
+	case r.Method == "PUT" && strings.HasPrefix(path, "/domains/") && strings.HasSuffix(path, "/dns"):
+		c.Method = "setDnsEntries"
+	case r.Method == "GET" && strings.HasPrefix(path, "/domains/"):
+		c.Method = "getDomain"
+	default:
+		restError(w, http.StatusNotFound, fmt.Sprintf("%v %v not found", r.Method, r.URL.Path))
+		return
+	}
+	if c.Method != "auth" {
+		c.Domain, _ = url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/domains/"), "/dns"))
+	}
+
+	var (
+		status int
+		token  string
+	)
+	switch c.Method {
+	case "auth":
+		c.Login, token, status, c.Fault = s.checkAuth(r, body)
+	default:
+		c.Login, status, c.Fault = s.checkToken(r, c)
+	}
+	if c.Method == "setDnsEntries" && c.Fault == nil {
+		var req struct {
+			DNSEntries []transip.DNSEntry `json:"dnsEntries"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			status, c.Fault = http.StatusBadRequest, &transip.FaultError{Message: "invalid body: " + err.Error()}
+		}
+		c.Entries = req.DNSEntries
+	}
+	if c.Fault == nil && len(s.faults[c.Method]) > 0 {
+		c.Fault = &s.faults[c.Method][0]
+		s.faults[c.Method] = s.faults[c.Method][1:]
+		switch {
+		case c.Fault.Auth():
+			status = http.StatusUnauthorized
+		case c.Fault.Temporary():
+			status = http.StatusTooManyRequests
+		default:
+			status = http.StatusInternalServerError
+		}
+	}
+	s.calls = append(s.calls, c)
+
+	if c.Fault != nil {
+		restError(w, status, c.Fault.Message)
+		return
+	}
+
+	d := s.zones[c.Domain]
+	switch c.Method {
+	case "auth":
+		restJSON(w, map[string]interface{}{"token": token})
+	case "getDomain":
+		restJSON(w, map[string]interface{}{"domain": map[string]interface{}{
+			"name":             d.Name,
+			"isTransferLocked": d.IsLocked,
+			"registrationDate": d.RegistrationDate,
+			"renewalDate":      d.RenewalDate,
+		}})
+	case "getDnsEntries":
+		entries := d.DNSEntries
+		if entries == nil {
+			entries = []transip.DNSEntry{}
+		}
+		restJSON(w, map[string]interface{}{"dnsEntries": entries})
+	case "setDnsEntries":
+		d.DNSEntries = c.Entries
+		s.zones[c.Domain] = d
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkAuth checks a token request, and gets the login and a new token if it's
+// valid.
+func (s *Server) checkAuth(r *http.Request, body []byte) (string, string, int, *transip.FaultError) {
+	var req struct {
+		Login string `json:"login"`
+		Nonce string `json:"nonce"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Login == "" || req.Nonce == "" {
+		return req.Login, "", http.StatusBadRequest, &transip.FaultError{Message: "Login and nonce are required"}
+	}
+
+	if s.PublicKey != nil {
+		if s.nonces[req.Nonce] {
+			return req.Login, "", http.StatusUnauthorized, &transip.FaultError{Message: "Nonce was already used"}
+		}
+		sig, err := base64.StdEncoding.DecodeString(r.Header.Get("Signature"))
+		h := sha512.Sum512(body)
+		if err != nil || rsa.VerifyPKCS1v15(s.PublicKey, crypto.SHA512, h[:], sig) != nil {
+			return req.Login, "", http.StatusUnauthorized, &transip.FaultError{Message: "Signature is invalid"}
+		}
+		s.nonces[req.Nonce] = true
+	}
+
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return req.Login, "", http.StatusInternalServerError, &transip.FaultError{Message: err.Error()}
+	}
+	token := fmt.Sprintf("%x", b)
+	s.tokens[token] = req.Login
+	return req.Login, token, 0, nil
+}
+
+// checkToken checks the token and domain for a call, and gets the login the
+// token is for.
+func (s *Server) checkToken(r *http.Request, c Call) (string, int, *transip.FaultError) {
+	login, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		return "", http.StatusUnauthorized, &transip.FaultError{Message: "Your access token has been revoked"}
+	}
+	if _, ok := s.zones[c.Domain]; !ok {
+		return login, http.StatusNotFound, &transip.FaultError{Message: fmt.Sprintf("Domain with name '%v' not found", c.Domain)}
+	}
+	return login, 0, nil
+}
+
+func restJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func restError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,261 @@
+// Package transiptest implements a fake TransIP API, for tests. This is mostly
+// the SOAP API, and the few endpoints of the REST API (v6) for DNS records.
+//
+// Basic usage:
+//
+//	srv := transiptest.NewServer(&key.PublicKey)
+//	defer srv.Close()
+//	srv.SetDomain(transip.Domain{Name: "example.com", DNSEntries: entries})
+//
+//	c := srv.Client("user", key)
+//	err := c.SetDNSEntries(ctx, "example.com", newEntries)
+//	calls := srv.Calls()
+//
+// RESTClient gets a transip.RESTClient for the same server and domains; see
+// rest.go for the REST endpoints.
+//
+// Signatures are verified like the API does, including rejecting a nonce that
+// was already used. Fail can be used to make the next calls return a fault, for
+// testing error handling and retries.
+package transiptest // import "github.com/Carpetsmoker/transip-dynamic/transip/transiptest"
+
+import (
+	"crypto/rsa"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+)
+
+// Call is a request the server received.
+type Call struct {
+	// getDomainNames, getInfo, or setDnsEntries for SOAP, and auth,
+	// getDomain, getDnsEntries, or setDnsEntries for REST.
+	Method  string
+	REST    bool
+	Login   string
+	Domain  string
+	Entries []transip.DNSEntry // For setDnsEntries.
+
+	// Set if the call was rejected, with a fault from Fail or because the
+	// signature or domain was wrong.
+	Fault *transip.FaultError
+}
+
+// Server is a fake TransIP API.
+type Server struct {
+	*httptest.Server
+
+	// Verify signatures with this key; signatures aren't verified if it's
+	// nil.
+	PublicKey *rsa.PublicKey
+
+	mu     sync.Mutex
+	zones  map[string]transip.Domain
+	calls  []Call
+	nonces map[string]bool
+	tokens map[string]string // REST token → login
+	faults map[string][]transip.FaultError
+}
+
+// NewServer starts a new server; use Close to stop it.
+func NewServer(key *rsa.PublicKey) *Server {
+	s := &Server{
+		PublicKey: key,
+		zones:     make(map[string]transip.Domain),
+		nonces:    make(map[string]bool),
+		tokens:    make(map[string]string),
+		faults:    make(map[string][]transip.FaultError),
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint gets the endpoint to use in transip.Client.
+func (s *Server) Endpoint() string { return s.Listener.Addr().String() }
+
+// Client gets a client for the server.
+func (s *Server) Client(login string, key *rsa.PrivateKey) *transip.Client {
+	c := &transip.Client{Login: login, PrivateKey: key, Endpoint: s.Endpoint()}
+	hc := s.Server.Client()
+	hc.Transport = c.Transport(hc.Transport)
+	c.HTTPClient = hc
+	return c
+}
+
+// RESTClient gets a client for the REST API of the server.
+func (s *Server) RESTClient(login string, key *rsa.PrivateKey) *transip.RESTClient {
+	return &transip.RESTClient{Login: login, PrivateKey: key, Endpoint: s.Endpoint(), HTTPClient: s.Server.Client()}
+}
+
+// SetDomain adds a domain, or replaces it if it exists.
+func (s *Server) SetDomain(d transip.Domain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.DNSEntries = append([]transip.DNSEntry(nil), d.DNSEntries...)
+	s.zones[d.Name] = d
+}
+
+// Domain gets a domain as it is now.
+func (s *Server) Domain(name string) (transip.Domain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.zones[name]
+	d.DNSEntries = append([]transip.DNSEntry(nil), d.DNSEntries...)
+	return d, ok
+}
+
+// Calls gets all calls the server received, in order.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Fail makes the next calls to method return the faults, one for every call.
+// The method names are as in Call; setDnsEntries is the same for both APIs.
+func (s *Server) Fail(method string, faults ...transip.FaultError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[method] = append(s.faults[method], faults...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v6/") {
+		s.serveREST(w, r, body)
+		return
+	}
+
+	var env struct {
+		Body struct {
+			Call struct {
+				XMLName xml.Name
+				Domain  string             `xml:"domainName"`
+				Entries []transip.DNSEntry `xml:"dnsEntries>item"`
+			} `xml:",any"`
+		}
+	}
+	if r.URL.Path != "/soap/" || xml.Unmarshal(body, &env) != nil {
+		http.Error(w, "not a SOAP request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := Call{
+		Method:  env.Body.Call.XMLName.Local,
+		Login:   cookie(r, "login"),
+		Domain:  env.Body.Call.Domain,
+		Entries: env.Body.Call.Entries,
+	}
+	c.Fault = s.check(r, c)
+	if c.Fault == nil && len(s.faults[c.Method]) > 0 {
+		c.Fault = &s.faults[c.Method][0]
+		s.faults[c.Method] = s.faults[c.Method][1:]
+	}
+	s.calls = append(s.calls, c)
+
+	if c.Fault != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `%v<SOAP-ENV:Fault><faultcode>%v</faultcode><faultstring>%v</faultstring></SOAP-ENV:Fault>%v`,
+			envStart, escape(c.Fault.Code), escape(c.Fault.Message), envEnd)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	d := s.zones[c.Domain]
+	switch c.Method {
+	case "getDomainNames":
+		names := make([]string, 0, len(s.zones))
+		for n := range s.zones {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, n := range names {
+			fmt.Fprintf(&b, "<item>%v</item>", escape(n))
+		}
+		fmt.Fprintf(w, "%v<ns1:getDomainNamesResponse><return>%v</return></ns1:getDomainNamesResponse>%v",
+			envStart, b.String(), envEnd)
+	case "getInfo":
+		var b strings.Builder
+		for _, e := range d.DNSEntries {
+			fmt.Fprintf(&b, "<item><name>%v</name><expire>%v</expire><type>%v</type><content>%v</content></item>",
+				escape(e.Name), e.Expire, escape(e.Type), escape(e.Content))
+		}
+		fmt.Fprintf(w, `%v<ns1:getInfoResponse><return><dnsEntries>%v</dnsEntries><isLocked>%v</isLocked>`+
+			`<registrationDate>%v</registrationDate><renewalDate>%v</renewalDate></return></ns1:getInfoResponse>%v`,
+			envStart, b.String(), d.IsLocked, d.RegistrationDate, d.RenewalDate, envEnd)
+	case "setDnsEntries":
+		d.DNSEntries = c.Entries
+		s.zones[c.Domain] = d
+		fmt.Fprintf(w, "%v<ns1:setDnsEntriesResponse/>%v", envStart, envEnd)
+	}
+}
+
+// check checks the call like the API does.
+func (s *Server) check(r *http.Request, c Call) *transip.FaultError {
+	var call transip.Call
+	switch c.Method {
+	case "getDomainNames":
+		call = transip.GetDomainNames()
+	case "getInfo":
+		call = transip.GetInfo(c.Domain)
+	case "setDnsEntries":
+		call = transip.SetDNSEntries(c.Domain, c.Entries)
+	default:
+		return &transip.FaultError{Code: "100", Message: "unsupported method " + c.Method}
+	}
+
+	if s.PublicKey != nil {
+		ts, nonce := cookie(r, "timestamp"), cookie(r, "nonce")
+		sig, _ := url.QueryUnescape(cookie(r, "signature"))
+		if s.nonces[nonce] {
+			return &transip.FaultError{Code: "200", Message: "Invalid API signature, nonce was already used."}
+		}
+		err := transip.Verify(s.PublicKey, call, r.Host, ts, nonce, sig)
+		if err != nil {
+			return &transip.FaultError{Code: "200", Message: "Invalid API signature, signature does not match the request."}
+		}
+		s.nonces[nonce] = true
+	}
+
+	if _, ok := s.zones[c.Domain]; !ok && c.Method != "getDomainNames" {
+		return &transip.FaultError{Code: "302", Message: fmt.Sprintf("Domain %v is not present in your account.", c.Domain)}
+	}
+	return nil
+}
+
+const (
+	envStart = `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="http://www.transip.nl/soap">` +
+		`<SOAP-ENV:Body>`
+	envEnd = `</SOAP-ENV:Body></SOAP-ENV:Envelope>`
+)
+
+func cookie(r *http.Request, name string) string {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,361 @@
+package transiptest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Carpetsmoker/transip-dynamic/transip"
+	"github.com/Carpetsmoker/transip-dynamic/transip/transiptest"
+)
+
+var (
+	key     *rsa.PrivateKey
+	keyOnce sync.Once
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	keyOnce.Do(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+	})
+	return key
+}
+
+func testServer(t *testing.T) *transiptest.Server {
+	t.Helper()
+	srv := transiptest.NewServer(&testKey(t).PublicKey)
+	t.Cleanup(srv.Close)
+	srv.SetDomain(transip.Domain{Name: "example.com", DNSEntries: []transip.DNSEntry{
+		{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.1"},
+		{Name: "www", Expire: 300, Type: "CNAME", Content: "@"},
+	}})
+	return srv
+}
+
+// update sets the A record of example.com to addr, like transip-dynamic does.
+func update(ctx context.Context, c *transip.Client, addr string) error {
+	entries, err := c.GetDNSEntries(ctx, "example.com")
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].Type == "A" {
+			entries[i].Content = addr
+		}
+	}
+	return c.SetDNSEntries(ctx, "example.com", entries)
+}
+
+func methods(calls []transiptest.Call) []string {
+	l := make([]string, 0, len(calls))
+	for _, c := range calls {
+		m := c.Method
+		if c.Fault != nil {
+			m += " (fault)"
+		}
+		l = append(l, m)
+	}
+	return l
+}
+
+func TestUpdate(t *testing.T) {
+	srv := testServer(t)
+	err := update(context.Background(), srv.Client("user", testKey(t)), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, _ := srv.Domain("example.com")
+	want := []transip.DNSEntry{
+		{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.2"},
+		{Name: "www", Expire: 300, Type: "CNAME", Content: "@"},
+	}
+	if !reflect.DeepEqual(d.DNSEntries, want) {
+		t.Errorf("\nhave: %v\nwant: %v", d.DNSEntries, want)
+	}
+
+	calls := srv.Calls()
+	if have, want := methods(calls), []string{"getInfo", "setDnsEntries"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("calls: %v; want %v", have, want)
+	}
+	if calls[1].Login != "user" || calls[1].Domain != "example.com" || !reflect.DeepEqual(calls[1].Entries, want) {
+		t.Errorf("wrong call: %+v", calls[1])
+	}
+}
+
+func TestUpdateWrongKey(t *testing.T) {
+	srv := testServer(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = update(context.Background(), srv.Client("user", other), "192.0.2.2")
+	var f *transip.FaultError
+	if !errors.As(err, &f) || !f.Auth() {
+		t.Fatalf("want an auth fault; have %v", err)
+	}
+	if d, _ := srv.Domain("example.com"); d.DNSEntries[0].Content != "192.0.2.1" {
+		t.Errorf("zone was changed: %v", d.DNSEntries)
+	}
+}
+
+func TestFailRetry(t *testing.T) {
+	srv := testServer(t)
+	srv.Fail("setDnsEntries",
+		transip.FaultError{Code: "100", Message: "Too many requests, try again later"},
+		transip.FaultError{Code: "100", Message: "Too many requests, try again later"})
+
+	// Retry on temporary faults, with a new signature for every attempt.
+	c := &transip.Client{Login: "user", PrivateKey: testKey(t), Endpoint: srv.Endpoint()}
+	next := c.Transport(srv.Server.Client().Transport)
+	c.HTTPClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		for i := 0; ; i++ {
+			r := req.Clone(req.Context())
+			r.Body, _ = req.GetBody()
+			resp, err := next.RoundTrip(r)
+			if err != nil || i == 3 {
+				return resp, err
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if f := transip.ParseFault(body); f == nil || !f.Temporary() {
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				return resp, nil
+			}
+		}
+	})}
+
+	err := update(context.Background(), c, "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := srv.Domain("example.com"); d.DNSEntries[0].Content != "192.0.2.2" {
+		t.Errorf("zone not changed: %v", d.DNSEntries)
+	}
+	have := methods(srv.Calls())
+	want := []string{"getInfo", "setDnsEntries (fault)", "setDnsEntries (fault)", "setDnsEntries"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+func TestFailNoRetry(t *testing.T) {
+	srv := testServer(t)
+	srv.Fail("getInfo", transip.FaultError{Code: "100", Message: "Internal error"})
+
+	err := update(context.Background(), srv.Client("user", testKey(t)), "192.0.2.2")
+	var f *transip.FaultError
+	if !errors.As(err, &f) || f.Message != "Internal error" || f.Temporary() {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	// Only the next call fails.
+	err = update(context.Background(), srv.Client("user", testKey(t)), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, want := methods(srv.Calls()), []string{"getInfo (fault)", "getInfo", "setDnsEntries"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+func TestNonceReuse(t *testing.T) {
+	srv := testServer(t)
+
+	// Keep the signed request, and send it again.
+	var signed *http.Request
+	c := &transip.Client{Login: "user", PrivateKey: testKey(t), Endpoint: srv.Endpoint()}
+	base := srv.Server.Client().Transport
+	c.HTTPClient = &http.Client{Transport: c.Transport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		signed = req
+		return base.RoundTrip(req)
+	}))}
+
+	_, err := c.GetInfo(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := signed.Clone(context.Background())
+	replay.Body, _ = signed.GetBody()
+	resp, err := base.RoundTrip(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	f := transip.ParseFault(body)
+	if f == nil || !strings.Contains(f.Message, "nonce was already used") || !f.Auth() {
+		t.Fatalf("want a fault for the nonce; have %v: %s", resp.Status, body)
+	}
+
+	// A new signature is fine.
+	_, err = c.GetInfo(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, want := methods(srv.Calls()), []string{"getInfo", "getInfo (fault)", "getInfo"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+func TestREST(t *testing.T) {
+	srv := testServer(t)
+	hc := srv.Server.Client()
+	do := func(method, path, token string, body []byte, out interface{}) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if path == "/v6/auth" {
+			h := sha512.Sum512(body)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, testKey(t), crypto.SHA512, h[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Signature", base64.StdEncoding.EncodeToString(sig))
+		}
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	var tok struct{ Token string }
+	authBody := []byte(`{"login":"user","nonce":"0123456789abcdef"}`)
+	if s := do("POST", "/v6/auth", "", authBody, &tok); s != 200 || tok.Token == "" {
+		t.Fatalf("auth: %v %q", s, tok.Token)
+	}
+	var e struct{ Error string }
+	if s := do("POST", "/v6/auth", "", authBody, &e); s != 401 || !strings.Contains(e.Error, "Nonce") {
+		t.Errorf("reused nonce: %v %q", s, e.Error)
+	}
+	if s := do("GET", "/v6/domains/example.com/dns", "wrong", nil, nil); s != 401 {
+		t.Errorf("wrong token: %v", s)
+	}
+	if s := do("GET", "/v6/domains/example.org/dns", tok.Token, nil, nil); s != 404 {
+		t.Errorf("unknown domain: %v", s)
+	}
+
+	var dns struct{ DNSEntries []transip.DNSEntry }
+	if s := do("GET", "/v6/domains/example.com/dns", tok.Token, nil, &dns); s != 200 || len(dns.DNSEntries) != 2 {
+		t.Fatalf("get: %v %v", s, dns.DNSEntries)
+	}
+	dns.DNSEntries[0].Content = "192.0.2.2"
+	put, _ := json.Marshal(dns)
+
+	srv.Fail("setDnsEntries", transip.FaultError{Message: "Rate limit exceeded"})
+	if s := do("PUT", "/v6/domains/example.com/dns", tok.Token, put, nil); s != 429 {
+		t.Errorf("Fail: %v", s)
+	}
+	if s := do("PUT", "/v6/domains/example.com/dns", tok.Token, put, nil); s != 204 {
+		t.Errorf("put: %v", s)
+	}
+	if d, _ := srv.Domain("example.com"); !reflect.DeepEqual(d.DNSEntries, dns.DNSEntries) {
+		t.Errorf("\nhave: %v\nwant: %v", d.DNSEntries, dns.DNSEntries)
+	}
+
+	have := methods(srv.Calls())
+	want := []string{"auth", "auth (fault)", "getDnsEntries (fault)", "getDnsEntries (fault)",
+		"getDnsEntries", "setDnsEntries (fault)", "setDnsEntries"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+type memTokens struct{ t transip.Token }
+
+func (m *memTokens) Load() (transip.Token, error) { return m.t, nil }
+func (m *memTokens) Store(t transip.Token) error  { m.t = t; return nil }
+
+func TestRESTClient(t *testing.T) {
+	srv := testServer(t)
+	store := &memTokens{}
+	c := srv.RESTClient("user", testKey(t))
+	c.Tokens = store
+
+	ctx := context.Background()
+	entries, err := c.GetDNSEntries(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Content = "192.0.2.2"
+	if err := c.SetDNSEntries(ctx, "example.com", entries); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := srv.Domain("example.com"); !reflect.DeepEqual(d.DNSEntries, entries) {
+		t.Errorf("\nhave: %v\nwant: %v", d.DNSEntries, entries)
+	}
+	if store.t.Token == "" {
+		t.Fatal("token not stored")
+	}
+
+	// A new client uses the stored token, but not if it's for another key.
+	c2 := srv.RESTClient("user", testKey(t))
+	c2.Tokens = store
+	if _, err := c2.GetInfo(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3 := srv.RESTClient("user", other)
+	c3.Tokens = store
+	var sErr *transip.StatusError
+	if _, err := c3.GetDNSEntries(ctx, "example.com"); !errors.As(err, &sErr) || sErr.Code != 401 {
+		t.Errorf("other key: %v", err)
+	}
+
+	// Revoked token is forgotten, and the next call gets a new one.
+	srv.Fail("getDnsEntries", transip.FaultError{Message: "Authentication failed"})
+	if _, err := c.GetDNSEntries(ctx, "example.com"); !errors.As(err, &sErr) || sErr.Code != 401 {
+		t.Errorf("revoked: %v", err)
+	}
+	if store.t.Token != "" {
+		t.Errorf("token not forgotten: %v", store.t)
+	}
+	if _, err := c.GetDNSEntries(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	have := methods(srv.Calls())
+	want := []string{"auth", "getDnsEntries", "setDnsEntries", "getDomain", "getDnsEntries",
+		"auth (fault)", "getDnsEntries (fault)", "auth", "getDnsEntries"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("calls: %v; want %v", have, want)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
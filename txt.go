@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The txt command adds or removes a single TXT record, for example as a
+// certbot or lego hook for ACME DNS-01 challenges:
+//
+//   transip-dynamic txt _acme-challenge.example.com "token" -wait 5m
+//   transip-dynamic txt -delete _acme-challenge.example.com "token"
+//
+// Unlike set, other TXT records with the same name are kept, as a certificate
+// for both example.com and *.example.com needs two challenges for the same
+// name at the same time. Without a value -delete removes all TXT records with
+// that name.
+//
+// With -wait it waits until all the authoritative nameservers serve the
+// change, or fails if they don't after the given time.
+
+const defaultTXTTTL = 60
+
+func txtRecord(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("txt", flag.ContinueOnError)
+	del := fs.Bool("delete", false, "remove the record rather than adding it")
+	ttl := fs.Int("ttl", defaultTXTTTL, "TTL in seconds")
+	wait := fs.Duration("wait", 0, "wait for this long until the nameservers serve the change")
+
+	pos, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 1 || len(pos) > 2 || (!*del && len(pos) != 2) {
+		return fmt.Errorf("usage: %[1]v txt NAME VALUE [-ttl SECONDS] [-wait DURATION]\n"+
+			"       %[1]v txt -delete NAME [VALUE] [-wait DURATION]", os.Args[0])
+	}
+	if *ttl <= 0 {
+		return fmt.Errorf("invalid TTL: %v", *ttl)
+	}
+	domain, fqdn, err := splitRecord(pos[0])
+	if err != nil {
+		return err
+	}
+	var value string
+	if len(pos) == 2 {
+		value = pos[1]
+	}
+
+	info, err := fetchDomain(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot get domain %v: %v", toUnicode(domain), err)
+	}
+
+	var (
+		newInfo = make([]Info, 0, len(info)+1)
+		removed []string
+		exists  bool
+	)
+	for _, i := range info {
+		if i.FQDN == fqdn && i.Type == "TXT" && (value == "" || i.Content == value) {
+			exists = true
+			if *del {
+				removed = append(removed, i.Content)
+				continue
+			}
+		}
+		newInfo = append(newInfo, i)
+	}
+
+	switch {
+	case *del && !exists:
+		infof("%v TXT %v doesn't exist", toUnicode(fqdn), orNone(value))
+	case !*del && exists:
+		infof("%v TXT %v already exists", toUnicode(fqdn), value)
+	default:
+		if !*del {
+			newInfo = append(newInfo, Info{Name: recordName(fqdn, domain), Expire: *ttl, Type: "TXT", Content: value})
+			setFQDN(newInfo[len(newInfo)-1:], domain)
+		}
+		err = sendUpdate(ctx, domain, newInfo)
+		if err != nil {
+			return fmt.Errorf("cannot update domain %v: %v", toUnicode(domain), err)
+		}
+		if *del {
+			infof("removed %v TXT %v", toUnicode(fqdn), strings.Join(removed, ", "))
+		} else {
+			infof("added %v TXT %v (TTL %v)", toUnicode(fqdn), value, *ttl)
+		}
+	}
+
+	if *wait > 0 {
+		return waitTXT(ctx, domain, fqdn, value, !*del, *wait)
+	}
+	return nil
+}
+
+// waitTXT waits until all nameservers for domain serve value for fqdn (or
+// stop serving it if present is false).
+func waitTXT(ctx context.Context, domain, fqdn, value string, present bool, wait time.Duration) error {
+	ns, err := nameservers(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("cannot get nameservers for %v: %v", toUnicode(domain), err)
+	}
+	if len(ns) == 0 {
+		return fmt.Errorf("no nameservers for %v", toUnicode(domain))
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		var waiting []string
+		for _, n := range ns {
+			// Errors are tried again, as the nameserver may just be busy.
+			ok, err := servesTXT(ctx, n, fqdn, value)
+			if err != nil || ok != present {
+				waiting = append(waiting, strings.TrimSuffix(n, "."))
+			}
+		}
+		if len(waiting) == 0 {
+			infof("all nameservers for %v have the change", toUnicode(domain))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%v didn't serve the change after %v", strings.Join(waiting, ", "), wait)
+		}
+		t := time.NewTimer(5 * time.Second)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// servesTXT reports if the nameserver serves value as a TXT record for fqdn,
+// or any TXT record if value is empty.
+func servesTXT(ctx context.Context, ns, fqdn, value string) (bool, error) {
+	rrs, err := dnsQuery(ctx, strings.TrimSuffix(ns, "."), fqdn, typeTXT, classIN, false)
+	if err != nil {
+		if err == errNoHost {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, rr := range rrs {
+		if rr.Type == typeTXT && (value == "" || rr.Value == value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
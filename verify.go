@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With -verify the authoritative nameservers are queried after an update
+// until they all serve the new addresses, as the API accepting the change
+// doesn't mean it's actually published:
+//
+//   transip-dynamic -verify -verify-timeout 5m
+//
+// The update fails if they don't have the change after -verify-timeout.
+
+var (
+	verify        bool
+	verifyTimeout time.Duration
+)
+
+// The TransIP nameservers, used if we can't look up the nameservers for the
+// domain.
+var transipNameservers = []string{"ns0.transip.net.", "ns1.transip.nl.", "ns2.transip.eu."}
+
+// verifyChanges waits until all nameservers for domain serve the A and AAAA
+// records in changes.
+func verifyChanges(ctx context.Context, domain string, changes []planChange) error {
+	var check []planChange
+	for _, c := range changes {
+		if (c.Type == "A" || c.Type == "AAAA") && c.New != "" {
+			check = append(check, c)
+		}
+	}
+	if !verify || len(check) == 0 || mockMode == "replay" {
+		return nil
+	}
+
+	ns, err := nameservers(ctx, domain)
+	if err != nil || len(ns) == 0 {
+		warnf("cannot get nameservers for %v (%v); using the TransIP nameservers",
+			toUnicode(domain), err)
+		ns = transipNameservers
+	}
+
+	deadline := time.Now().Add(verifyTimeout)
+	for {
+		var waiting []string
+		for i := 0; i < len(check); i++ {
+			c := check[i]
+			qtype := uint16(typeA)
+			if c.Type == "AAAA" {
+				qtype = typeAAAA
+			}
+
+			// Round-robin records have several addresses.
+			_, multi := config.RoundRobin[c.FQDN]
+			multi = multi || manualAll != nil
+
+			var wrong []string
+			for _, n := range ns {
+				if s := serves(ctx, n, c.FQDN, qtype); s != c.New && !(multi && inList(strings.Split(s, ", "), c.New)) {
+					wrong = append(wrong, fmt.Sprintf("%v serves %v", strings.TrimSuffix(n, "."), s))
+				}
+			}
+			if len(wrong) == 0 {
+				infof("verified %v %v %v on all nameservers", toUnicode(c.FQDN), c.Type, c.New)
+				check = append(check[:i], check[i+1:]...)
+				i--
+				continue
+			}
+			waiting = append(waiting, fmt.Sprintf("%v %v (%v)", toUnicode(c.FQDN), c.Type, strings.Join(wrong, ", ")))
+		}
+		if len(waiting) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			sort.Strings(waiting)
+			return fmt.Errorf("updated, but not verified after %v: %v", verifyTimeout, strings.Join(waiting, "; "))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// With -via user@host the API connections are made through SSH (with "ssh -W"),
+// for hosts that can't connect to the API directly. This uses the ssh command
+// so that ~/.ssh/config, agents, and known_hosts all work as usual.
+
+// Set from the -via flag.
+var via string
+
+// viaTransport gets a copy of the transport t which connects through SSH.
+func viaTransport(t http.RoundTripper) (http.RoundTripper, error) {
+	ht, ok := t.(*http.Transport)
+	if !ok {
+		return nil, errors.New("-via: unsupported transport")
+	}
+	ht = ht.Clone()
+	ht.Proxy = nil
+	ht.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialSSH(via, addr)
+	}
+	return ht, nil
+}
+
+// dialSSH connects to addr through host.
+func dialSSH(host, addr string) (net.Conn, error) {
+	cmd := exec.Command("ssh", "-W", addr,
+		"-o", "BatchMode=yes", "-o", "ExitOnForwardFailure=yes", "--", host)
+	cmd.Stderr = os.Stderr
+
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &sshConn{cmd: cmd, r: r, w: w, addr: viaAddr(addr)}, nil
+}
+
+// sshConn is a connection over the stdin and stdout of ssh.
+type sshConn struct {
+	cmd  *exec.Cmd
+	r    io.ReadCloser
+	w    io.WriteCloser
+	addr viaAddr
+}
+
+func (c *sshConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *sshConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *sshConn) Close() error {
+	c.w.Close()
+	c.r.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *sshConn) LocalAddr() net.Addr  { return viaAddr("ssh:" + via) }
+func (c *sshConn) RemoteAddr() net.Addr { return c.addr }
+
+// Deadlines aren't supported on pipes; the HTTP client's timeouts still work.
+func (c *sshConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sshConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sshConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type viaAddr string
+
+func (a viaAddr) Network() string { return "ssh" }
+func (a viaAddr) String() string  { return string(a) }
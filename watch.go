@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The watch command shows the detected IP, what the nameservers serve for
+// every record, and when it was last updated, and refreshes this every few
+// seconds (30s by default):
+//
+//   transip-dynamic watch
+//   transip-dynamic watch 10s
+//
+// It never changes anything. This is useful to see what's going on while
+// the connection is changed or the ISP is having trouble.
+
+type watchRecord struct {
+	FQDN, Type string
+	Want       string
+	Published  published
+	Served     [][2]string // Nameserver and value.
+}
+
+type watchState struct {
+	Checked time.Time
+	IP      *ipT
+	IPErr   error
+	Records []watchRecord
+	Errors  []string
+}
+
+func watch(interval string) error {
+	ctx := context.Background()
+	every := 30 * time.Second
+	if interval != "" {
+		var err error
+		every, err = time.ParseDuration(interval)
+		if err != nil {
+			return err
+		}
+		if every < time.Second {
+			return fmt.Errorf("interval must be at least 1s, not %v", every)
+		}
+	}
+
+	fi, err := os.Stdout.Stat()
+	tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+
+	var w watchState
+	for {
+		if time.Since(w.Checked) >= every {
+			w = watchCheck(ctx)
+		}
+		next := every - time.Since(w.Checked)
+
+		if tty {
+			fmt.Print("\x1b[H\x1b[2J") // Move to top and clear.
+			fmt.Print(w.String(next.Round(time.Second)))
+			time.Sleep(time.Second)
+			continue
+		}
+		// Don't redraw every second if it's not a terminal.
+		fmt.Print(w.String(0), "\n")
+		time.Sleep(next)
+	}
+}
+
+// watchCheck gets the current IP and what the nameservers serve.
+func watchCheck(ctx context.Context) watchState {
+	w := watchState{Checked: time.Now()}
+	w.IP, _, _, w.IPErr = ipFromServices(ctx, config.GetIP)
+	if w.IPErr == nil {
+		resolveSources(ctx)
+	}
+
+	stateMu.Lock()
+	st := readState()
+	stateMu.Unlock()
+
+	for _, domain := range sortedDomains() {
+		ns, err := nameservers(ctx, domain)
+		if err != nil {
+			// Printed with the rest, as it would be cleared right away.
+			w.Errors = append(w.Errors, fmt.Sprintf("cannot get nameservers for %v: %v", toUnicode(domain), err))
+		}
+
+		for _, record := range config.Records[domain] {
+			var rip ipT
+			if w.IP != nil {
+				var ok bool
+				rip, ok = ipForRecord(record, *w.IP)
+				if !ok { // Source failed; don't show the get-ip address.
+					rip = ipT{}
+				}
+			}
+			for _, t := range []string{"A", "AAAA"} {
+				r := watchRecord{FQDN: record, Type: t, Want: rip.IPv4, Published: st.Published[record+" "+t]}
+				qtype := uint16(typeA)
+				if t == "AAAA" {
+					r.Want, qtype = rip.IPv6, typeAAAA
+				}
+				for _, n := range ns {
+					r.Served = append(r.Served, [2]string{n, serves(ctx, n, record, qtype)})
+				}
+				w.Records = append(w.Records, r)
+			}
+		}
+	}
+	return w
+}
+
+func (w watchState) String(next time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "transip-dynamic watch   %v\n\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	switch {
+	case w.IPErr != nil:
+		fmt.Fprintf(&b, "Detected IP:  error: %v\n", w.IPErr)
+	default:
+		fmt.Fprintf(&b, "Detected IP:  IPv4 %v, IPv6 %v\n", orNone(w.IP.IPv4), orNone(w.IP.IPv6))
+	}
+
+	stateMu.Lock()
+	last := readState().LastIP
+	stateMu.Unlock()
+	if !last.Changed.IsZero() {
+		fmt.Fprintf(&b, "Last change:  %v (%v ago)\n", last.Changed.Format("2006-01-02 15:04:05"),
+			time.Since(last.Changed).Round(time.Second))
+	}
+	b.WriteByte('\n')
+
+	for _, r := range w.Records {
+		if r.Want == "" && r.Published.Content == "" && allNone(r.Served) {
+			continue
+		}
+		fmt.Fprintf(&b, "%v %v\n", toUnicode(r.FQDN), r.Type)
+
+		lines := [][2]string{{"detected", orNone(r.Want)}}
+		if r.Published.Content != "" {
+			lines = append(lines, [2]string{"published", fmt.Sprintf("%v at %v", r.Published.Content,
+				r.Published.Time.Format("2006-01-02 15:04:05"))})
+		}
+		lines = append(lines, r.Served...)
+		for i, l := range lines {
+			mark := " "
+			if i > 0 && r.Want != "" && strings.SplitN(l[1], " at ", 2)[0] != r.Want {
+				mark = "!"
+			}
+			fmt.Fprintf(&b, "  %v %-24v %v\n", mark, l[0], l[1])
+		}
+	}
+
+	for _, e := range w.Errors {
+		fmt.Fprintf(&b, "\nwarning: %v", e)
+	}
+	if len(w.Errors) > 0 {
+		b.WriteByte('\n')
+	}
+
+	if next > 0 {
+		fmt.Fprintf(&b, "\nNext check in %v; ^C to stop\n", next)
+	}
+	return b.String()
+}
+
+func allNone(served [][2]string) bool {
+	for _, s := range served {
+		if s[1] != orNone("") {
+			return false
+		}
+	}
+	return true
+}
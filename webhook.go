@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This implements the external-dns webhook provider API, so that a Kubernetes
+// cluster can manage TransIP records with this program running as a sidecar.
+// See:
+// https://kubernetes-sigs.github.io/external-dns/latest/docs/tutorials/webhook-provider/
+
+const (
+	webhookMediaType = "application/external.dns.webhook+json;version=1"
+
+	// TTL to use if external-dns doesn't set one.
+	webhookDefaultTTL = 300
+)
+
+// endpoint is a single DNS name with one or more targets, as used by
+// external-dns.
+type endpoint struct {
+	DNSName          string             `json:"dnsName"`
+	Targets          []string           `json:"targets"`
+	RecordType       string             `json:"recordType"`
+	SetIdentifier    string             `json:"setIdentifier,omitempty"`
+	RecordTTL        int64              `json:"recordTTL,omitempty"`
+	Labels           map[string]string  `json:"labels,omitempty"`
+	ProviderSpecific []providerSpecific `json:"providerSpecific,omitempty"`
+}
+
+type providerSpecific struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// changes is a set of changes external-dns wants us to apply.
+type changes struct {
+	Create    []*endpoint `json:"Create"`
+	UpdateOld []*endpoint `json:"UpdateOld"`
+	UpdateNew []*endpoint `json:"UpdateNew"`
+	Delete    []*endpoint `json:"Delete"`
+}
+
+// Serialize the changes, as we need to send back the entire zone; two POSTs at
+// the same time would otherwise overwrite each other's records.
+var webhookMu sync.Mutex
+
+// domainFilter tells external-dns which zones we manage.
+type domainFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// webhookZones gets the list of zones to manage; this is the Zones setting, or
+// all the domains from Records if it's not set.
+func webhookZones() []string {
+	if len(config.Zones) > 0 {
+		return config.Zones
+	}
+
+	return sortedDomains()
+}
+
+// findZone finds the zone name belongs in, or "" if it's not in any of them.
+func findZone(zones []string, name string) string {
+	name = strings.ToLower(strings.TrimRight(name, "."))
+	zone := ""
+	for _, z := range zones {
+		z = strings.ToLower(strings.TrimRight(z, "."))
+		if (name == z || strings.HasSuffix(name, "."+z)) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	return zone
+}
+
+func serveWebhook() error {
+	memoryCache = true
+
+	zones := webhookZones()
+	if len(zones) == 0 {
+		return errors.New("no zones to manage; set Zones or Records in the config")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		writeWebhook(w, http.StatusOK, domainFilter{Include: zones})
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			eps, err := webhookRecords(r.Context(), zones)
+			if err != nil {
+				webhookError(w, err)
+				return
+			}
+			writeWebhook(w, http.StatusOK, eps)
+		case http.MethodPost:
+			var c changes
+			err := json.NewDecoder(r.Body).Decode(&c)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = applyChanges(r.Context(), zones, c)
+			if err != nil {
+				webhookError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/adjustendpoints", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var eps []*endpoint
+		err := json.NewDecoder(r.Body).Decode(&eps)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ep := range eps {
+			if ep.RecordTTL == 0 {
+				ep.RecordTTL = webhookDefaultTTL
+			}
+		}
+		writeWebhook(w, http.StatusOK, eps)
+	})
+
+	infof("external-dns webhook listening on %v",
+		config.WebhookListen)
+	return http.ListenAndServe(config.WebhookListen, configLocked(mux))
+}
+
+func writeWebhook(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", webhookMediaType)
+	w.Header().Set("Vary", "Content-Type")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func webhookError(w http.ResponseWriter, err error) {
+	warnf("webhook: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// webhookRecords gets all records in zones as external-dns endpoints; records
+// with the same name and type are grouped in a single endpoint.
+func webhookRecords(ctx context.Context, zones []string) ([]*endpoint, error) {
+	var eps []*endpoint
+	for _, zone := range zones {
+		info, err := getDomain(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get domain %v: %v", zone, err)
+		}
+
+		seen := make(map[string]*endpoint)
+		for _, i := range info {
+			k := i.FQDN + " " + i.Type
+			if ep, ok := seen[k]; ok {
+				ep.Targets = append(ep.Targets, i.Content)
+				continue
+			}
+
+			ep := &endpoint{
+				DNSName:    strings.TrimRight(i.FQDN, "."),
+				Targets:    []string{i.Content},
+				RecordType: i.Type,
+				RecordTTL:  int64(i.Expire),
+			}
+			seen[k] = ep
+			eps = append(eps, ep)
+		}
+	}
+	return eps, nil
+}
+
+// applyChanges applies the changes from external-dns; every zone is fetched
+// and sent back once, in sorted order.
+func applyChanges(ctx context.Context, zones []string, c changes) error {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+
+	infos := make(map[string][]Info)
+	get := func(ep *endpoint) (string, error) {
+		zone := findZone(zones, ep.DNSName)
+		if zone == "" {
+			return "", fmt.Errorf("%v is not in any of the zones %v", ep.DNSName, zones)
+		}
+		if _, ok := infos[zone]; ok {
+			return zone, nil
+		}
+
+		info, err := fetchDomain(ctx, zone)
+		if err != nil {
+			return "", fmt.Errorf("cannot get domain %v: %v", zone, err)
+		}
+		infos[zone] = info
+		return zone, nil
+	}
+
+	remove := func(eps []*endpoint) error {
+		for _, ep := range eps {
+			zone, err := get(ep)
+			if err != nil {
+				return err
+			}
+
+			name := recordName(ep.DNSName, zone)
+			info := infos[zone][:0]
+			for _, i := range infos[zone] {
+				if strings.EqualFold(i.Name, name) && i.Type == ep.RecordType && inList(ep.Targets, i.Content) {
+					continue
+				}
+				info = append(info, i)
+			}
+			infos[zone] = info
+		}
+		return nil
+	}
+
+	add := func(eps []*endpoint) error {
+		for _, ep := range eps {
+			zone, err := get(ep)
+			if err != nil {
+				return err
+			}
+
+			ttl := int(ep.RecordTTL)
+			if ttl == 0 {
+				ttl = webhookDefaultTTL
+			}
+			for _, t := range ep.Targets {
+				infos[zone] = append(infos[zone], Info{
+					Name:    recordName(ep.DNSName, zone),
+					Expire:  ttl,
+					Type:    ep.RecordType,
+					Content: t,
+					FQDN:    strings.ToLower(strings.TrimRight(ep.DNSName, ".")) + ".",
+				})
+			}
+		}
+		return nil
+	}
+
+	for _, f := range []func() error{
+		func() error { return remove(c.Delete) },
+		func() error { return remove(c.UpdateOld) },
+		func() error { return add(c.Create) },
+		func() error { return add(c.UpdateNew) },
+	} {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+
+	send := make([]string, 0, len(infos))
+	for zone := range infos {
+		send = append(send, zone)
+	}
+	sort.Strings(send)
+	for _, zone := range send {
+		err := sendUpdate(ctx, zone, infos[zone])
+		if err != nil {
+			return fmt.Errorf("cannot update domain %v: %v", zone, err)
+		}
+	}
+	return nil
+}
+
+func inList(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// With zone-file the entire zone is described in a file, and plan and apply
+// will add, change, and delete records to make the zone match it:
+//
+//   zone-file example.com zones/example.com
+//
+// The format is a subset of the BIND zone file format, with a record on every
+// line and comments starting with ";" (at the start of a line or after a
+// space):
+//
+//   $TTL 3600
+//   @                  IN  MX   10 mail.example.com.
+//   www.example.com.   300 IN  A    203.0.113.5
+//   mail               IN  CNAME example.com.
+//
+// Names are relative to the domain (or $ORIGIN) unless they end with a ".", and
+// the TTL and "IN" are optional. Quoted strings and parentheses work as in
+// BIND, and SOA records are skipped as TransIP manages those. The output of the
+// list and export commands can be used as-is.
+//
+// The A and AAAA records for the records from the config are always set to the
+// current address; they don't need to be in the zone file, and the address in
+// the zone file is ignored if they are.
+
+// readZoneFile reads the records for domain from a zone file.
+func readZoneFile(domain, path string) ([]Info, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseZoneFile(domain, data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return info, nil
+}
+
+// parseZoneFile parses a zone file for domain.
+func parseZoneFile(domain string, data []byte) ([]Info, error) {
+	var (
+		info   []Info
+		ttl    = 300
+		origin = domain
+		owner  string
+		scan   = bufio.NewScanner(bytes.NewReader(data))
+		n      = 0
+	)
+	for scan.Scan() {
+		n++
+		raw := scan.Text()
+		line, depth := stripComment(raw)
+		// Records in parentheses can span several lines.
+		for start := n; depth > 0; {
+			if !scan.Scan() {
+				return nil, fmt.Errorf("line %d: no closing parenthesis", start)
+			}
+			n++
+			l, d := stripComment(scan.Text())
+			line, depth = line+" "+l, depth+d
+		}
+		f := zoneFields(line)
+		if len(f) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(f[0]) {
+		case "$TTL":
+			if len(f) != 2 {
+				return nil, fmt.Errorf("line %d: need a single TTL after $TTL", n)
+			}
+			t, err := strconv.Atoi(f[1])
+			if err != nil || t < 60 {
+				return nil, fmt.Errorf("line %d: TTL must be a number of seconds of at least 60, not %q", n, f[1])
+			}
+			ttl = t
+			continue
+		case "$ORIGIN":
+			if len(f) != 2 || !strings.HasSuffix(f[1], ".") {
+				return nil, fmt.Errorf("line %d: need a single name ending with a . after $ORIGIN", n)
+			}
+			o, err := toASCII(strings.ToLower(strings.TrimSuffix(f[1], ".")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			if o != domain && !strings.HasSuffix(o, "."+domain) {
+				return nil, fmt.Errorf("line %d: $ORIGIN %v is not in %v", n, f[1], toUnicode(domain))
+			}
+			origin = o
+			continue
+		}
+		if strings.HasPrefix(f[0], "$") {
+			return nil, fmt.Errorf("line %d: unsupported directive %v", n, f[0])
+		}
+
+		// A line starting with whitespace is for the previous name.
+		if raw[0] != ' ' && raw[0] != '\t' {
+			name, err := zoneName(domain, origin, f[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			owner, f = name, f[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: no name", n)
+		}
+
+		// The TTL and class can be in either order.
+		e := Info{Name: owner, Expire: ttl}
+		for i := 0; i < 2 && len(f) > 0; i++ {
+			if t, err := strconv.Atoi(f[0]); err == nil {
+				if t < 60 {
+					return nil, fmt.Errorf("line %d: TTL must be at least 60 seconds, not %d", n, t)
+				}
+				e.Expire, f = t, f[1:]
+			} else if strings.EqualFold(f[0], "IN") {
+				f = f[1:]
+			}
+		}
+		if len(f) < 2 {
+			return nil, fmt.Errorf("line %d: need a type and content", n)
+		}
+
+		e.Type = strings.ToUpper(f[0])
+		switch {
+		case e.Type == "SOA":
+			// Managed by TransIP.
+			continue
+		case !inList(recordTypes, e.Type):
+			return nil, fmt.Errorf("line %d: unsupported record type %v", n, f[0])
+		case e.Type == "TXT":
+			e.Content = unquoteTXT(f[1:])
+		default:
+			e.Content = strings.Join(f[1:], " ")
+		}
+		info = append(info, e)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	setFQDN(info, domain)
+	return info, nil
+}
+
+// stripComment removes a comment from a line in a zone file, and returns the
+// number of opened minus the number of closed parentheses. A ";" is only a
+// comment if it's not quoted and at the start or after whitespace, as unquoted
+// TXT records such as DKIM keys often contain a ";".
+func stripComment(line string) (string, int) {
+	var (
+		quoted, esc bool
+		depth       int
+	)
+	for i, c := range line {
+		switch {
+		case esc:
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ';' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], depth
+		}
+	}
+	return line, depth
+}
+
+// zoneFields splits a line in a zone file on whitespace and parentheses;
+// quoted strings are kept together, with the quotes.
+func zoneFields(line string) []string {
+	var (
+		f           []string
+		b           strings.Builder
+		quoted, esc bool
+	)
+	for _, c := range line {
+		switch {
+		case esc:
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '(' || c == ')'):
+			if b.Len() > 0 {
+				f = append(f, b.String())
+				b.Reset()
+			}
+			continue
+		}
+		b.WriteRune(c)
+	}
+	if b.Len() > 0 {
+		f = append(f, b.String())
+	}
+	return f
+}
+
+// unquoteTXT gets the content for a TXT record; if it's quoted all the strings
+// are joined, as the API only accepts a single string.
+func unquoteTXT(f []string) string {
+	if !strings.HasPrefix(f[0], `"`) {
+		return strings.Join(f, " ")
+	}
+	var b strings.Builder
+	for _, s := range f {
+		s = strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// quoteTXT quotes the content of a TXT record for a zone file, split in
+// strings of at most 255 bytes.
+func quoteTXT(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	var parts []string
+	for len(s) > 255 {
+		// Don't split an escape.
+		i, bs := 255, 0
+		for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+			bs++
+		}
+		if bs%2 == 1 {
+			i--
+		}
+		parts, s = append(parts, `"`+s[:i]+`"`), s[i:]
+	}
+	return strings.Join(append(parts, `"`+s+`"`), " ")
+}
+
+// zoneName gets the name as used by the API for a name in a zone file.
+func zoneName(domain, origin, name string) (string, error) {
+	switch {
+	case name == "@":
+		name = origin + "."
+	case !strings.HasSuffix(name, "."):
+		name += "." + origin + "."
+	}
+
+	_, fqdn, err := splitRecord(name)
+	if err != nil {
+		return "", err
+	}
+	if fqdn != domain+"." && !strings.HasSuffix(fqdn, "."+domain+".") {
+		return "", fmt.Errorf("%v is not in %v", toUnicode(strings.TrimSuffix(fqdn, ".")), toUnicode(domain))
+	}
+	return recordName(fqdn, domain), nil
+}
+
+// planZoneFile works out the changes to make the zone in info match the zone
+// file, with the records from the config set to ip.
+func planZoneFile(domain, path string, info []Info, ip ipT) ([]Info, []planChange, error) {
+	want, err := readZoneFile(domain, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the current A and AAAA records for the records from the config if
+	// they're not in the zone file, so planDomain can update them.
+	records := config.Records[domain]
+	for _, i := range info {
+		if (i.Type != "A" && i.Type != "AAAA") || !inList(records, i.FQDN) {
+			continue
+		}
+		found := false
+		for _, w := range want {
+			if w.FQDN == i.FQDN && w.Type == i.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			want = append(want, i)
+		}
+	}
+
+	want, _, err = planDomain(records, want, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	want, _ = addSRV(domain, want)
+	want, _ = addStatic(domain, want)
+	return want, diffZone(info, want), nil
+}
+
+// diffZone gets the changes from the records in have to the records in want.
+// Records with the same name and type are changed rather than deleted and
+// added where possible.
+func diffZone(have, want []Info) []planChange {
+	type key struct{ fqdn, typ string }
+	var (
+		from  = make(map[key][]Info)
+		to    = make(map[key][]Info)
+		names []key
+	)
+	for _, i := range have {
+		k := key{i.FQDN, i.Type}
+		if _, ok := from[k]; !ok {
+			names = append(names, k)
+		}
+		from[k] = append(from[k], i)
+	}
+	for _, i := range want {
+		k := key{i.FQDN, i.Type}
+		_, inFrom := from[k]
+		if _, inTo := to[k]; !inFrom && !inTo {
+			names = append(names, k)
+		}
+		to[k] = append(to[k], i)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].fqdn == names[j].fqdn {
+			return names[i].typ < names[j].typ
+		}
+		return names[i].fqdn < names[j].fqdn
+	})
+
+	// Remove the records that are the same in both.
+	without := func(l []Info, i Info) ([]Info, bool) {
+		for j := range l {
+			if l[j].Content == i.Content && l[j].Expire == i.Expire {
+				return append(l[:j:j], l[j+1:]...), true
+			}
+		}
+		return l, false
+	}
+
+	var changes []planChange
+	for _, k := range names {
+		o, n := []Info(nil), to[k]
+		for _, i := range from[k] {
+			var same bool
+			if n, same = without(n, i); !same {
+				o = append(o, i)
+			}
+		}
+
+		for len(o) > 0 || len(n) > 0 {
+			c := planChange{FQDN: k.fqdn, Type: k.typ}
+			switch {
+			case len(o) > 0 && len(n) > 0:
+				c.Old, c.New = o[0].Content, n[0].Content
+				if o[0].Expire != n[0].Expire {
+					c.OldTTL, c.TTL = o[0].Expire, n[0].Expire
+				}
+				o, n = o[1:], n[1:]
+			case len(o) > 0:
+				c.Old, c.OldTTL = o[0].Content, o[0].Expire
+				o = o[1:]
+			default:
+				c.New = n[0].Content
+				n = n[1:]
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseZoneFile(t *testing.T) {
+	config = configT{}
+	tests := []struct {
+		name, in string
+		want     []Info
+		wantErr  string
+	}{
+		{"simple", `
+$TTL 3600
+@                  IN  MX   10 mail.example.com.
+www.example.com.   300 IN  A    203.0.113.5
+mail               IN  CNAME example.com.
+`, []Info{
+			{"@", 3600, "MX", "10 mail.example.com.", "example.com."},
+			{"www", 300, "A", "203.0.113.5", "www.example.com."},
+			{"mail", 3600, "CNAME", "example.com.", "mail.example.com."},
+		}, ""},
+
+		{"defaults", "home A 192.0.2.1\nHOME in aaaa 2001:db8::1\n", []Info{
+			{"home", 300, "A", "192.0.2.1", "home.example.com."},
+			{"home", 300, "AAAA", "2001:db8::1", "home.example.com."},
+		}, ""},
+
+		{"TTL and class in either order", "a IN 600 A 192.0.2.1\nb 600 IN A 192.0.2.2\n", []Info{
+			{"a", 600, "A", "192.0.2.1", "a.example.com."},
+			{"b", 600, "A", "192.0.2.2", "b.example.com."},
+		}, ""},
+
+		{"previous name", "www A 192.0.2.1\n  AAAA 2001:db8::1\n\tTXT hello\n", []Info{
+			{"www", 300, "A", "192.0.2.1", "www.example.com."},
+			{"www", 300, "AAAA", "2001:db8::1", "www.example.com."},
+			{"www", 300, "TXT", "hello", "www.example.com."},
+		}, ""},
+
+		{"origin", "$ORIGIN sub.example.com.\nwww A 192.0.2.1\n@ A 192.0.2.2\nx.example.com. A 192.0.2.3\n", []Info{
+			{"www.sub", 300, "A", "192.0.2.1", "www.sub.example.com."},
+			{"sub", 300, "A", "192.0.2.2", "sub.example.com."},
+			{"x", 300, "A", "192.0.2.3", "x.example.com."},
+		}, ""},
+
+		{"comments", "; comment\nwww A 192.0.2.1 ; comment\n_dkim TXT v=DKIM1;k=rsa;p=abc\n", []Info{
+			{"www", 300, "A", "192.0.2.1", "www.example.com."},
+			{"_dkim", 300, "TXT", "v=DKIM1;k=rsa;p=abc", "_dkim.example.com."},
+		}, ""},
+
+		{"quoted TXT", `txt TXT "a \"quoted\" ; string" "and \\ more"` + "\n", []Info{
+			{"txt", 300, "TXT", `a "quoted" ; stringand \ more`, "txt.example.com."},
+		}, ""},
+
+		{"parentheses", "big TXT ( \"first\" ; comment\n   \"second\" )\n@ SOA ns.example.com. host.example.com. (\n 1 2 3 4 5 )\nmx MX ( 10\n mail.example.com. )\n", []Info{
+			{"big", 300, "TXT", "firstsecond", "big.example.com."},
+			{"mx", 300, "MX", "10 mail.example.com.", "mx.example.com."},
+		}, ""},
+
+		{"IDN", "bücher A 192.0.2.1\n", []Info{
+			{"xn--bcher-kva", 300, "A", "192.0.2.1", "xn--bcher-kva.example.com."},
+		}, ""},
+
+		{"empty", "\n; only a comment\n\n", nil, ""},
+
+		{"bad $TTL", "$TTL 1h\n", nil, "line 1: TTL must be a number"},
+		{"$TTL too short", "$TTL 30\n", nil, "line 1: TTL must be a number of seconds of at least 60"},
+		{"$TTL without value", "\n$TTL\n", nil, "line 2: need a single TTL"},
+		{"TTL too short", "www 30 A 192.0.2.1\n", nil, "line 1: TTL must be at least 60 seconds"},
+		{"relative $ORIGIN", "$ORIGIN sub\n", nil, "line 1: need a single name ending with a ."},
+		{"$ORIGIN in another domain", "$ORIGIN example.net.\n", nil, "line 1: $ORIGIN example.net. is not in example.com"},
+		{"directive", "$INCLUDE other.zone\n", nil, "line 1: unsupported directive $INCLUDE"},
+		{"no name", "  A 192.0.2.1\n", nil, "line 1: no name"},
+		{"no content", "www A\n", nil, "line 1: need a type and content"},
+		{"unsupported type", "www HINFO x y\n", nil, "line 1: unsupported record type HINFO"},
+		{"name in another domain", "www.example.net. A 192.0.2.1\n", nil, "line 1: www.example.net is not in example.com"},
+		{"no closing parenthesis", "www A 192.0.2.1\nbig TXT (\n \"first\"\n", nil, "line 2: no closing parenthesis"},
+		{"line number after parentheses", "big TXT (\n \"first\" )\nwww A\n", nil, "line 3: need a type and content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have, err := parseZoneFile("example.com", []byte(tt.in))
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  \t ", nil},
+		{"www IN A 192.0.2.1", []string{"www", "IN", "A", "192.0.2.1"}},
+		{"www\tIN  A\t 192.0.2.1 ", []string{"www", "IN", "A", "192.0.2.1"}},
+		{`txt TXT "a b" "c"`, []string{"txt", "TXT", `"a b"`, `"c"`}},
+		{`txt TXT "a \" b"`, []string{"txt", "TXT", `"a \" b"`}},
+		{`txt TXT "a (b)"`, []string{"txt", "TXT", `"a (b)"`}},
+		{"mx MX (10 mail)", []string{"mx", "MX", "10", "mail"}},
+		{"a\\ b c", []string{"a\\ b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have := zoneFields(tt.in)
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnquoteTXT(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"hello"}, "hello"},
+		{[]string{"v=spf1", "-all"}, "v=spf1 -all"},
+		{[]string{`"hello world"`}, "hello world"},
+		{[]string{`"a"`, `"b"`}, "ab"},
+		{[]string{`""`}, ""},
+		{[]string{`"a \"b\""`}, `a "b"`},
+		{[]string{`"a\\b"`}, `a\b`},
+		{[]string{`"trailing\"`}, `trailing\`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.in, " "), func(t *testing.T) {
+			have := unquoteTXT(tt.in)
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneName(t *testing.T) {
+	config = configT{}
+	tests := []struct {
+		origin, in, want, wantErr string
+	}{
+		{"example.com", "@", "@", ""},
+		{"example.com", "www", "www", ""},
+		{"example.com", "WWW", "www", ""},
+		{"example.com", "a.b", "a.b", ""},
+		{"example.com", "*", "*", ""},
+		{"example.com", "www.example.com.", "www", ""},
+		{"example.com", "example.com.", "@", ""},
+		{"sub.example.com", "@", "sub", ""},
+		{"sub.example.com", "www", "www.sub", ""},
+		{"sub.example.com", "www.example.com.", "www", ""},
+		{"example.com", "bücher", "xn--bcher-kva", ""},
+
+		{"example.com", "www.example.net.", "", "www.example.net is not in example.com"},
+		{"example.com", "com.", "", "doesn't look like a valid FQDN"},
+		{"example.com", "a..b", "", "doesn't look like a valid FQDN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin+" "+tt.in, func(t *testing.T) {
+			have, err := zoneName("example.com", tt.origin, tt.in)
+			if !errorContains(err, tt.wantErr) {
+				t.Fatalf("wrong error\nhave: %v\nwant: %v", err, tt.wantErr)
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneFileRoundTrip(t *testing.T) {
+	config = configT{}
+	info := []Info{
+		{Name: "@", Expire: 300, Type: "A", Content: "192.0.2.1"},
+		{Name: "@", Expire: 3600, Type: "MX", Content: "10 mail.example.com."},
+		{Name: "@", Expire: 3600, Type: "TXT", Content: "v=spf1 mx -all"},
+		{Name: "home", Expire: 60, Type: "AAAA", Content: "2001:db8::1"},
+		{Name: "www", Expire: 300, Type: "CNAME", Content: "@"},
+		{Name: "_sip._udp", Expire: 300, Type: "SRV", Content: "10 20 5060 home.example.com."},
+		{Name: "_dkim", Expire: 300, Type: "TXT", Content: "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjAN", 50)},
+		{Name: "quote", Expire: 300, Type: "TXT", Content: `a "quoted" \ string ; not a comment (or parentheses)`},
+		{Name: "split", Expire: 300, Type: "TXT", Content: strings.Repeat("x", 254) + `\"` + strings.Repeat("y", 300)},
+		{Name: "empty", Expire: 300, Type: "TXT", Content: ""},
+		{Name: "xn--bcher-kva", Expire: 300, Type: "A", Content: "192.0.2.2"},
+		{Name: "*", Expire: 300, Type: "A", Content: "192.0.2.3"},
+	}
+	setFQDN(info, "example.com")
+
+	zone := zoneFile("example.com", info, "test")
+	have, err := parseZoneFile("example.com", zone)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, zone)
+	}
+	if d := diffZone(info, have); len(d) > 0 {
+		t.Errorf("not the same after a round trip:\n%v\n%s", d, zone)
+	}
+	if !reflect.DeepEqual(have, info) {
+		t.Errorf("\nhave: %v\nwant: %v", have, info)
+	}
+}